@@ -55,7 +55,7 @@ go get golang.org/x/tools/go/ssa
 go get golang.org/x/tools/go/ssa/ssautil
 
 echo "Building compiler..."
-go build -o "$BUILD_DIR/gonim-compile" main.go
+go build -o "$BUILD_DIR/gonim-compile" .
 echo -e "${GREEN}✓${NC} Go compiler frontend built successfully"
 echo ""
 
@@ -193,6 +193,7 @@ if [ ! -e "$INPUT_PATH" ]; then
 fi
 
 IR_FILE="$OUTPUT_DIR/ir.json"
+IR_CACHE="${GONIM_CACHE:-$OUTPUT_DIR/.ir_cache}"
 
 transpile() {
     log_info "Transpiling Go package: $INPUT_PATH"
@@ -200,9 +201,9 @@ transpile() {
     # Step 1: Generate IR
     log_info "Generating intermediate representation..."
     if [ -n "$VERBOSE" ]; then
-        "$COMPILE_BIN" -input="$INPUT_PATH" -output="$IR_FILE" -v
+        "$COMPILE_BIN" ir -input="$INPUT_PATH" -output="$IR_FILE" -cache="$IR_CACHE" -v
     else
-        "$COMPILE_BIN" -input="$INPUT_PATH" -output="$IR_FILE"
+        "$COMPILE_BIN" ir -input="$INPUT_PATH" -output="$IR_FILE" -cache="$IR_CACHE"
     fi
     
     if [ $? -ne 0 ]; then
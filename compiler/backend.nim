@@ -1,4 +1,4 @@
-import json, strutils, tables, sets, os, algorithm, strformat
+import json, strutils, tables, sets, os, algorithm, strformat, options
 
 type
   HybridIR = object
@@ -29,6 +29,9 @@ type
     underlying: string
     signature: FuncSignature
     type_params: seq[TypeParam]
+    exported: bool
+    doc: string
+    deprecated: string
 
   TypeParam = object
     name: string
@@ -46,6 +49,17 @@ type
     body: BodyIR
     is_method: bool
     package: string
+    exported: bool
+    doc: string
+    deprecated: string
+    wrapper: Option[WrapperIR]
+
+  WrapperIR = object
+    kind: string
+    recv: string
+    `method`: string
+    target: string
+    `interface`: bool
 
   ReceiverInfo = object
     name: string
@@ -63,10 +77,36 @@ type
 
   BodyIR = object
     blocks: seq[BlockIR]
+    values: seq[ValueIR]
+    named_results: seq[NamedResult]
     locals: seq[LocalVar]
     free_vars: seq[string]
     struct_hints: Table[string, HintIR]
     defers: seq[DeferInfo]
+    loops: seq[LoopIR]
+
+  NamedResult = object
+    name: string
+    typ: string
+    index: int
+    alloc: string
+
+  ValueIR = object
+    name: string
+    kind: string
+    typ: string
+    index: int
+    `block`: int
+    `ref`: string
+    value: string
+
+  LoopIR = object
+    header: int
+    latches: seq[int]
+    blocks: seq[int]
+    exits: seq[int]
+    parent: int
+    depth: int
 
   HintIR = object
     kind: string
@@ -81,15 +121,144 @@ type
     id: int
     instructions: seq[Instruction]
     successors: seq[int]
+    predecessors: seq[int]
+    idom: int
+    dominees: seq[int]
+    post_idom: int
+    loop: int
+    loop_depth: int
     comment: string
 
   Instruction = object
     op: string
     args: seq[string]
     typ: string
+    `tuple`: seq[string]
     result: string
     comment: string
     position: int
+    alloc: Option[AllocIR]
+    operation: Option[OperationIR]
+    phi: Option[PhiIR]
+    slice: Option[SliceIR]
+    access: Option[AccessIR]
+    field: Option[FieldIR]
+    convert: Option[ConvertIR]
+    type_assert: Option[TypeAssertIR]
+    make: Option[MakeIR]
+    range: Option[RangeIR]
+    select: Option[SelectIR]
+    extract: Option[ExtractIR]
+    `return`: Option[ReturnIR]
+    call: Option[CallIR]
+    debug_ref: Option[DebugRefIR]
+
+  AllocIR = object
+    elem: string
+    heap: bool
+    `var`: string
+
+  OperationIR = object
+    operator: string
+    x: string
+    y: string
+    comma_ok: bool
+
+  PhiIR = object
+    edges: seq[PhiEdge]
+    `var`: string
+
+  PhiEdge = object
+    `block`: int
+    value: string
+
+  SliceIR = object
+    x: string
+    kind: string
+    low: string
+    high: string
+    max: string
+
+  AccessIR = object
+    x: string
+    kind: string
+    key: string
+    value: string
+    key_type: string
+    elem_type: string
+    comma_ok: bool
+
+  FieldIR = object
+    x: string
+    struct: string
+    index: int
+    name: string
+    embedded: bool
+
+  ConvertIR = object
+    x: string
+    `from`: string
+    to: string
+    from_kind: string
+    to_kind: string
+
+  TypeAssertIR = object
+    x: string
+    asserted: string
+    `interface`: bool
+    comma_ok: bool
+
+  MakeIR = object
+    len: string
+    cap: string
+    reserve: string
+    size: string
+    fn: string
+    bindings: seq[string]
+    key_type: string
+    elem_type: string
+
+  RangeIR = object
+    x: string
+    kind: string
+    key_type: string
+    value_type: string
+
+  SelectIR = object
+    blocking: bool
+    states: seq[SelectState]
+
+  SelectState = object
+    dir: string
+    chan: string
+    send: string
+
+  ExtractIR = object
+    `tuple`: string
+    index: int
+
+  ReturnIR = object
+    values: seq[string]
+    names: seq[string]
+
+  CallIR = object
+    kind: string
+    value: string
+    target: string
+    recv: string
+    `method`: string
+    args: seq[string]
+    builtin: Option[BuiltinIR]
+
+  BuiltinIR = object
+    name: string
+    arg_types: seq[string]
+    kind: string
+
+  DebugRefIR = object
+    x: string
+    expr: string
+    is_addr: bool
 
   LocalVar = object
     name: string
@@ -99,11 +268,17 @@ type
     name: string
     typ: string
     value: string
+    exported: bool
+    doc: string
+    deprecated: string
 
   ConstDef = object
     name: string
     typ: string
     value: string
+    exported: bool
+    doc: string
+    deprecated: string
 
   NimGenerator = object
     ir: HybridIR
@@ -129,6 +304,8 @@ proc sanitizeName(name: string): string =
   result = result.replace("]", "")
   result = result.replace("(", "_")
   result = result.replace(")", "_")
+  result = result.replace("$", "_")
+  result = result.replace("#", "_")
   
   # Handle Nim keywords
   const nimKeywords = ["addr", "and", "as", "asm", "bind", "block", "break",
@@ -153,6 +330,19 @@ proc emit(gen: var NimGenerator, line: string) =
 proc emitRaw(gen: var NimGenerator, text: string) =
   gen.output.add(text)
 
+proc exportMarker(exported: bool): string =
+  if exported: "*" else: ""
+
+# Emits a Go doc comment as ## lines at the current indentation.
+proc emitDoc(gen: var NimGenerator, doc: string) =
+  for line in doc.strip(leading = false).splitLines:
+    gen.emit(("## " & line).strip(leading = false))
+
+# Formats a Go doc comment as a trailing ## comment on one line.
+proc trailingDoc(doc: string): string =
+  let text = doc.splitWhitespace.join(" ")
+  if text.len > 0: " ## " & text else: ""
+
 proc convertType(gen: var NimGenerator, goType: string): string =
   # Handle pointer types
   if goType.startsWith("*"):
@@ -238,12 +428,35 @@ proc convertType(gen: var NimGenerator, goType: string): string =
         return sanitizeName(parts[0]) & "_" & sanitizeName(parts[1])
     return sanitizeName(goType)
 
+# underlyingType follows a named type of the program to its underlying type
+proc underlyingType(gen: NimGenerator, goType: string): string =
+  result = goType
+  let dot = goType.rfind('.')
+  if dot < 0 or goType.startsWith("[") or goType.startsWith("*"):
+    return
+  let (path, name) = (goType[0..<dot], goType[dot+1..^1])
+  for pkg in gen.ir.packages:
+    if pkg.path == path:
+      for td in pkg.types:
+        if td.name == name and td.underlying.len > 0:
+          return gen.underlyingType(td.underlying)
+
+proc tupleType(gen: var NimGenerator, results: seq[Param]): string =
+  ## The Nim tuple of multiple results, with fields for named results.
+  var fields: seq[string]
+  for res in results:
+    let typ = gen.convertType(res.typ)
+    fields.add(if res.name.len > 0: &"{sanitizeName(res.name)}: {typ}" else: typ)
+  if results[0].name.len > 0: &"tuple[{fields.join(\", \")}]"
+  else: &"({fields.join(\", \")})"
+
 proc generateTypeDefinition(gen: var NimGenerator, typeDef: TypeDef) =
-  let typeName = sanitizeName(typeDef.name)
+  let typeName = sanitizeName(typeDef.name) & exportMarker(typeDef.exported)
+  let doc = trailingDoc(typeDef.doc)
 
   case typeDef.kind
   of "struct":
-    gen.emit(&"type {typeName}* = object")
+    gen.emit(&"type {typeName} = object{doc}")
     gen.indent.inc
     if typeDef.fields.len == 0:
       gen.emit("discard")  # Empty struct, use discard
@@ -263,7 +476,7 @@ proc generateTypeDefinition(gen: var NimGenerator, typeDef: TypeDef) =
     gen.emit("")
 
   of "interface":
-    gen.emit(&"type {typeName}* = ref object of GoInterface")
+    gen.emit(&"type {typeName} = ref object of GoInterface{doc}")
     gen.indent.inc
     if typeDef.fields.len == 0:
       gen.emit("discard")
@@ -275,7 +488,7 @@ proc generateTypeDefinition(gen: var NimGenerator, typeDef: TypeDef) =
                           gen.convertType(typeDef.underlying)
                         else:
                           "void"  # Default to 'void' if underlying type is missing
-    gen.emit(&"type {typeName}* = {underlyingType}")
+    gen.emit(&"type {typeName} = {underlyingType}{doc}")
     gen.emit("")
 
   of "func":
@@ -287,18 +500,44 @@ proc generateTypeDefinition(gen: var NimGenerator, typeDef: TypeDef) =
     if typeDef.signature.results.len == 1:
       resultType = gen.convertType(typeDef.signature.results[0].typ)
     elif typeDef.signature.results.len > 1:
-      var resultTypes: seq[string]
-      for res in typeDef.signature.results:
-        resultTypes.add(gen.convertType(res.typ))
-      resultType = &"tuple[{resultTypes.join(\", \")}]"
+      resultType = gen.tupleType(typeDef.signature.results)
 
     let paramList = paramTypes.join(", ")
-    gen.emit(&"type {typeName}* = proc({paramList}): {resultType}")
+    gen.emit(&"type {typeName} = proc({paramList}): {resultType}{doc}")
     gen.emit("")
 
   else:
     echo "Warning: Unsupported type kind: {typeDef.kind} for {typeName}"
 
+proc builtinCallExpr(b: BuiltinIR, args: seq[string]): string =
+  ## Maps a call of a Go builtin to the runtime procs implementing it.
+  var x = if args.len > 0: args[0] else: ""
+  if b.kind == "array_pointer":
+    x = x & "[]"
+  case b.name
+  of "append":
+    if args.len < 2: x
+    elif b.kind == "string": &"appendString({x}, {args[1]})"
+    else: &"appendSlice({x}, {args[1]})"
+  of "len", "cap": &"{x}.{b.name}"
+  of "copy": &"copy({x}, {args[1]})"
+  of "delete": &"{x}.delete({args[1]})"
+  of "clear", "close": &"{x}.{b.name}()"
+  of "min", "max": &"{b.name}([{args.join(\", \")}])"
+  of "print", "println":
+    var parts: seq[string]
+    for arg in args:
+      parts.add(&"${arg}")
+    if b.name == "println":
+      let text = if parts.len > 0: parts.join(" & \" \" & ") else: "\"\""
+      &"stderr.writeLine({text})"
+    else:
+      let text = if parts.len > 0: parts.join(" & ") else: "\"\""
+      &"stderr.write({text})"
+  of "unsafe.Add": &"cast[pointer](cast[uint]({x}) + uint({args[1]}))"
+  of "ssa:wrapnilchk", "unsafe.SliceData", "unsafe.StringData": x
+  else: &"{b.name}({args.join(\", \")})"
+
 proc generateInstruction(gen: var NimGenerator, instr: Instruction) =
   case instr.op
   of "Alloc":
@@ -314,17 +553,131 @@ proc generateInstruction(gen: var NimGenerator, instr: Instruction) =
       gen.emit(&"{dest} = {src}")
   
   of "UnOp":
-    if instr.result.len > 0 and instr.args.len > 0:
+    if instr.result.len > 0 and instr.operation.isSome:
+      let op = instr.operation.get
       let res = sanitizeName(instr.result)
-      let arg = sanitizeName(instr.args[0])
-      gen.emit(&"let {res} = not {arg}  # {instr.comment}")
+      let x = sanitizeName(op.x)
+      case op.operator
+      of "*": gen.emit(&"let {res} = {x}[]")
+      of "<-":
+        if op.comma_ok: gen.emit(&"let {res} = {x}.tryRecv()")
+        else: gen.emit(&"let {res} = {x}.recv()")
+      of "-": gen.emit(&"let {res} = -{x}")
+      else: gen.emit(&"let {res} = not {x}")
   
   of "BinOp":
-    if instr.result.len > 0 and instr.args.len >= 2:
+    if instr.result.len > 0 and instr.operation.isSome:
+      let op = instr.operation.get
+      let res = sanitizeName(instr.result)
+      let lhs = sanitizeName(op.x)
+      let rhs = sanitizeName(op.y)
+      let nimOp = case op.operator
+        of "/": (if gen.convertType(instr.typ) in ["float32", "float64"]: "/" else: "div")
+        of "%": "mod"
+        of "&": "and"
+        of "|": "or"
+        of "^": "xor"
+        of "<<": "shl"
+        of ">>": "shr"
+        else: op.operator
+      if op.operator == "&^":
+        gen.emit(&"let {res} = {lhs} and not {rhs}")
+      else:
+        gen.emit(&"let {res} = {lhs} {nimOp} {rhs}")
+  
+  of "Slice":
+    if instr.result.len > 0 and instr.slice.isSome:
+      let sl = instr.slice.get
+      let res = sanitizeName(instr.result)
+      var x = sanitizeName(sl.x)
+      if sl.kind == "array_pointer":
+        x = x & "[]"
+      let low = if sl.low.len > 0: sanitizeName(sl.low) else: "0"
+      let high = if sl.high.len > 0: sanitizeName(sl.high) else: &"{x}.len"
+      if sl.max.len > 0:
+        gen.emit(&"let {res} = {x}.slice({low}, {high}, {sanitizeName(sl.max)})")
+      else:
+        gen.emit(&"let {res} = {x}[{low} ..< {high}]")
+  
+  of "IndexAddr", "Index":
+    if instr.result.len > 0 and instr.access.isSome:
+      let acc = instr.access.get
+      let res = sanitizeName(instr.result)
+      var x = sanitizeName(acc.x)
+      if acc.kind == "array_pointer":
+        x = x & "[]"
+      let key = sanitizeName(acc.key)
+      if instr.op == "IndexAddr":
+        gen.emit(&"let {res} = addr {x}[{key}]")
+      else:
+        gen.emit(&"let {res} = {x}[{key}]")
+  
+  of "Lookup":
+    if instr.result.len > 0 and instr.access.isSome:
+      let acc = instr.access.get
       let res = sanitizeName(instr.result)
-      let lhs = sanitizeName(instr.args[0])
-      let rhs = sanitizeName(instr.args[1])
-      gen.emit(&"let {res} = {lhs} + {rhs}  # {instr.comment}")
+      let x = sanitizeName(acc.x)
+      let key = sanitizeName(acc.key)
+      let zero = &"default({gen.convertType(acc.elem_type)})"
+      if acc.comma_ok:
+        gen.emit(&"let {res} = ({x}.getOrDefault({key}, {zero}), {x}.contains({key}))")
+      else:
+        gen.emit(&"let {res} = {x}.getOrDefault({key}, {zero})")
+  
+  of "MapUpdate":
+    if instr.access.isSome:
+      let acc = instr.access.get
+      gen.emit(&"{sanitizeName(acc.x)}[{sanitizeName(acc.key)}] = {sanitizeName(acc.value)}")
+  
+  of "FieldAddr", "Field":
+    if instr.result.len > 0 and instr.field.isSome:
+      let f = instr.field.get
+      let res = sanitizeName(instr.result)
+      let x = sanitizeName(f.x)
+      let name = sanitizeName(f.name)
+      if instr.op == "FieldAddr":
+        gen.emit(&"let {res} = addr {x}.{name}")
+      else:
+        gen.emit(&"let {res} = {x}.{name}")
+  
+  of "Convert", "ChangeType", "SliceToArrayPointer":
+    if instr.result.len > 0 and instr.convert.isSome:
+      let conv = instr.convert.get
+      let res = sanitizeName(instr.result)
+      let x = sanitizeName(conv.x)
+      let toType = gen.convertType(conv.to)
+      if conv.to_kind == "string" and conv.from_kind == "slice":
+        gen.emit(&"let {res} = sliceToGoString({x})")
+      elif conv.to_kind == "slice" and conv.from_kind == "string":
+        let elem = gen.underlyingType(gen.underlyingType(conv.to)[2..^1])
+        if elem in ["rune", "int32"]:
+          gen.emit(&"let {res} = stringToRunes({x})")
+        else:
+          gen.emit(&"let {res} = stringToBytes({x})")
+      elif conv.to_kind == "string" and conv.from_kind != "string":
+        gen.emit(&"let {res} = runeToGoString({x})")
+      else:
+        gen.emit(&"let {res} = {toType}({x})")
+  
+  of "Range":
+    if instr.result.len > 0 and instr.range.isSome:
+      let rng = instr.range.get
+      let res = sanitizeName(instr.result)
+      let keyType = gen.convertType(rng.key_type)
+      let valueType = gen.convertType(rng.value_type)
+      gen.emit(&"var {res}: seq[({keyType}, {valueType})]")
+      gen.emit(&"for k, v in {sanitizeName(rng.x)}.pairs: {res}.add((k, v))")
+      gen.emit(&"var {res}_pos = 0")
+  
+  of "Next":
+    if instr.result.len > 0 and instr.range.isSome:
+      let rng = instr.range.get
+      let res = sanitizeName(instr.result)
+      let it = sanitizeName(rng.x)
+      let keyType = gen.convertType(rng.key_type)
+      let valueType = gen.convertType(rng.value_type)
+      gen.emit(&"let {res} = if {it}_pos < {it}.len: (true, {it}[{it}_pos][0], {it}[{it}_pos][1]) else: (false, default({keyType}), default({valueType}))")
+      gen.emit(&"inc {it}_pos")
   
   of "Call", "Go":
     var callStr = ""
@@ -334,7 +687,14 @@ proc generateInstruction(gen: var NimGenerator, instr: Instruction) =
       for i in 1..<instr.args.len:
         args.add(sanitizeName(instr.args[i]))
       
-      if instr.op == "Go":
+      let builtin = if instr.call.isSome: instr.call.get.builtin else: none(BuiltinIR)
+      if builtin.isSome:
+        callStr = builtinCallExpr(builtin.get, args)
+      elif instr.call.isSome and instr.call.get.kind == "invoke":
+        callStr = &"{fnName}.{sanitizeName(instr.call.get.`method`)}({args.join(\", \")})"
+        if instr.op == "Go":
+          callStr = "spawn " & callStr
+      elif instr.op == "Go":
         callStr = &"spawn {fnName}({args.join(\", \")})"
       else:
         callStr = &"{fnName}({args.join(\", \")})"
@@ -350,10 +710,18 @@ proc generateInstruction(gen: var NimGenerator, instr: Instruction) =
       var rets: seq[string]
       for arg in instr.args:
         rets.add(sanitizeName(arg))
-      gen.emit(&"return {rets.join(\", \")}")
+      if rets.len == 1:
+        gen.emit(&"return {rets[0]}")
+      else:
+        gen.emit(&"return ({rets.join(\", \")})")
     else:
       gen.emit("return")
   
+  of "Extract":
+    if instr.result.len > 0 and instr.extract.isSome:
+      let ext = instr.extract.get
+      gen.emit(&"let {sanitizeName(instr.result)} = {sanitizeName(ext.`tuple`)}[{ext.index}]")
+  
   of "If":
     if instr.args.len > 0:
       let cond = sanitizeName(instr.args[0])
@@ -459,15 +827,17 @@ proc generateFunction(gen: var NimGenerator, fn: FunctionIR) =
   if fn.signature.results.len == 1:
     returnType = ": " & gen.convertType(fn.signature.results[0].typ)
   elif fn.signature.results.len > 1:
-    var resultTypes: seq[string]
-    for res in fn.signature.results:
-      resultTypes.add(gen.convertType(res.typ))
-    returnType = &": tuple[{resultTypes.join(\", \")}]"
+    returnType = ": " & gen.tupleType(fn.signature.results)
   
   # Generate function signature
   let paramList = params.join(", ")
-  gen.emit(&"proc {procName}*({paramList}){returnType} =")
+  let marker = exportMarker(fn.exported)
+  var pragmas = ""
+  if fn.deprecated.len > 0:
+    pragmas = " {.deprecated: " & fn.deprecated.escape & ".}"
+  gen.emit(&"proc {procName}{marker}({paramList}){returnType}{pragmas} =")
   gen.indent.inc
+  gen.emitDoc(fn.doc)
   
   # Generate body
   if fn.body.blocks.len == 0:
@@ -496,9 +866,10 @@ proc generatePackage(gen: var NimGenerator, pkg: PackageIR) =
   
   # Generate constants
   for constant in pkg.constants:
-    let constName = sanitizeName(constant.name)
+    let constName = sanitizeName(constant.name) & exportMarker(constant.exported)
     let constType = gen.convertType(constant.typ)
-    gen.emit(&"const {constName}*: {constType} = {constant.value}")
+    let doc = trailingDoc(constant.doc)
+    gen.emit(&"const {constName}: {constType} = {constant.value}{doc}")
   
   if pkg.constants.len > 0:
     gen.emit("")
@@ -509,12 +880,13 @@ proc generatePackage(gen: var NimGenerator, pkg: PackageIR) =
   
   # Generate globals
   for global in pkg.globals:
-    let globalName = sanitizeName(global.name)
+    let globalName = sanitizeName(global.name) & exportMarker(global.exported)
     let globalType = gen.convertType(global.typ)
+    let doc = trailingDoc(global.doc)
     if global.value.len > 0:
-      gen.emit(&"var {globalName}*: {globalType} = {global.value}")
+      gen.emit(&"var {globalName}: {globalType} = {global.value}{doc}")
     else:
-      gen.emit(&"var {globalName}*: {globalType}")
+      gen.emit(&"var {globalName}: {globalType}{doc}")
   
   if pkg.globals.len > 0:
     gen.emit("")
@@ -523,139 +895,112 @@ proc generatePackage(gen: var NimGenerator, pkg: PackageIR) =
   for fn in pkg.functions:
     gen.generateFunction(fn)
 
-proc generateRuntime(outputDir: string) =
-  # Generate runtime.nim with Go runtime primitives
-  let runtimeCode = """
-# Go Runtime for Nim
-import std/[asyncdispatch, locks, hashes, tables]
-
-type
-  GoInt* = int
-  GoUint* = uint
-  Rune* = int32
-  
-  GoString* = object
-    data*: seq[byte]
-    length*: int
-  
-  GoSlice*[T] = object
-    data*: seq[T]
-    length*: int
-    capacity*: int
-  
-  GoMap*[K, V] = ref object
-    data*: Table[K, V]
-  
-  GoChan*[T] = ref object
-    queue*: seq[T]
-    lock*: Lock
-    capacity*: int
-  
-  GoRecvChan*[T] = GoChan[T]
-  GoSendChan*[T] = GoChan[T]
-  
-  GoInterface* = ref object of RootObj
-  
-  GoError* = ref object of Exception
-  
-  GoFunc* = proc()
-  
-  GoComplex64* = object
-    real*: float32
-    imag*: float32
-  
-  GoComplex128* = object
-    real*: float64
-    imag*: float64
-
-proc newGoString*(s: string): GoString =
-  result.data = cast[seq[byte]](s)
-  result.length = s.len
-
-proc `$`*(s: GoString): string =
-  result = newString(s.length)
-  for i in 0..<s.length:
-    result[i] = char(s.data[i])
-
-proc newGoSlice*[T](cap: int = 0): GoSlice[T] =
-  result.data = newSeq[T](cap)
-  result.length = 0
-  result.capacity = cap
-
-proc append*[T](s: var GoSlice[T], items: varargs[T]) =
-  for item in items:
-    if s.length >= s.capacity:
-      s.capacity = if s.capacity == 0: 1 else: s.capacity * 2
-      s.data.setLen(s.capacity)
-    s.data[s.length] = item
-    s.length.inc
-
-proc `[]`*[T](s: GoSlice[T], i: int): T =
-  s.data[i]
-
-proc `[]=`*[T](s: var GoSlice[T], i: int, val: T) =
-  s.data[i] = val
-
-proc len*[T](s: GoSlice[T]): int =
-  s.length
-
-proc cap*[T](s: GoSlice[T]): int =
-  s.capacity
-
-proc newGoMap*[K, V](): GoMap[K, V] =
-  new(result)
-  result.data = initTable[K, V]()
-
-proc `[]`*[K, V](m: GoMap[K, V], key: K): V =
-  m.data[key]
+# The runtime the generated code imports, which implements the procs the
+# backend calls for Go builtins and operators, such as appendSlice, copy and
+# tryRecv.
+const goRuntime = staticRead("../runtime/runtime.nim")
 
-proc `[]=`*[K, V](m: GoMap[K, V], key: K, val: V) =
-  m.data[key] = val
-
-proc contains*[K, V](m: GoMap[K, V], key: K): bool =
-  m.data.hasKey(key)
-
-proc delete*[K, V](m: GoMap[K, V], key: K) =
-  m.data.del(key)
-
-proc newGoChan*[T](capacity: int = 0): GoChan[T] =
-  new(result)
-  result.queue = newSeq[T]()
-  result.capacity = capacity
-  initLock(result.lock)
-
-proc send*[T](ch: GoChan[T], val: T) =
-  withLock(ch.lock):
-    ch.queue.add(val)
-
-proc recv*[T](ch: GoChan[T]): T =
-  withLock(ch.lock):
-    while ch.queue.len == 0:
-      discard
-    result = ch.queue[0]
-    ch.queue.delete(0)
-
-proc close*[T](ch: GoChan[T]) =
-  discard
-
-proc spawn*(fn: proc()) =
-  # Simple goroutine simulation using thread
-  var thr: Thread[void]
-  createThread(thr, fn)
+proc generateRuntime(outputDir: string) =
+  writeFile(outputDir / "runtime.nim", goRuntime)
 
-proc panic*(msg: string) =
-  raise newException(GoError, msg)
+# The binary IR written by `gonim-compile ir -format binary` (see
+# compiler/ir/binary.go) stores the JSON data model, so it decodes to a
+# JsonNode and both encodings share one conversion to HybridIR.
+const
+  gnirMagic = "GNIR"
+  gnirVersion = 1'u64
 
-proc recover*(): GoInterface =
-  # Simplified recover
-  result = nil
-"""
-  
-  writeFile(outputDir / "runtime.nim", runtimeCode)
+type
+  GnirReader = object
+    data: string
+    pos: int
+    strings: seq[string]
+
+proc gnirError(msg: string) {.noreturn.} =
+  raise newException(ValueError, "binary IR: " & msg)
+
+proc readByte(r: var GnirReader): byte =
+  if r.pos >= r.data.len:
+    gnirError("unexpected EOF")
+  result = byte(r.data[r.pos])
+  inc r.pos
+
+proc readUvarint(r: var GnirReader): uint64 =
+  var shift = 0
+  while true:
+    let b = r.readByte()
+    if shift == 63 and b > 1:
+      gnirError("varint overflows a 64-bit integer")
+    result = result or (uint64(b and 0x7F) shl shift)
+    if b < 0x80:
+      return
+    shift += 7
+
+proc readVarint(r: var GnirReader): int64 =
+  let ux = r.readUvarint()
+  result = int64(ux shr 1)
+  if (ux and 1) != 0:
+    result = not result
+
+# readCount reads a length or element count, each unit of which takes at
+# least one byte of the input, so lengths from corrupt files fail early
+proc readCount(r: var GnirReader): int =
+  let n = r.readUvarint()
+  if n > uint64(r.data.len - r.pos):
+    gnirError(&"count {n} too large")
+  int(n)
+
+proc readStringRef(r: var GnirReader): string =
+  let idx = r.readUvarint()
+  if idx >= uint64(r.strings.len):
+    gnirError(&"string index {idx} out of range")
+  r.strings[int(idx)]
+
+proc readValue(r: var GnirReader): JsonNode =
+  let tag = r.readByte()
+  case tag
+  of 0: newJNull()
+  of 1: newJBool(false)
+  of 2: newJBool(true)
+  of 3: newJInt(BiggestInt(r.readVarint()))
+  of 4:
+    var bits = 0'u64
+    for i in 0..7:
+      bits = bits or (uint64(r.readByte()) shl (8 * i))
+    newJFloat(cast[float64](bits))
+  of 5: newJString(r.readStringRef())
+  of 6:
+    let arr = newJArray()
+    for _ in 0..<r.readCount():
+      arr.add(r.readValue())
+    arr
+  of 7:
+    let obj = newJObject()
+    for _ in 0..<r.readCount():
+      let key = r.readStringRef()
+      obj[key] = r.readValue()
+    obj
+  else:
+    gnirError(&"unknown tag {tag}")
+
+proc parseGnir(data: string): JsonNode =
+  if not data.startsWith(gnirMagic):
+    gnirError("bad magic")
+  var r = GnirReader(data: data, pos: gnirMagic.len)
+  let version = r.readUvarint()
+  if version != gnirVersion:
+    gnirError(&"unsupported version {version}")
+  for _ in 0..<r.readCount():
+    let n = r.readCount()
+    r.strings.add(data[r.pos ..< r.pos + n])
+    r.pos += n
+  r.readValue()
 
 proc generate*(irPath: string, outputDir: string) =
-  let jsonContent = readFile(irPath)
-  let ir = to(parseJson(jsonContent), HybridIR)
+  let content = readFile(irPath)
+  let tree = if content.startsWith(gnirMagic): parseGnir(content)
+             else: parseJson(content)
+  let ir = to(tree, HybridIR)
   
   createDir(outputDir)
   generateRuntime(outputDir)
@@ -700,7 +1045,7 @@ when isMainModule:
         irPath = p.key
   
   if irPath.len == 0:
-    echo "Usage: nim c -r backend.nim -i input.json -o output_dir"
+    echo "Usage: nim c -r backend.nim -i input.json|input.gnir -o output_dir"
     quit(1)
   
   generate(irPath, outputDir)
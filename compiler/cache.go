@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/packages"
+)
+
+// irCacheVersion is bumped whenever the layout of cache entries changes.
+const irCacheVersion = "1"
+
+// irCache stores the generated IR of every package under a content address
+// derived from the package's files, the keys of its dependencies, the Go
+// version, the build flags, the stdlib manifest and the frontend binary
+// itself, so unchanged packages skip SSA construction and extraction.
+type irCache struct {
+	dir    string
+	base   string
+	stdlib *stdlibResolver
+
+	// mu guards the fields below; packages are looked up concurrently.
+	mu     sync.Mutex
+	keys   map[*packages.Package]string
+	hashes map[string]string
+	hits   int
+	misses int
+}
+
+// cacheEntry is the cached IR of one package, together with the unmapped
+// stdlib uses whose diagnostics have to be reported again on a cache hit.
+type cacheEntry struct {
+	Key      string        `json:"key"`
+	Package  ir.PackageIR  `json:"package"`
+	Unmapped []UnmappedUse `json:"unmapped"`
+}
+
+func newIRCache(dir string, p *program, opts *loadOptions) (*irCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	manifest, err := json.Marshal(p.stdlib.manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "cache %s\nfrontend %s\ngo %s\n", irCacheVersion, frontendVersion(), goVersion(opts))
+	fmt.Fprintf(h, "goos %s\ngoarch %s\ntags %s\nmod %s\nmode %s\n",
+		p.build.GOOS, p.build.GOARCH, strings.Join(p.build.Tags, ","), p.build.Mod, p.build.Mode)
+	fmt.Fprintf(h, "unmapped %s\nmanifest %s\n", p.stdlib.severity, manifest)
+
+	return &irCache{
+		dir:    dir,
+		base:   hex.EncodeToString(h.Sum(nil)),
+		stdlib: p.stdlib,
+		keys:   make(map[*packages.Package]string),
+		hashes: make(map[string]string),
+	}, nil
+}
+
+// frontendVersion identifies the running frontend by the hash of its
+// executable, so rebuilding it invalidates the cache.
+func frontendVersion() string {
+	exe, err := os.Executable()
+	if err == nil {
+		if sum, err := hashFile(exe); err == nil {
+			return sum
+		}
+	}
+	return "unknown"
+}
+
+// goVersion returns the version of the go command that loads the packages,
+// which decides the standard library sources.
+func goVersion(opts *loadOptions) string {
+	cmd := exec.Command("go", "env", "GOVERSION")
+	cmd.Env = opts.config().Env
+	out, err := cmd.Output()
+	if err != nil {
+		return runtime.Version()
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// key returns the content address of pkg; c.mu must be held. Standard
+// library packages are identified by their path alone since the Go version
+// is part of every key.
+func (c *irCache) key(pkg *packages.Package) (string, error) {
+	if key, ok := c.keys[pkg]; ok {
+		return key, nil
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "base %s\npackage %s %s\n", c.base, pkg.ID, pkg.PkgPath)
+	if !c.stdlib.isStandard(pkg.PkgPath) {
+		if err := c.hashFiles(h, pkg); err != nil {
+			return "", err
+		}
+	}
+
+	paths := make([]string, 0, len(pkg.Imports))
+	for path := range pkg.Imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		dep, err := c.key(pkg.Imports[path])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "import %s %s\n", path, dep)
+	}
+
+	key := hex.EncodeToString(h.Sum(nil))
+	c.keys[pkg] = key
+	return key, nil
+}
+
+func (c *irCache) hashFiles(h hash.Hash, pkg *packages.Package) error {
+	files := append(append([]string(nil), pkg.CompiledGoFiles...), pkg.OtherFiles...)
+	sort.Strings(files)
+	for _, file := range files {
+		sum, ok := c.hashes[file]
+		if !ok {
+			var err error
+			if sum, err = hashFile(file); err != nil {
+				return err
+			}
+			c.hashes[file] = sum
+		}
+		fmt.Fprintf(h, "file %s %s\n", file, sum)
+	}
+	return nil
+}
+
+func (c *irCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".gnir")
+}
+
+// lookup returns the cached IR of pkg. A nil cache never hits.
+func (c *irCache) lookup(pkg *packages.Package) (*cacheEntry, bool) {
+	if c == nil || pkg == nil {
+		return nil, false
+	}
+	key, err := c.lockedKey(pkg)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	data, err := os.ReadFile(c.path(key))
+	if err == nil {
+		err = ir.UnmarshalBinary(data, &entry)
+	}
+	hit := err == nil && entry.Key == key
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !hit {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return &entry, true
+}
+
+func (c *irCache) lockedKey(pkg *packages.Package) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.key(pkg)
+}
+
+// store writes the IR of pkg to the cache; the file is renamed into place so
+// concurrent runs never read a partial entry.
+func (c *irCache) store(pkg *packages.Package, pkgIR ir.PackageIR, unmapped []UnmappedUse) error {
+	if c == nil || pkg == nil {
+		return nil
+	}
+	key, err := c.lockedKey(pkg)
+	if err != nil {
+		return err
+	}
+
+	data, err := ir.MarshalBinary(cacheEntry{Key: key, Package: pkgIR, Unmapped: unmapped})
+	if err != nil {
+		return err
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
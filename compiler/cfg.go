@@ -0,0 +1,213 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+// blockIndexes returns the indexes of blocks in fn.Blocks.
+func blockIndexes(blocks []*ssa.BasicBlock) []int {
+	idx := make([]int, 0, len(blocks))
+	for _, b := range blocks {
+		idx = append(idx, b.Index)
+	}
+	return idx
+}
+
+// extractCFG records the edges, dominator and post-dominator trees and
+// loops of fn in the blocks of body, which correspond to fn.Blocks.
+func extractCFG(fn *ssa.Function, body *ir.BodyIR) {
+	postIdom := postDominators(fn)
+	for i, block := range fn.Blocks {
+		b := &body.Blocks[i]
+		b.Successors = blockIndexes(block.Succs)
+		b.Predecessors = blockIndexes(block.Preds)
+		b.Dominees = blockIndexes(block.Dominees())
+		b.Idom = -1
+		if idom := block.Idom(); idom != nil {
+			b.Idom = idom.Index
+		}
+		b.PostIdom = postIdom[i]
+		b.Loop = -1
+	}
+
+	body.Loops = findLoops(fn)
+	for i, loop := range body.Loops {
+		for _, b := range loop.Blocks {
+			blk := &body.Blocks[b]
+			blk.LoopDepth++
+			if blk.Loop < 0 || body.Loops[blk.Loop].Depth < loop.Depth {
+				blk.Loop = i
+			}
+		}
+	}
+}
+
+// postDominators returns the immediate post-dominator of every block of fn,
+// computed on the reversed CFG with a virtual exit node following every
+// block without successors. Blocks whose immediate post-dominator is the
+// exit, or that never reach it, get -1.
+func postDominators(fn *ssa.Function) []int {
+	n := len(fn.Blocks)
+	exit := n
+	// succs are the successors in the CFG, which are the predecessors in
+	// the reversed graph.
+	succs := func(b int) []int {
+		if s := fn.Blocks[b].Succs; len(s) > 0 {
+			return blockIndexes(s)
+		}
+		return []int{exit}
+	}
+
+	order := make([]int, n+1)
+	visited := make([]bool, n+1)
+	var post []int
+	var visit func(b int)
+	visit = func(b int) {
+		visited[b] = true
+		var preds []int
+		if b == exit {
+			for _, blk := range fn.Blocks {
+				if len(blk.Succs) == 0 {
+					preds = append(preds, blk.Index)
+				}
+			}
+		} else {
+			preds = blockIndexes(fn.Blocks[b].Preds)
+		}
+		for _, p := range preds {
+			if !visited[p] {
+				visit(p)
+			}
+		}
+		order[b] = len(post)
+		post = append(post, b)
+	}
+	visit(exit)
+
+	ipdom := make([]int, n+1)
+	for i := range ipdom {
+		ipdom[i] = -1
+	}
+	ipdom[exit] = exit
+	intersect := func(a, b int) int {
+		for a != b {
+			for order[a] < order[b] {
+				a = ipdom[a]
+			}
+			for order[b] < order[a] {
+				b = ipdom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		// Reverse postorder, skipping the exit, which comes last.
+		for i := len(post) - 2; i >= 0; i-- {
+			b := post[i]
+			idom := -1
+			for _, s := range succs(b) {
+				if ipdom[s] < 0 {
+					continue
+				}
+				if idom < 0 {
+					idom = s
+				} else {
+					idom = intersect(s, idom)
+				}
+			}
+			if ipdom[b] != idom {
+				ipdom[b] = idom
+				changed = true
+			}
+		}
+	}
+
+	result := make([]int, n)
+	for i := range result {
+		result[i] = ipdom[i]
+		if result[i] == exit {
+			result[i] = -1
+		}
+	}
+	return result
+}
+
+// findLoops returns the natural loops of fn. The back edges to a header,
+// from the blocks it dominates, form a single loop.
+func findLoops(fn *ssa.Function) []ir.LoopIR {
+	loops := make([]ir.LoopIR, 0)
+	for _, h := range fn.Blocks {
+		latches := make([]int, 0)
+		for _, p := range h.Preds {
+			if h.Dominates(p) && !containsInt(latches, p.Index) {
+				latches = append(latches, p.Index)
+			}
+		}
+		if len(latches) == 0 {
+			continue
+		}
+		sort.Ints(latches)
+
+		in := map[int]bool{h.Index: true}
+		stack := append([]int(nil), latches...)
+		for len(stack) > 0 {
+			b := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if in[b] {
+				continue
+			}
+			in[b] = true
+			for _, p := range fn.Blocks[b].Preds {
+				if h.Dominates(p) {
+					stack = append(stack, p.Index)
+				}
+			}
+		}
+
+		loop := ir.LoopIR{Header: h.Index, Latches: latches, Exits: make([]int, 0), Parent: -1}
+		for _, b := range fn.Blocks {
+			if !in[b.Index] {
+				continue
+			}
+			loop.Blocks = append(loop.Blocks, b.Index)
+			for _, s := range b.Succs {
+				if !in[s.Index] && !containsInt(loop.Exits, s.Index) {
+					loop.Exits = append(loop.Exits, s.Index)
+				}
+			}
+		}
+		sort.Ints(loop.Exits)
+		loops = append(loops, loop)
+	}
+
+	// The parent of a loop is the smallest other loop containing its header.
+	for i := range loops {
+		for j := range loops {
+			if i == j || !containsInt(loops[j].Blocks, loops[i].Header) {
+				continue
+			}
+			if p := loops[i].Parent; p < 0 || len(loops[j].Blocks) < len(loops[p].Blocks) {
+				loops[i].Parent = j
+			}
+		}
+	}
+	for i := range loops {
+		for p := i; p >= 0; p = loops[p].Parent {
+			loops[i].Depth++
+		}
+	}
+	return loops
+}
+
+func containsInt(list []int, x int) bool {
+	for _, y := range list {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,700 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/types"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []*command
+
+func init() {
+	// Assigned in init because runHelp refers back to commands.
+	commands = []*command{
+		{name: "ir", args: "[flags] [packages]", summary: "Generate the hybrid IR as JSON", run: runIR},
+		{name: "watch", args: "[flags] [packages]", summary: "Regenerate the IR whenever a source file changes", run: runWatch},
+		{name: "check", args: "[flags] [packages]", summary: "Load and type-check packages, build SSA, write nothing", run: runCheck},
+		{name: "stats", args: "[flags] [packages]", summary: "Print function, block and instruction counts", run: runStats},
+		{name: "graph", args: "[flags] [packages]", summary: "Print the call graph, or a function's CFG, in DOT format", run: runGraph},
+		{name: "report", args: "[flags] [packages]", summary: "Report used Go features and which ones go2nim cannot handle", run: runReport},
+		{name: "explain", args: "[flags] <func>", summary: "Print the IR of a single function", run: runExplain},
+		{name: "run", args: "[flags] [packages]", summary: "Execute a main package from its IR with the reference interpreter", run: runInterp},
+		{name: "difftest", args: "[flags] <programs or directories>", summary: "Run programs with Go and transpiled to Nim and compare their output", run: runDiffTest},
+		{name: "convert", args: "[flags] <input> <output>", summary: "Convert an IR file between the JSON and binary encodings", run: runConvert},
+		{name: "help", args: "[command]", summary: "Show help for a command", run: runHelp},
+	}
+}
+
+func lookupCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: gonim-compile <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Without a command, gonim-compile runs \"ir\".")
+	fmt.Fprintln(w, "Run \"gonim-compile help <command>\" for the flags of a command.")
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	cmd := lookupCommand(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gonim-compile %s %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args into fs and reports the exit code to use when
+// parsing did not succeed.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+
+	cmd := lookupCommand(args[0])
+	if cmd == nil || cmd.name == "help" {
+		fmt.Fprintf(os.Stderr, "gonim-compile: unknown command %q\n\n", args[0])
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	return cmd.run([]string{"-h"})
+}
+
+func runIR(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("ir")
+	opts.register(fs)
+	outputPath := fs.String("output", "", "Output file, or directory for -format sharded (default output.json, output.gnir, output.jsonl or output_ir)")
+	format := fs.String("format", formatJSON, "Output format: json (single file), binary (compact single file), jsonl (streamed JSON Lines) or sharded (one file per package plus index.json)")
+	diagPath := fs.String("diagnostics", "", "Also write diagnostics as JSON to this file")
+	cacheDir := fs.String("cache", "", "Reuse the IR of unchanged packages from this cache directory")
+	library := fs.Bool("library", false, "Library mode: emit the exported API of every package, their methods and a module manifest")
+	golden := fs.Bool("golden", false, "Write file names relative to the working directory so the IR can be checked in and diffed")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	opts.addPatterns(fs.Args())
+	opts.lazyBuild = true
+	if *library && opts.tests {
+		fmt.Fprintf(os.Stderr, "gonim-compile ir: -library and -test cannot be combined\n")
+		return exitUsage
+	}
+
+	defaultOutput, ok := defaultOutputs[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "gonim-compile ir: unknown format %q\n", *format)
+		return exitUsage
+	}
+	if *outputPath == "" {
+		*outputPath = defaultOutput
+	}
+
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		return failLoad(err, diags, *diagPath)
+	}
+	p.golden = *golden
+	if *library {
+		p.build.Mode = modeLibrary
+	}
+
+	var cache *irCache
+	if *cacheDir != "" {
+		if cache, err = newIRCache(*cacheDir, p, &opts); err != nil {
+			log.Printf("Failed to open cache: %v", err)
+			return exitFailure
+		}
+	}
+
+	switch *format {
+	case formatJSON, formatBinary:
+		w := &memoryWriter{}
+		if err = emitIR(p, w, cache, opts.verbose); err != nil {
+			break
+		}
+		if *format == formatJSON {
+			err = writeJSONFile(*outputPath, w.ir)
+		} else {
+			err = writeBinaryFile(*outputPath, w.ir)
+		}
+	case formatJSONL:
+		var w *jsonlWriter
+		if w, err = newJSONLWriter(*outputPath); err == nil {
+			err = emitIR(p, w, cache, opts.verbose)
+		}
+	case formatSharded:
+		var w *shardedWriter
+		if w, err = newShardedWriter(*outputPath); err == nil {
+			err = emitIR(p, w, cache, opts.verbose)
+		}
+	}
+
+	if cache != nil && opts.verbose {
+		log.Printf("Cache: %d packages reused, %d regenerated", cache.hits, cache.misses)
+	}
+
+	diags.print(os.Stderr)
+	if code := writeDiagnostics(diags, *diagPath); code != exitOK {
+		return code
+	}
+
+	if err != nil {
+		log.Printf("Failed to write output: %v", err)
+		return exitFailure
+	}
+
+	if n := diags.count(severityError); n > 0 {
+		log.Printf("Generated IR with %d errors: %s", n, *outputPath)
+		return exitFailure
+	}
+
+	log.Printf("Successfully generated IR: %s", *outputPath)
+	return exitOK
+}
+
+func runConvert(args []string) int {
+	fs := newFlagSet("convert")
+	to := fs.String("to", "", "Output encoding: json or binary (default: the opposite of the input)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return exitUsage
+	}
+	inPath, outPath := fs.Arg(0), fs.Arg(1)
+
+	data, err := os.ReadFile(inPath)
+	if err != nil {
+		log.Printf("Failed to read input: %v", err)
+		return exitFailure
+	}
+
+	from := formatJSON
+	if ir.IsBinary(data) {
+		from = formatBinary
+	}
+	if *to == "" {
+		*to = formatBinary
+		if from == formatBinary {
+			*to = formatJSON
+		}
+	}
+	if *to != formatJSON && *to != formatBinary {
+		fmt.Fprintf(os.Stderr, "gonim-compile convert: unknown encoding %q\n", *to)
+		return exitUsage
+	}
+
+	var tree any
+	if from == formatBinary {
+		tree, err = ir.DecodeBinaryTree(data)
+	} else {
+		tree, err = ir.DecodeJSONTree(data)
+	}
+	if err != nil {
+		log.Printf("Failed to decode %s: %v", inPath, err)
+		return exitFailure
+	}
+
+	if *to == formatBinary {
+		err = writeBinaryFile(outPath, tree)
+	} else {
+		err = writeJSONFile(outPath, tree)
+	}
+	if err != nil {
+		log.Printf("Failed to write output: %v", err)
+		return exitFailure
+	}
+
+	log.Printf("Converted %s (%s) to %s (%s)", inPath, from, outPath, *to)
+	return exitOK
+}
+
+func runCheck(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("check")
+	opts.register(fs)
+	diagPath := fs.String("diagnostics", "", "Also write diagnostics as JSON to this file")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	opts.addPatterns(fs.Args())
+
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		return failLoad(err, diags, *diagPath)
+	}
+
+	buildIR(p, opts.verbose)
+	diags.print(os.Stderr)
+	if code := writeDiagnostics(diags, *diagPath); code != exitOK {
+		return code
+	}
+
+	if n := diags.count(severityError); n > 0 {
+		fmt.Printf("failed: %d errors, %d warnings\n", n, diags.count(severityWarning))
+		return exitFailure
+	}
+
+	fmt.Printf("ok: %d packages, %d functions, %d warnings\n",
+		len(p.initial), len(p.initialFunctions()), diags.count(severityWarning))
+	return exitOK
+}
+
+// failLoad reports a program that could not be loaded.
+func failLoad(err error, diags *diagnostics, diagPath string) int {
+	diags.print(os.Stderr)
+	writeDiagnostics(diags, diagPath)
+	log.Print(err)
+	return exitFailure
+}
+
+func writeDiagnostics(diags *diagnostics, path string) int {
+	if path == "" {
+		return exitOK
+	}
+	if err := diags.writeFile(path); err != nil {
+		log.Printf("Failed to write diagnostics: %v", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+type packageStats struct {
+	Path         string `json:"path"`
+	Types        int    `json:"types"`
+	Functions    int    `json:"functions"`
+	Blocks       int    `json:"blocks"`
+	Instructions int    `json:"instructions"`
+}
+
+type irStats struct {
+	Packages []packageStats `json:"packages"`
+	Ops      map[string]int `json:"ops"`
+}
+
+func collectStats(hir ir.HybridIR) irStats {
+	stats := irStats{
+		Packages: make([]packageStats, 0),
+		Ops:      make(map[string]int),
+	}
+
+	for _, pkg := range hir.Packages {
+		ps := packageStats{
+			Path:      pkg.Path,
+			Types:     len(pkg.Types),
+			Functions: len(pkg.Functions),
+		}
+		for _, fn := range pkg.Functions {
+			if fn.Body == nil {
+				continue
+			}
+			ps.Blocks += len(fn.Body.Blocks)
+			for _, block := range fn.Body.Blocks {
+				ps.Instructions += len(block.Instructions)
+				for _, inst := range block.Instructions {
+					stats.Ops[inst.Op]++
+				}
+			}
+		}
+		stats.Packages = append(stats.Packages, ps)
+	}
+
+	return stats
+}
+
+func runStats(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("stats")
+	opts.register(fs)
+	asJSON := fs.Bool("json", false, "Print statistics as JSON")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	opts.addPatterns(fs.Args())
+
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		return failLoad(err, diags, "")
+	}
+
+	stats := collectStats(buildIR(p, opts.verbose))
+	diags.print(os.Stderr)
+
+	if *asJSON {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			log.Printf("Failed to marshal statistics: %v", err)
+			return exitFailure
+		}
+		fmt.Println(string(data))
+		return exitOK
+	}
+
+	fmt.Printf("%-40s %8s %10s %8s %13s\n", "PACKAGE", "TYPES", "FUNCTIONS", "BLOCKS", "INSTRUCTIONS")
+	for _, ps := range stats.Packages {
+		fmt.Printf("%-40s %8d %10d %8d %13d\n", ps.Path, ps.Types, ps.Functions, ps.Blocks, ps.Instructions)
+	}
+
+	ops := make([]string, 0, len(stats.Ops))
+	for op := range stats.Ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if stats.Ops[ops[i]] != stats.Ops[ops[j]] {
+			return stats.Ops[ops[i]] > stats.Ops[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+
+	fmt.Println()
+	fmt.Printf("%-40s %8s\n", "OP", "COUNT")
+	for _, op := range ops {
+		fmt.Printf("%-40s %8d\n", op, stats.Ops[op])
+	}
+	return exitOK
+}
+
+func runGraph(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("graph")
+	opts.register(fs)
+	funcName := fs.String("func", "", "Print the control flow graph of this function instead of the call graph")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	opts.addPatterns(fs.Args())
+
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		return failLoad(err, diags, "")
+	}
+
+	diags.print(os.Stderr)
+	if *funcName != "" {
+		matches := findFunctions(p.initialFunctions(), *funcName)
+		if len(matches) != 1 {
+			reportLookup(*funcName, matches)
+			return exitFailure
+		}
+		writeCFG(os.Stdout, matches[0])
+	} else {
+		writeCallGraph(os.Stdout, p.initialFunctions())
+	}
+	return exitOK
+}
+
+func writeCallGraph(w io.Writer, fns []*ssa.Function) {
+	fmt.Fprintln(w, "digraph calls {")
+	fmt.Fprintln(w, "  node [shape=box];")
+	seen := make(map[string]bool)
+	for _, fn := range fns {
+		fmt.Fprintf(w, "  %q;\n", fn.String())
+		for _, block := range fn.Blocks {
+			for _, instr := range block.Instrs {
+				call, ok := instr.(ssa.CallInstruction)
+				if !ok {
+					continue
+				}
+				callee := call.Common().StaticCallee()
+				if callee == nil {
+					continue
+				}
+				edge := fn.String() + "\x00" + callee.String()
+				if seen[edge] {
+					continue
+				}
+				seen[edge] = true
+				fmt.Fprintf(w, "  %q -> %q;\n", fn.String(), callee.String())
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func writeCFG(w io.Writer, fn *ssa.Function) {
+	fmt.Fprintf(w, "digraph %q {\n", fn.String())
+	fmt.Fprintln(w, "  node [shape=box];")
+	for _, block := range fn.Blocks {
+		label := fmt.Sprintf("%d", block.Index)
+		if block.Comment != "" {
+			label += ": " + block.Comment
+		}
+		fmt.Fprintf(w, "  b%d [label=%q];\n", block.Index, label)
+		for _, succ := range block.Succs {
+			fmt.Fprintf(w, "  b%d -> b%d;\n", block.Index, succ.Index)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// findFunctions matches name against a function's plain name ("Pop"), its
+// receiver-qualified name ("Stack.Pop", "(*Stack).Pop") or its package
+// qualified name ("main.main").
+func findFunctions(fns []*ssa.Function, name string) []*ssa.Function {
+	matches := make([]*ssa.Function, 0)
+	for _, fn := range fns {
+		for _, candidate := range functionNames(fn) {
+			if candidate == name {
+				matches = append(matches, fn)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func functionNames(fn *ssa.Function) []string {
+	names := []string{fn.Name(), fn.String(), fn.RelString(fn.Pkg.Pkg), fn.Pkg.Pkg.Name() + "." + fn.Name()}
+	if recv := fn.Signature.Recv(); recv != nil {
+		names = append(names, receiverBaseName(recv.Type())+"."+fn.Name())
+	}
+	return names
+}
+
+func receiverBaseName(t types.Type) string {
+	name := types.TypeString(t, nil)
+	name = strings.TrimPrefix(name, "*")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func reportLookup(name string, matches []*ssa.Function) {
+	if len(matches) == 0 {
+		log.Printf("No function named %q", name)
+		return
+	}
+	log.Printf("Function name %q is ambiguous:", name)
+	for _, fn := range matches {
+		log.Printf("    %s", fn.String())
+	}
+}
+
+func runReport(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("report")
+	opts.register(fs)
+	format := fs.String("format", "markdown", "Output format: markdown or json")
+	outputPath := fs.String("output", "", "Write the report to this file instead of stdout")
+	failUnsupported := fs.Bool("fail-unsupported", false, "Exit with status 1 when unsupported features are used")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	opts.addPatterns(fs.Args())
+
+	if *format != "markdown" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "gonim-compile report: unknown format %q\n", *format)
+		return exitUsage
+	}
+
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		return failLoad(err, diags, "")
+	}
+	if opts.verbose {
+		diags.print(os.Stderr)
+	}
+
+	report := buildReport(p)
+
+	var buf bytes.Buffer
+	if *format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Printf("Failed to marshal report: %v", err)
+			return exitFailure
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	} else {
+		writeReportMarkdown(&buf, report)
+	}
+
+	if *outputPath != "" {
+		if err := os.WriteFile(*outputPath, buf.Bytes(), 0644); err != nil {
+			log.Printf("Failed to write report: %v", err)
+			return exitFailure
+		}
+	} else {
+		os.Stdout.Write(buf.Bytes())
+	}
+
+	if *failUnsupported && report.Summary.UnsupportedFunctions > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func runExplain(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("explain")
+	opts.register(fs)
+	asJSON := fs.Bool("json", false, "Print the function IR as JSON")
+	showSSA := fs.Bool("ssa", false, "Also print the SSA listing the IR was generated from")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	name := fs.Arg(0)
+
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		return failLoad(err, diags, "")
+	}
+
+	matches := findFunctions(p.initialFunctions(), name)
+	if len(matches) != 1 {
+		reportLookup(name, matches)
+		return exitFailure
+	}
+	fn := matches[0]
+
+	fnIR, unmapped := processFunction(fn, fn.Pkg, findGoPackage(fn.Pkg, p.initial), p.stdlib, p.locals)
+	p.stdlib.report(unmapped)
+	diags.print(os.Stderr)
+
+	if *asJSON {
+		data, err := json.MarshalIndent(fnIR, "", "  ")
+		if err != nil {
+			log.Printf("Failed to marshal IR: %v", err)
+			return exitFailure
+		}
+		fmt.Println(string(data))
+	} else {
+		writeFunctionIR(os.Stdout, fnIR)
+	}
+
+	if *showSSA {
+		var buf bytes.Buffer
+		ssa.WriteFunction(&buf, fn)
+		fmt.Println()
+		os.Stdout.Write(buf.Bytes())
+	}
+	return exitOK
+}
+
+func writeFunctionIR(w io.Writer, fnIR ir.FunctionIR) {
+	fmt.Fprintf(w, "func %s.%s%s\n", fnIR.Package, fnIR.Name, formatSignature(fnIR))
+
+	if fnIR.Body == nil {
+		fmt.Fprintln(w, "  (external, no body)")
+		return
+	}
+
+	for _, local := range fnIR.Body.Locals {
+		fmt.Fprintf(w, "  local %s %s\n", local.Name, local.Type)
+	}
+	if len(fnIR.Body.FreeVars) > 0 {
+		fmt.Fprintf(w, "  free vars: %s\n", strings.Join(fnIR.Body.FreeVars, ", "))
+	}
+
+	for _, block := range fnIR.Body.Blocks {
+		fmt.Fprintf(w, "\nblock %d", block.ID)
+		if block.Comment != "" {
+			fmt.Fprintf(w, " (%s)", block.Comment)
+		}
+		fmt.Fprintf(w, " -> %v\n", block.Successors)
+		for _, inst := range block.Instructions {
+			line := inst.Op
+			if len(inst.Args) > 0 {
+				line += " " + strings.Join(inst.Args, ", ")
+			}
+			if inst.Result != "" {
+				line = fmt.Sprintf("%s = %s", inst.Result, line)
+			}
+			if inst.Type != "" {
+				line += " : " + inst.Type
+			}
+			fmt.Fprintf(w, "  %-60s # %s\n", line, inst.Comment)
+		}
+	}
+}
+
+func formatSignature(fnIR ir.FunctionIR) string {
+	params := make([]string, 0, len(fnIR.Signature.Params))
+	for _, p := range fnIR.Signature.Params {
+		params = append(params, strings.TrimSpace(p.Name+" "+p.Type))
+	}
+	results := make([]string, 0, len(fnIR.Signature.Results))
+	for _, r := range fnIR.Signature.Results {
+		results = append(results, strings.TrimSpace(r.Name+" "+r.Type))
+	}
+
+	sig := "(" + strings.Join(params, ", ") + ")"
+	switch len(results) {
+	case 0:
+	case 1:
+		sig += " " + results[0]
+	default:
+		sig += " (" + strings.Join(results, ", ") + ")"
+	}
+	if fnIR.Receiver != nil {
+		recv := fnIR.Receiver.Type
+		if fnIR.Receiver.Pointer {
+			recv = "*" + recv
+		}
+		sig = " [recv " + recv + "]" + sig
+	}
+	return sig
+}
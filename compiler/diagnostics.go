@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/packages"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityNote    = "note"
+)
+
+type diagnostics struct {
+	list []ir.Diagnostic
+}
+
+func newDiagnostics() *diagnostics {
+	return &diagnostics{list: make([]ir.Diagnostic, 0)}
+}
+
+func (ds *diagnostics) add(d ir.Diagnostic) {
+	ds.list = append(ds.list, d)
+}
+
+func (ds *diagnostics) addAt(fset *token.FileSet, pos token.Pos, pkgPath, severity, code, message, suggestion string) {
+	ds.add(diagnosticAt(fset, pos, pkgPath, severity, code, message, suggestion))
+}
+
+func diagnosticAt(fset *token.FileSet, pos token.Pos, pkgPath, severity, code, message, suggestion string) ir.Diagnostic {
+	d := ir.Diagnostic{
+		Severity:   severity,
+		Code:       code,
+		Package:    pkgPath,
+		Message:    message,
+		Suggestion: suggestion,
+	}
+	if pos.IsValid() {
+		p := fset.Position(pos)
+		d.File, d.Line, d.Column = p.Filename, p.Line, p.Column
+	}
+	return d
+}
+
+func (ds *diagnostics) count(severity string) int {
+	n := 0
+	for _, d := range ds.list {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// sorted returns the diagnostics ordered by file and position, keeping the
+// order of insertion for diagnostics at the same place.
+func (ds *diagnostics) sorted() []ir.Diagnostic {
+	list := append([]ir.Diagnostic(nil), ds.list...)
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	if list == nil {
+		list = make([]ir.Diagnostic, 0)
+	}
+	return list
+}
+
+// relativeDiagnostics returns diags with file names relative to the working
+// directory, using forward slashes, so the IR does not depend on where the
+// sources are checked out.
+func relativeDiagnostics(diags []ir.Diagnostic) []ir.Diagnostic {
+	rel := make([]ir.Diagnostic, len(diags))
+	for i, d := range diags {
+		d.File = relativePath(d.File)
+		rel[i] = d
+	}
+	return rel
+}
+
+// relativePath returns an absolute path relative to the working directory,
+// using forward slashes; other paths are returned unchanged.
+func relativePath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if r, err := filepath.Rel(wd, path); err == nil {
+		return filepath.ToSlash(r)
+	}
+	return path
+}
+
+func (ds *diagnostics) print(w io.Writer) {
+	for _, d := range ds.sorted() {
+		fmt.Fprintln(w, d.String())
+		if d.Suggestion != "" {
+			fmt.Fprintf(w, "\t%s: %s\n", severityNote, d.Suggestion)
+		}
+	}
+}
+
+func (ds *diagnostics) writeFile(path string) error {
+	data, err := json.MarshalIndent(ds.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// addPackageErrors records the errors reported by go/packages for pkgs and
+// their dependencies.
+func (ds *diagnostics) addPackageErrors(pkgs []*packages.Package) {
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			d := ir.Diagnostic{
+				Severity: severityError,
+				Code:     packageErrorCode(err.Kind),
+				Package:  pkg.PkgPath,
+				Message:  err.Msg,
+			}
+			d.File, d.Line, d.Column = parsePosition(err.Pos)
+			ds.add(d)
+		}
+	})
+}
+
+func packageErrorCode(kind packages.ErrorKind) string {
+	switch kind {
+	case packages.ListError:
+		return "load-error"
+	case packages.ParseError:
+		return "parse-error"
+	case packages.TypeError:
+		return "type-error"
+	default:
+		return "unknown-error"
+	}
+}
+
+// parsePosition splits a go/packages position of the form file:line:col,
+// file:line or file.
+func parsePosition(pos string) (string, int, int) {
+	if pos == "" || pos == "-" {
+		return "", 0, 0
+	}
+
+	parts := strings.Split(pos, ":")
+	nums := make([]int, 0, 2)
+	for len(parts) > 1 && len(nums) < 2 {
+		n, err := strconv.Atoi(parts[len(parts)-1])
+		if err != nil {
+			break
+		}
+		nums = append([]int{n}, nums...)
+		parts = parts[:len(parts)-1]
+	}
+
+	file := strings.Join(parts, ":")
+	switch len(nums) {
+	case 2:
+		return file, nums[0], nums[1]
+	case 1:
+		return file, nums[0], 0
+	default:
+		return file, 0, 0
+	}
+}
+
+var unsupportedImports = map[string]struct {
+	code       string
+	message    string
+	suggestion string
+}{
+	"unsafe": {
+		code:       "unsupported-unsafe",
+		message:    "package unsafe is not supported; pointer arithmetic and unsafe conversions are not translated",
+		suggestion: "isolate unsafe code behind a function and provide a hand-written Nim implementation",
+	},
+	"reflect": {
+		code:       "unsupported-reflect",
+		message:    "package reflect is not supported by the Nim runtime",
+		suggestion: "replace reflection with type switches or generated code",
+	},
+}
+
+// scanUnsupported reports Go features the transpiler cannot handle yet.
+func (ds *diagnostics) scanUnsupported(pkg *packages.Package) {
+	for _, file := range pkg.Syntax {
+		usesCgo := false
+		for _, spec := range file.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			if path == "C" {
+				usesCgo = true
+			}
+			if u, ok := unsupportedImports[path]; ok {
+				ds.addAt(pkg.Fset, spec.Pos(), pkg.PkgPath, severityWarning, u.code, u.message, u.suggestion)
+			}
+		}
+
+		for _, cg := range file.Comments {
+			for _, c := range cg.List {
+				ds.scanDirective(pkg, c, usesCgo)
+			}
+		}
+	}
+}
+
+func (ds *diagnostics) scanDirective(pkg *packages.Package, c *ast.Comment, usesCgo bool) {
+	switch {
+	case strings.HasPrefix(c.Text, "//go:linkname "):
+		ds.addAt(pkg.Fset, c.Pos(), pkg.PkgPath, severityWarning, "unsupported-linkname",
+			"//go:linkname directives are ignored; the linked symbol will be unresolved",
+			"call the target through its public API or provide the symbol in Nim")
+	case usesCgo && strings.HasPrefix(c.Text, "//export "):
+		ds.addAt(pkg.Fset, c.Pos(), pkg.PkgPath, severityWarning, "unsupported-cgo-export",
+			fmt.Sprintf("cgo callback %s cannot be exported to C", strings.TrimSpace(strings.TrimPrefix(c.Text, "//export "))),
+			"export the function from Nim with {.exportc.} by hand")
+	}
+}
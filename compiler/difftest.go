@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	diffPass  = "pass"
+	diffFail  = "fail"
+	diffError = "error"
+
+	sectionMatch     = "match"
+	sectionReordered = "reordered"
+	sectionMismatch  = "mismatch"
+	sectionMissing   = "missing"
+	sectionExtra     = "extra"
+)
+
+// startSection names the output printed before the first section header.
+const startSection = "(start)"
+
+// DiffReport is the result of running every program of a corpus with Go and
+// through the transpiler.
+type DiffReport struct {
+	Programs []DiffProgram `json:"programs"`
+	Features []FeatureRow  `json:"features"`
+	Summary  DiffSummary   `json:"summary"`
+}
+
+type DiffSummary struct {
+	Programs int `json:"programs"`
+	Passed   int `json:"passed"`
+	Failed   int `json:"failed"`
+	Errors   int `json:"errors"`
+}
+
+// DiffProgram compares the standard output and exit code of one program.
+// Stage names the step that failed when Status is "error": go build, go run,
+// transpile, backend, nim or run.
+type DiffProgram struct {
+	Program  string        `json:"program"`
+	Status   string        `json:"status"`
+	Stage    string        `json:"stage,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	GoExit   int           `json:"go_exit"`
+	NimExit  int           `json:"nim_exit"`
+	Features []string      `json:"features"`
+	Sections []DiffSection `json:"sections"`
+}
+
+// DiffSection compares one section of the output, as delimited by the
+// section header pattern. Detail shows the first differing line.
+type DiffSection struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// FeatureRow holds the result of every program using a language feature.
+type FeatureRow struct {
+	Feature string            `json:"feature"`
+	Results map[string]string `json:"results"`
+}
+
+func runDiffTest(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("difftest")
+	opts.register(fs)
+	backend := fs.String("backend", "", "Nim backend executable (default gonim-backend next to this binary, or in $PATH)")
+	nim := fs.String("nim", "nim", "Nim compiler")
+	workDir := fs.String("work", "", "Keep the IR, Nim code and binaries of every program in this directory (default: a temporary directory)")
+	timeout := fs.Duration("timeout", 30*time.Second, "Time limit for running each binary")
+	section := fs.String("section", `^\[\d+\] (?:Testing )?(.+?)\.*$`, "Pattern of the lines that start an output section; the first group names it")
+	var masks stringList
+	fs.Var(&masks, "mask", "Pattern replaced in both outputs before comparing, e.g. timestamps; may be repeated")
+	strict := fs.Bool("strict", false, "Fail sections whose lines only differ in order instead of accepting goroutine reordering")
+	format := fs.String("format", "markdown", "Output format: markdown or json")
+	outputPath := fs.String("output", "", "Write the report to this file instead of stdout")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *format != "markdown" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "gonim-compile difftest: unknown format %q\n", *format)
+		return exitUsage
+	}
+	corpus := append(opts.patterns, fs.Args()...)
+	if len(corpus) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	r := &diffRunner{
+		opts:    opts,
+		backend: *backend,
+		nim:     *nim,
+		timeout: *timeout,
+		strict:  *strict,
+	}
+	var err error
+	if r.section, err = regexp.Compile(*section); err != nil {
+		fmt.Fprintf(os.Stderr, "gonim-compile difftest: invalid -section: %v\n", err)
+		return exitUsage
+	}
+	for _, mask := range masks {
+		re, err := regexp.Compile(mask)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gonim-compile difftest: invalid -mask: %v\n", err)
+			return exitUsage
+		}
+		r.masks = append(r.masks, re)
+	}
+	if r.backend == "" {
+		r.backend = defaultBackend()
+	}
+
+	programs, err := corpusPrograms(corpus)
+	if err != nil {
+		log.Print(err)
+		return exitFailure
+	}
+
+	r.work = *workDir
+	if r.work == "" {
+		if r.work, err = os.MkdirTemp("", "gonim-difftest"); err != nil {
+			log.Printf("Failed to create work directory: %v", err)
+			return exitFailure
+		}
+		defer os.RemoveAll(r.work)
+	}
+
+	report := DiffReport{Programs: make([]DiffProgram, 0, len(programs))}
+	for _, program := range programs {
+		result := r.run(program)
+		log.Printf("%s: %s", program, result.Status)
+		report.Programs = append(report.Programs, result)
+	}
+	report.summarize()
+
+	var buf bytes.Buffer
+	if *format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Printf("Failed to marshal report: %v", err)
+			return exitFailure
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	} else {
+		writeDiffMarkdown(&buf, report)
+	}
+
+	if *outputPath != "" {
+		if err := os.WriteFile(*outputPath, buf.Bytes(), 0644); err != nil {
+			log.Printf("Failed to write report: %v", err)
+			return exitFailure
+		}
+	} else {
+		os.Stdout.Write(buf.Bytes())
+	}
+
+	if report.Summary.Passed != report.Summary.Programs {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// defaultBackend returns the backend installed next to the running frontend,
+// as build.sh does, or the one in $PATH.
+func defaultBackend() string {
+	if exe, err := os.Executable(); err == nil {
+		path := filepath.Join(filepath.Dir(exe), "gonim-backend")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return "gonim-backend"
+}
+
+// corpusPrograms expands the corpus: a .go file is a program, a directory
+// contributes each .go file in it and each subdirectory with Go files as a
+// package.
+func corpusPrograms(corpus []string) ([]string, error) {
+	programs := make([]string, 0)
+	for _, entry := range corpus {
+		info, err := os.Stat(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid corpus entry: %w", err)
+		}
+		if !info.IsDir() {
+			programs = append(programs, entry)
+			continue
+		}
+
+		entries, err := os.ReadDir(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid corpus entry: %w", err)
+		}
+		for _, e := range entries {
+			path := filepath.Join(entry, e.Name())
+			switch {
+			case e.IsDir():
+				if files, _ := filepath.Glob(filepath.Join(path, "*.go")); len(files) > 0 {
+					programs = append(programs, path)
+				}
+			case strings.HasSuffix(e.Name(), ".go") && !strings.HasSuffix(e.Name(), "_test.go"):
+				programs = append(programs, path)
+			}
+		}
+	}
+	return programs, nil
+}
+
+// diffRunner builds and runs programs with Go and through the transpiler:
+// frontend, backend and Nim compiler.
+type diffRunner struct {
+	opts    loadOptions
+	work    string
+	backend string
+	nim     string
+	timeout time.Duration
+	section *regexp.Regexp
+	masks   []*regexp.Regexp
+	strict  bool
+}
+
+func (r *diffRunner) run(program string) DiffProgram {
+	result := DiffProgram{
+		Program:  program,
+		Status:   diffError,
+		Features: make([]string, 0),
+		Sections: make([]DiffSection, 0),
+	}
+	fail := func(stage string, err error) DiffProgram {
+		result.Stage = stage
+		result.Error = err.Error()
+		return result
+	}
+
+	dir := filepath.Join(r.work, unsafeFileChars.ReplaceAllString(program, "_"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fail("go build", err)
+	}
+
+	goBin, err := filepath.Abs(filepath.Join(dir, "go_main"))
+	if err != nil {
+		return fail("go build", err)
+	}
+	srcDir, pattern := target(program)
+	build := append([]string{"go", "build"}, r.opts.config().BuildFlags...)
+	if _, err := r.command(srcDir, append(build, "-o", goBin, pattern)...); err != nil {
+		return fail("go build", err)
+	}
+	goOut, goExit, err := r.execute(goBin)
+	if err != nil {
+		return fail("go run", err)
+	}
+	result.GoExit = goExit
+
+	irPath := filepath.Join(dir, "ir.json")
+	features, err := r.transpile(program, irPath)
+	result.Features = features
+	if err != nil {
+		return fail("transpile", err)
+	}
+
+	nimDir := filepath.Join(dir, "nim")
+	if _, err := r.command("", r.backend, "-i", irPath, "-o", nimDir); err != nil {
+		return fail("backend", err)
+	}
+	nimBin := filepath.Join(dir, "nim_main")
+	if _, err := r.command("", r.nim, "c", "-d:release", "--hints:off", "-o:"+nimBin, filepath.Join(nimDir, "main.nim")); err != nil {
+		return fail("nim", err)
+	}
+	nimOut, nimExit, err := r.execute(nimBin)
+	if err != nil {
+		return fail("run", err)
+	}
+	result.NimExit = nimExit
+
+	result.Sections = r.compare(goOut, nimOut)
+	result.Status = diffPass
+	if goExit != nimExit {
+		result.Status = diffFail
+	}
+	for _, s := range result.Sections {
+		if s.Status != sectionMatch && (s.Status != sectionReordered || r.strict) {
+			result.Status = diffFail
+		}
+	}
+	return result
+}
+
+// target returns the directory to build program in, so that it is resolved
+// against its own module, and the pattern naming it there.
+func target(program string) (string, string) {
+	if info, err := os.Stat(program); err == nil && info.IsDir() {
+		return program, "."
+	}
+	return filepath.Dir(program), filepath.Base(program)
+}
+
+// transpile writes the IR of program and returns the language features it
+// uses. Errors in the diagnostics fail the transpilation.
+func (r *diffRunner) transpile(program, irPath string) ([]string, error) {
+	opts := r.opts
+	dir, pattern := target(program)
+	opts.dir = dir
+	opts.patterns = stringList{pattern}
+
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		return make([]string, 0), diagnosticsError(err, diags)
+	}
+
+	features := make(map[string]bool)
+	for _, pr := range buildReport(p).Packages {
+		for _, f := range pr.Features {
+			features[f] = true
+		}
+	}
+
+	hir := buildIR(p, opts.verbose)
+	if diags.count(severityError) > 0 {
+		return sortedKeys(features), diagnosticsError(errors.New("transpilation failed"), diags)
+	}
+	return sortedKeys(features), writeJSONFile(irPath, hir)
+}
+
+// diagnosticsError adds the first error diagnostic to err.
+func diagnosticsError(err error, diags *diagnostics) error {
+	for _, d := range diags.sorted() {
+		if d.Severity == severityError {
+			return fmt.Errorf("%w: %s", err, d)
+		}
+	}
+	return err
+}
+
+// command runs a build step in dir and returns its output, which is added
+// to the error when it fails.
+func (r *diffRunner) command(dir string, args ...string) ([]byte, error) {
+	if r.opts.verbose {
+		log.Printf("Running %s", strings.Join(args, " "))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = r.opts.config().Env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s: %w\n%s", filepath.Base(args[0]), err, lastLines(out, 10))
+	}
+	return out, nil
+}
+
+// execute runs a built program within the time limit and returns its
+// standard output and exit code. A non-zero exit is not an error.
+func (r *diffRunner) execute(bin string) (string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = io.Discard
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", 0, fmt.Errorf("timed out after %s", r.timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return stdout.String(), 0, nil
+}
+
+func lastLines(out []byte, n int) string {
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+type outputSection struct {
+	name  string
+	lines []string
+}
+
+// sections normalizes out and splits it at the section headers.
+func (r *diffRunner) sections(out string) []outputSection {
+	out = strings.ReplaceAll(out, "\r\n", "\n")
+	for _, re := range r.masks {
+		out = re.ReplaceAllString(out, "<masked>")
+	}
+
+	sections := []outputSection{{name: startSection}}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if m := r.section.FindStringSubmatch(line); m != nil {
+			name := m[0]
+			if len(m) > 1 {
+				name = m[1]
+			}
+			sections = append(sections, outputSection{name: name})
+		}
+		if line != "" {
+			s := &sections[len(sections)-1]
+			s.lines = append(s.lines, line)
+		}
+	}
+	if len(sections[0].lines) == 0 {
+		sections = sections[1:]
+	}
+	return sections
+}
+
+// compare matches the sections of the Go output with those of the Nim
+// output by name. Sections whose lines only differ in order are reordered,
+// as goroutines may interleave their output differently.
+func (r *diffRunner) compare(goOut, nimOut string) []DiffSection {
+	nimSections := make(map[string][]outputSection)
+	var nimOrder []string
+	for _, s := range r.sections(nimOut) {
+		if _, ok := nimSections[s.name]; !ok {
+			nimOrder = append(nimOrder, s.name)
+		}
+		nimSections[s.name] = append(nimSections[s.name], s)
+	}
+
+	result := make([]DiffSection, 0)
+	for _, want := range r.sections(goOut) {
+		candidates := nimSections[want.name]
+		if len(candidates) == 0 {
+			result = append(result, DiffSection{Name: want.name, Status: sectionMissing})
+			continue
+		}
+		got := candidates[0]
+		nimSections[want.name] = candidates[1:]
+		result = append(result, compareSection(want, got))
+	}
+	for _, name := range nimOrder {
+		for range nimSections[name] {
+			result = append(result, DiffSection{Name: name, Status: sectionExtra})
+		}
+	}
+	return result
+}
+
+func compareSection(want, got outputSection) DiffSection {
+	ds := DiffSection{Name: want.name, Status: sectionMatch}
+	if equalLines(want.lines, got.lines) {
+		return ds
+	}
+
+	sortedWant := append([]string(nil), want.lines...)
+	sortedGot := append([]string(nil), got.lines...)
+	sort.Strings(sortedWant)
+	sort.Strings(sortedGot)
+	if equalLines(sortedWant, sortedGot) {
+		ds.Status = sectionReordered
+		return ds
+	}
+
+	ds.Status = sectionMismatch
+	for i := 0; ; i++ {
+		var w, g string
+		if i < len(want.lines) {
+			w = want.lines[i]
+		}
+		if i < len(got.lines) {
+			g = got.lines[i]
+		}
+		if w != g {
+			ds.Detail = fmt.Sprintf("line %d: go %q, nim %q", i+1, w, g)
+			return ds
+		}
+	}
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// summarize counts the results and builds the feature matrix.
+func (report *DiffReport) summarize() {
+	byFeature := make(map[string]map[string]string)
+	for _, pr := range report.Programs {
+		report.Summary.Programs++
+		switch pr.Status {
+		case diffPass:
+			report.Summary.Passed++
+		case diffFail:
+			report.Summary.Failed++
+		default:
+			report.Summary.Errors++
+		}
+		for _, f := range pr.Features {
+			if byFeature[f] == nil {
+				byFeature[f] = make(map[string]string)
+			}
+			byFeature[f][pr.Program] = pr.Status
+		}
+	}
+
+	report.Features = make([]FeatureRow, 0, len(byFeature))
+	for f, results := range byFeature {
+		report.Features = append(report.Features, FeatureRow{Feature: f, Results: results})
+	}
+	sort.Slice(report.Features, func(i, j int) bool { return report.Features[i].Feature < report.Features[j].Feature })
+}
+
+var diffMarks = map[string]string{
+	diffPass:         "✓",
+	diffFail:         "✗",
+	diffError:        "error",
+	sectionMatch:     "✓",
+	sectionReordered: "✓ (reordered)",
+	sectionMismatch:  "✗",
+	sectionMissing:   "✗ missing",
+	sectionExtra:     "✗ extra",
+}
+
+func writeDiffMarkdown(w io.Writer, report DiffReport) {
+	fmt.Fprintln(w, "# go2nim differential test report")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%d programs: %d passed, %d failed, %d errors.\n",
+		report.Summary.Programs, report.Summary.Passed, report.Summary.Failed, report.Summary.Errors)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Program | Result | Go exit | Nim exit | Sections |")
+	fmt.Fprintln(w, "|---------|--------|---------|----------|----------|")
+	for _, pr := range report.Programs {
+		result := diffMarks[pr.Status]
+		if pr.Status == diffError {
+			result = "error (" + pr.Stage + ")"
+		}
+		matched := 0
+		for _, s := range pr.Sections {
+			if s.Status == sectionMatch || s.Status == sectionReordered {
+				matched++
+			}
+		}
+		goExit, nimExit := fmt.Sprint(pr.GoExit), fmt.Sprint(pr.NimExit)
+		if pr.Status == diffError {
+			nimExit = "-"
+			if pr.Stage == "go build" || pr.Stage == "go run" {
+				goExit = "-"
+			}
+		}
+		fmt.Fprintf(w, "| `%s` | %s | %s | %s | %d/%d |\n", pr.Program, result, goExit, nimExit, matched, len(pr.Sections))
+	}
+
+	if len(report.Features) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "## Features")
+		fmt.Fprintln(w)
+		header := "| Feature |"
+		rule := "|---------|"
+		for _, pr := range report.Programs {
+			header += " `" + pr.Program + "` |"
+			rule += "---|"
+		}
+		fmt.Fprintln(w, header)
+		fmt.Fprintln(w, rule)
+		for _, row := range report.Features {
+			line := "| " + row.Feature + " |"
+			for _, pr := range report.Programs {
+				mark := "-"
+				if status, ok := row.Results[pr.Program]; ok {
+					mark = diffMarks[status]
+				}
+				line += " " + mark + " |"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	for _, pr := range report.Programs {
+		if pr.Status == diffPass {
+			continue
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "## `%s`\n", pr.Program)
+		fmt.Fprintln(w)
+		if pr.Status == diffError {
+			fmt.Fprintf(w, "%s failed:\n\n```\n%s\n```\n", pr.Stage, pr.Error)
+			continue
+		}
+		if pr.GoExit != pr.NimExit {
+			fmt.Fprintf(w, "Exit code %d, want %d.\n\n", pr.NimExit, pr.GoExit)
+		}
+		fmt.Fprintln(w, "| Section | Result | Detail |")
+		fmt.Fprintln(w, "|---------|--------|--------|")
+		for _, s := range pr.Sections {
+			detail := s.Detail
+			if detail == "" {
+				detail = "-"
+			}
+			fmt.Fprintf(w, "| %s | %s | %s |\n", s.Name, diffMarks[s.Status], strings.ReplaceAll(detail, "|", `\|`))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// declDocs maps the package-level objects of a package to the text of their
+// doc comments.
+type declDocs map[types.Object]string
+
+// collectDocs reads the doc comments of the type, const, var and func
+// declarations of pkg. A spec inside a parenthesized group falls back to
+// the doc comment of the group when it has none of its own.
+func collectDocs(pkg *packages.Package) declDocs {
+	docs := make(declDocs)
+	if pkg == nil || pkg.TypesInfo == nil {
+		return docs
+	}
+
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if obj := pkg.TypesInfo.Defs[decl.Name]; obj != nil {
+					docs[obj] = decl.Doc.Text()
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						docs.add(pkg.TypesInfo, spec.Name, spec.Doc, decl.Doc)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							docs.add(pkg.TypesInfo, name, spec.Doc, decl.Doc)
+						}
+					}
+				}
+			}
+		}
+	}
+	return docs
+}
+
+func (d declDocs) add(info *types.Info, name *ast.Ident, doc, groupDoc *ast.CommentGroup) {
+	obj := info.Defs[name]
+	if obj == nil {
+		return
+	}
+	if doc == nil {
+		doc = groupDoc
+	}
+	d[obj] = doc.Text()
+}
+
+// deprecation returns the text of the "Deprecated: " paragraph of a doc
+// comment, the convention Go tooling uses to mark deprecated declarations.
+func deprecation(doc string) string {
+	for _, para := range strings.Split(doc, "\n\n") {
+		if text, ok := strings.CutPrefix(para, "Deprecated: "); ok {
+			return strings.Join(strings.Fields(text), " ")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"go/types"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+// encodeInstruction sets the structured encoding of instr on inst.
+func encodeInstruction(names localNames, instr ssa.Instruction, inst *ir.Instruction) {
+	if v, ok := instr.(ssa.Value); ok {
+		if t, ok := v.Type().(*types.Tuple); ok {
+			inst.Tuple = make([]string, 0, t.Len())
+			for i := 0; i < t.Len(); i++ {
+				inst.Tuple = append(inst.Tuple, names.typeString(t.At(i).Type()))
+			}
+		}
+	}
+
+	switch v := instr.(type) {
+	case *ssa.Alloc:
+		inst.Alloc = &ir.AllocIR{
+			Elem: names.typeString(deref(v.Type())),
+			Heap: v.Heap,
+			Var:  v.Comment,
+		}
+	case *ssa.BinOp:
+		inst.Operation = &ir.OperationIR{Operator: v.Op.String(), X: optionalOperand(names, v.X), Y: optionalOperand(names, v.Y)}
+	case *ssa.UnOp:
+		inst.Operation = &ir.OperationIR{Operator: v.Op.String(), X: optionalOperand(names, v.X), CommaOk: v.CommaOk}
+	case *ssa.Phi:
+		phi := &ir.PhiIR{Edges: make([]ir.PhiEdge, 0, len(v.Edges)), Var: v.Comment}
+		for i, e := range v.Edges {
+			phi.Edges = append(phi.Edges, ir.PhiEdge{Block: v.Block().Preds[i].Index, Value: optionalOperand(names, e)})
+		}
+		inst.Phi = phi
+	case *ssa.Slice:
+		inst.Slice = &ir.SliceIR{
+			X:    optionalOperand(names, v.X),
+			Kind: accessKind(v.X.Type()),
+			Low:  optionalOperand(names, v.Low),
+			High: optionalOperand(names, v.High),
+			Max:  optionalOperand(names, v.Max),
+		}
+	case *ssa.IndexAddr:
+		inst.Access = indexAccess(names, v.X, v.Index)
+	case *ssa.Index:
+		inst.Access = indexAccess(names, v.X, v.Index)
+	case *ssa.Lookup:
+		inst.Access = mapAccess(names, v.X, v.Index, nil)
+		inst.Access.CommaOk = v.CommaOk
+	case *ssa.MapUpdate:
+		inst.Access = mapAccess(names, v.Map, v.Key, v.Value)
+	case *ssa.FieldAddr:
+		inst.Field = fieldAccess(names, v.X, deref(v.X.Type()), v.Field)
+	case *ssa.Field:
+		inst.Field = fieldAccess(names, v.X, v.X.Type(), v.Field)
+	case *ssa.Convert:
+		inst.Convert = conversion(names, v.X, v.Type())
+	case *ssa.ChangeType:
+		inst.Convert = conversion(names, v.X, v.Type())
+	case *ssa.ChangeInterface:
+		inst.Convert = conversion(names, v.X, v.Type())
+	case *ssa.MultiConvert:
+		inst.Convert = conversion(names, v.X, v.Type())
+	case *ssa.SliceToArrayPointer:
+		inst.Convert = conversion(names, v.X, v.Type())
+	case *ssa.MakeInterface:
+		inst.Convert = conversion(names, v.X, v.Type())
+	case *ssa.TypeAssert:
+		inst.TypeAssert = &ir.TypeAssertIR{
+			X:         optionalOperand(names, v.X),
+			Asserted:  names.typeString(v.AssertedType),
+			Interface: types.IsInterface(v.AssertedType),
+			CommaOk:   v.CommaOk,
+		}
+	case *ssa.MakeSlice:
+		inst.Make = &ir.MakeIR{Len: optionalOperand(names, v.Len), Cap: optionalOperand(names, v.Cap), ElemType: elemType(names, v.Type())}
+	case *ssa.MakeMap:
+		m := coreType(v.Type()).(*types.Map)
+		inst.Make = &ir.MakeIR{Reserve: optionalOperand(names, v.Reserve), KeyType: names.typeString(m.Key()), ElemType: names.typeString(m.Elem())}
+	case *ssa.MakeChan:
+		inst.Make = &ir.MakeIR{Size: optionalOperand(names, v.Size), ElemType: elemType(names, v.Type())}
+	case *ssa.MakeClosure:
+		mk := &ir.MakeIR{Fn: optionalOperand(names, v.Fn), Bindings: make([]string, 0, len(v.Bindings))}
+		for _, b := range v.Bindings {
+			mk.Bindings = append(mk.Bindings, optionalOperand(names, b))
+		}
+		inst.Make = mk
+	case *ssa.Range:
+		rng := &ir.RangeIR{X: optionalOperand(names, v.X), Kind: accessKind(v.X.Type())}
+		rng.KeyType, rng.ValueType = rangeTypes(names, v.X.Type())
+		inst.Range = rng
+	case *ssa.Next:
+		rng := &ir.RangeIR{X: optionalOperand(names, v.Iter), Kind: ir.KindMap}
+		if v.IsString {
+			rng.Kind = ir.KindString
+		}
+		// The tuple has invalid key and value types when they are unused.
+		if r, ok := v.Iter.(*ssa.Range); ok {
+			rng.KeyType, rng.ValueType = rangeTypes(names, r.X.Type())
+		}
+		inst.Range = rng
+	case *ssa.Select:
+		sel := &ir.SelectIR{Blocking: v.Blocking, States: make([]ir.SelectState, 0, len(v.States))}
+		for _, st := range v.States {
+			dir := "recv"
+			if st.Dir == types.SendOnly {
+				dir = "send"
+			}
+			sel.States = append(sel.States, ir.SelectState{Dir: dir, Chan: optionalOperand(names, st.Chan), Send: optionalOperand(names, st.Send)})
+		}
+		inst.Select = sel
+	case *ssa.Extract:
+		inst.Extract = &ir.ExtractIR{Tuple: optionalOperand(names, v.Tuple), Index: v.Index}
+	case *ssa.Return:
+		ret := &ir.ReturnIR{Values: make([]string, 0, len(v.Results))}
+		for _, r := range v.Results {
+			ret.Values = append(ret.Values, optionalOperand(names, r))
+		}
+		if results := v.Parent().Signature.Results(); results.Len() > 0 && results.At(0).Name() != "" {
+			for i := 0; i < results.Len(); i++ {
+				ret.Names = append(ret.Names, results.At(i).Name())
+			}
+		}
+		inst.Return = ret
+	case ssa.CallInstruction:
+		inst.Call = encodeCall(names, v.Common(), v.Parent().Pkg)
+	case *ssa.DebugRef:
+		inst.DebugRef = &ir.DebugRefIR{X: optionalOperand(names, v.X), Expr: types.ExprString(v.Expr), IsAddr: v.IsAddr}
+	}
+}
+
+// encodeCall classifies call, made in package from, and resolves its target.
+func encodeCall(names localNames, call *ssa.CallCommon, from *ssa.Package) *ir.CallIR {
+	c := &ir.CallIR{Kind: ir.CallClosure, Value: optionalOperand(names, call.Value), Args: make([]string, 0, len(call.Args))}
+	for _, arg := range call.Args {
+		c.Args = append(c.Args, optionalOperand(names, arg))
+	}
+	if call.IsInvoke() {
+		c.Kind, c.Recv, c.Method = ir.CallInvoke, names.typeString(call.Value.Type()), call.Method.Name()
+		return c
+	}
+	switch fn := call.Value.(type) {
+	case *ssa.Builtin:
+		c.Kind, c.Builtin = ir.CallBuiltin, builtinCall(names, fn, call.Args)
+	case *ssa.Function:
+		c.Kind, c.Target = ir.CallStatic, funcRef(fn, from)
+		if recv := fn.Signature.Recv(); recv != nil {
+			c.Kind, c.Recv = ir.CallMethod, names.typeString(recv.Type())
+		}
+	case *ssa.MakeClosure:
+		c.Target = funcRef(fn.Fn.(*ssa.Function), from)
+	}
+	return c
+}
+
+// builtinCall describes the call of builtin b with args.
+func builtinCall(names localNames, b *ssa.Builtin, args []ssa.Value) *ir.BuiltinIR {
+	bi := &ir.BuiltinIR{Name: b.Name(), ArgTypes: make([]string, 0, len(args))}
+	// Object only looks up the universe scope.
+	if _, ok := types.Unsafe.Scope().Lookup(b.Name()).(*types.Builtin); ok && b.Object() == nil {
+		bi.Name = "unsafe." + bi.Name
+	}
+	for _, arg := range args {
+		bi.ArgTypes = append(bi.ArgTypes, names.typeString(arg.Type()))
+	}
+	switch {
+	case len(args) == 0:
+	case bi.Name == "append" || bi.Name == "copy":
+		if len(args) > 1 {
+			bi.Kind = accessKind(args[1].Type())
+		}
+	case bi.Name == "min" || bi.Name == "max":
+		bi.Kind = typeKind(args[0].Type())
+	default:
+		bi.Kind = accessKind(args[0].Type())
+	}
+	return bi
+}
+
+// optionalOperand returns the operand name of v, or "" if v is nil.
+func optionalOperand(names localNames, v ssa.Value) string {
+	if v == nil {
+		return ""
+	}
+	return operandName(names, v)
+}
+
+// deref returns the element type of pointer types and t otherwise.
+func deref(t types.Type) types.Type {
+	if p, ok := coreType(t).(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return t
+}
+
+// coreType returns the underlying type of t, or for type parameters the
+// underlying type shared by every term of the constraint, if any.
+func coreType(t types.Type) types.Type {
+	tp, ok := t.(*types.TypeParam)
+	if !ok {
+		return t.Underlying()
+	}
+	iface := tp.Constraint().Underlying().(*types.Interface)
+	var core types.Type
+	for i := 0; i < iface.NumEmbeddeds(); i++ {
+		terms := []*types.Term{}
+		switch e := iface.EmbeddedType(i).(type) {
+		case *types.Union:
+			for j := 0; j < e.Len(); j++ {
+				terms = append(terms, e.Term(j))
+			}
+		default:
+			terms = append(terms, types.NewTerm(false, e))
+		}
+		for _, term := range terms {
+			u := term.Type().Underlying()
+			if core == nil {
+				core = u
+			} else if !types.Identical(core, u) {
+				return iface
+			}
+		}
+	}
+	if core == nil {
+		return iface
+	}
+	return core
+}
+
+// accessKind classifies the operand of a Slice, Range or element access.
+func accessKind(t types.Type) string {
+	switch u := coreType(t).(type) {
+	case *types.Slice:
+		return ir.KindSlice
+	case *types.Array:
+		return ir.KindArray
+	case *types.Pointer:
+		if _, ok := coreType(u.Elem()).(*types.Array); ok {
+			return ir.KindArrayPointer
+		}
+	case *types.Map:
+		return ir.KindMap
+	case *types.Basic:
+		if u.Info()&types.IsString != 0 {
+			return ir.KindString
+		}
+	}
+	return typeKind(t)
+}
+
+// typeKind returns the kind of the underlying type of t: the name of a basic
+// type, without aliases such as byte, or the kind of composite type.
+func typeKind(t types.Type) string {
+	if _, ok := t.(*types.TypeParam); ok {
+		return ir.KindTypeParam
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		if u.Kind() == types.UnsafePointer {
+			return "unsafe.Pointer"
+		}
+		return types.Typ[u.Kind()].Name()
+	case *types.Slice:
+		return "slice"
+	case *types.Array:
+		return "array"
+	case *types.Pointer:
+		return "pointer"
+	case *types.Map:
+		return "map"
+	case *types.Chan:
+		return "chan"
+	case *types.Signature:
+		return "func"
+	case *types.Struct:
+		return "struct"
+	case *types.Interface:
+		return "interface"
+	case *types.Tuple:
+		return "tuple"
+	}
+	return ""
+}
+
+// elemType returns the element type of a slice, array, pointer to array,
+// string, map or channel type.
+func elemType(names localNames, t types.Type) string {
+	switch u := coreType(deref(t)).(type) {
+	case *types.Slice:
+		return names.typeString(u.Elem())
+	case *types.Array:
+		return names.typeString(u.Elem())
+	case *types.Map:
+		return names.typeString(u.Elem())
+	case *types.Chan:
+		return names.typeString(u.Elem())
+	case *types.Basic:
+		if u.Info()&types.IsString != 0 {
+			return "byte"
+		}
+	}
+	return ""
+}
+
+func indexAccess(names localNames, x, index ssa.Value) *ir.AccessIR {
+	return &ir.AccessIR{
+		X:        optionalOperand(names, x),
+		Kind:     accessKind(x.Type()),
+		Key:      optionalOperand(names, index),
+		KeyType:  names.typeString(index.Type()),
+		ElemType: elemType(names, x.Type()),
+	}
+}
+
+func mapAccess(names localNames, m, key, value ssa.Value) *ir.AccessIR {
+	mt := coreType(m.Type()).(*types.Map)
+	return &ir.AccessIR{
+		X:        optionalOperand(names, m),
+		Kind:     ir.KindMap,
+		Key:      optionalOperand(names, key),
+		Value:    optionalOperand(names, value),
+		KeyType:  names.typeString(mt.Key()),
+		ElemType: names.typeString(mt.Elem()),
+	}
+}
+
+func fieldAccess(names localNames, x ssa.Value, structType types.Type, index int) *ir.FieldIR {
+	f := coreType(structType).(*types.Struct).Field(index)
+	return &ir.FieldIR{
+		X:        optionalOperand(names, x),
+		Struct:   names.typeString(structType),
+		Index:    index,
+		Name:     f.Name(),
+		Embedded: f.Embedded(),
+	}
+}
+
+func conversion(names localNames, x ssa.Value, to types.Type) *ir.ConvertIR {
+	return &ir.ConvertIR{
+		X:        optionalOperand(names, x),
+		From:     names.typeString(x.Type()),
+		To:       names.typeString(to),
+		FromKind: typeKind(x.Type()),
+		ToKind:   typeKind(to),
+	}
+}
+
+// rangeTypes returns the key and value types of ranging over a string or
+// map.
+func rangeTypes(names localNames, t types.Type) (string, string) {
+	if m, ok := coreType(t).(*types.Map); ok {
+		return names.typeString(m.Key()), names.typeString(m.Elem())
+	}
+	return "int", "rune"
+}
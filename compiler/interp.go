@@ -0,0 +1,1425 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gonim/compiler/ir"
+)
+
+// exitInterp is the exit code of run when the IR cannot be executed, as
+// opposed to the exit code of the program it runs.
+const exitInterp = 3
+
+// irError reports IR the interpreter cannot execute: an unknown
+// instruction, an operand that does not resolve or a function missing from
+// the IR. It aborts the program and cannot be recovered from.
+type irError struct {
+	msg string
+}
+
+func (e *irError) Error() string { return e.msg }
+
+// goPanic is a panic of the interpreted program.
+type goPanic struct {
+	val       any
+	recovered bool
+}
+
+// exitSignal unwinds every frame when the program calls os.Exit.
+type exitSignal struct {
+	code int
+}
+
+// convError is the panic of a failed type assertion.
+type convError string
+
+func (e convError) Error() string { return "interface conversion: " + string(e) }
+
+type exitResult struct {
+	code int
+	err  error
+}
+
+// interp executes the IR of a main package the way the compiled Go program
+// would run, to check that the IR carries everything a backend needs.
+// Operators and other operands only encoded in instruction comments are
+// parsed from there.
+type interp struct {
+	prog    *ir.Program
+	globals map[string]*any
+	natives map[string]any
+	stdout  *syncWriter
+	stderr  io.Writer
+	done    chan exitResult
+	consts  sync.Map
+}
+
+// syncWriter serializes the output of goroutines.
+type syncWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func (w *syncWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.w.Flush()
+}
+
+// thread is the state of one goroutine.
+type thread struct {
+	// recoverable is the panic a deferred call about to start may recover.
+	recoverable *goPanic
+}
+
+type frame struct {
+	in     *interp
+	th     *thread
+	fn     *ir.FunctionIR
+	env    map[string]any
+	defers []deferred
+	// recoverable is the panic recover returns in this frame, which is a
+	// deferred call run while panicking.
+	recoverable *goPanic
+}
+
+type deferred struct {
+	callee any
+	args   []any
+}
+
+// nativeFunc is a function implemented by the interpreter.
+type nativeFunc func(th *thread, args []any) []any
+
+func runInterp(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("run")
+	opts.register(fs)
+	irPath := fs.String("ir", "", "Execute this IR file (JSON or binary) instead of loading packages")
+	// Arguments after -- are passed to the program.
+	var programArgs []string
+	for i, a := range args {
+		if a == "--" {
+			args, programArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	opts.addPatterns(fs.Args())
+	opts.lazyBuild = true
+
+	var prog *ir.Program
+	var err error
+	if *irPath != "" {
+		prog, err = ir.Load(*irPath)
+	} else {
+		diags := newDiagnostics()
+		var p *program
+		if p, err = loadProgram(&opts, diags); err != nil {
+			return failLoad(err, diags, "")
+		}
+		hir := buildIR(p, opts.verbose)
+		if diags.count(severityError) > 0 {
+			diags.print(os.Stderr)
+			return exitFailure
+		}
+		prog, err = ir.Resolve(&hir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gonim-compile run: %v\n", err)
+		return exitInterp
+	}
+
+	args = append([]string{prog.MainPkg}, programArgs...)
+	code, err := newInterp(prog, args, os.Stdout, os.Stderr).run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gonim-compile run: %v\n", err)
+		return exitInterp
+	}
+	return code
+}
+
+// newInterp prepares to run prog with the command-line arguments args, the
+// first of which names the program.
+func newInterp(prog *ir.Program, args []string, stdout, stderr io.Writer) *interp {
+	in := &interp{
+		prog:    prog,
+		globals: make(map[string]*any),
+		stdout:  &syncWriter{w: bufio.NewWriter(stdout)},
+		stderr:  stderr,
+		done:    make(chan exitResult, 1),
+	}
+	for _, pkg := range prog.Packages {
+		for _, g := range pkg.Globals {
+			v := in.zero(strings.TrimPrefix(g.Type, "*"))
+			in.globals[pkg.Path+"."+g.Name] = &v
+		}
+	}
+	in.natives = in.nativeFuncs()
+	for name, v := range in.nativeGlobals(args) {
+		in.globals[name] = v
+	}
+	return in
+}
+
+// run executes the initialization and main function of the main package
+// and returns the exit code of the program.
+func (in *interp) run() (int, error) {
+	main := in.prog.Main()
+	if main == nil {
+		return 0, fmt.Errorf("no main function in package %q", in.prog.MainPkg)
+	}
+	go func() {
+		th := &thread{}
+		defer func() {
+			if r := recover(); r != nil {
+				in.finish(in.crash(r))
+			}
+		}()
+		if init := in.prog.Function(in.prog.MainPkg + ".init"); init != nil {
+			in.call(th, init, nil, nil)
+		}
+		in.call(th, main, nil, nil)
+		in.finish(exitResult{})
+	}()
+	res := <-in.done
+	in.stdout.flush()
+	return res.code, res.err
+}
+
+// finish ends the program with the first result reported by any goroutine.
+func (in *interp) finish(res exitResult) {
+	select {
+	case in.done <- res:
+	default:
+	}
+}
+
+// crash turns what a goroutine panicked with into the result of the
+// program.
+func (in *interp) crash(r any) exitResult {
+	switch r := r.(type) {
+	case exitSignal:
+		return exitResult{code: r.code}
+	case *irError:
+		return exitResult{err: r}
+	case *goPanic:
+		in.stdout.flush()
+		fmt.Fprintf(in.stderr, "panic: %s\n", in.panicString(r.val))
+		return exitResult{code: 2}
+	}
+	return exitResult{err: fmt.Errorf("interpreter failure: %v", r)}
+}
+
+func (in *interp) panicString(v any) string {
+	switch x := in.native(v).(type) {
+	case error:
+		return x.Error()
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// goroutine runs the callee of a go statement.
+func (in *interp) goroutine(callee any, args []any) {
+	defer func() {
+		if r := recover(); r != nil {
+			in.finish(in.crash(r))
+		}
+	}()
+	in.callValue(&thread{}, callee, args)
+}
+
+func (in *interp) callValue(th *thread, callee any, args []any) []any {
+	switch f := callee.(type) {
+	case *closure:
+		if f == nil {
+			panic(plainError("invalid memory address or nil pointer dereference"))
+		}
+		return in.call(th, f.fn, args, f.free)
+	case nativeFunc:
+		return f(th, args)
+	case nil:
+		panic(plainError("invalid memory address or nil pointer dereference"))
+	}
+	rv := reflect.ValueOf(callee)
+	if rv.Kind() != reflect.Func {
+		panic(&irError{fmt.Sprintf("call of non-function %T", callee)})
+	}
+	return in.callReflect(rv, args)
+}
+
+func (in *interp) call(th *thread, fn *ir.FunctionIR, args, free []any) (results []any) {
+	fr := &frame{in: in, th: th, fn: fn, env: make(map[string]any)}
+	if fn.Body == nil {
+		fr.failf("function has no body in the IR")
+	}
+	var names []string
+	if fn.Receiver != nil {
+		names = append(names, fn.Receiver.Name)
+	}
+	for _, p := range fn.Signature.Params {
+		names = append(names, p.Name)
+	}
+	if len(args) != len(names) {
+		fr.failf("called with %d arguments, want %d", len(args), len(names))
+	}
+	for i, name := range names {
+		fr.env[name] = args[i]
+	}
+	if len(free) != len(fn.Body.FreeVars) {
+		fr.failf("called with %d free variables, want %d", len(free), len(fn.Body.FreeVars))
+	}
+	for i, name := range fn.Body.FreeVars {
+		fr.env[name] = free[i]
+	}
+	fr.recoverable, th.recoverable = th.recoverable, nil
+
+	defer fr.unwind(&results)
+	return fr.run()
+}
+
+func (fr *frame) name() string {
+	return strings.TrimPrefix(ir.FuncKey(fr.fn), fr.fn.Package+".")
+}
+
+func (fr *frame) failf(format string, args ...any) {
+	panic(&irError{fmt.Sprintf("%s.%s: %s", fr.fn.Package, fr.name(), fmt.Sprintf(format, args...))})
+}
+
+func (fr *frame) fail(inst *ir.Instruction, format string, args ...any) {
+	fr.failf("%s: %s", inst.Comment, fmt.Sprintf(format, args...))
+}
+
+// unwind runs the deferred calls of a panicking frame. If one of them
+// recovers, the function returns the zero values of its results, since the
+// IR does not say which block resumes after a recover.
+func (fr *frame) unwind(results *[]any) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	p, ok := r.(*goPanic)
+	if !ok {
+		switch r := r.(type) {
+		case *irError, exitSignal:
+			panic(r)
+		case *runtime.TypeAssertionError:
+			// Raised by the interpreter itself on an operand of the wrong
+			// kind; program type assertions raise convError.
+			fr.failf("operand of unexpected type: %v", r)
+		}
+		p = &goPanic{val: r}
+	}
+	for len(fr.defers) > 0 {
+		d := fr.defers[len(fr.defers)-1]
+		fr.defers = fr.defers[:len(fr.defers)-1]
+		p = fr.runDeferred(d, p)
+	}
+	if p != nil && !p.recovered {
+		panic(p)
+	}
+	if b := fr.recoverBlock(); b >= 0 {
+		*results = fr.runFrom(b)
+		return
+	}
+	*results = make([]any, len(fr.fn.Signature.Results))
+	for i, res := range fr.fn.Signature.Results {
+		(*results)[i] = fr.in.zero(res.Type)
+	}
+}
+
+// recoverBlock returns the block that returns the named results of a
+// function after a deferred call recovered: the only block other than the
+// entry no block jumps to.
+func (fr *frame) recoverBlock() int {
+	blocks := fr.fn.Body.Blocks
+	targets := make([]bool, len(blocks))
+	for _, b := range blocks {
+		for _, succ := range b.Successors {
+			if succ >= 0 && succ < len(targets) {
+				targets[succ] = true
+			}
+		}
+	}
+	for i := 1; i < len(blocks); i++ {
+		if !targets[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// runDeferred runs d while panicking with p and returns the panic still in
+// progress: p, a panic raised by d, or p recovered.
+func (fr *frame) runDeferred(d deferred, p *goPanic) (next *goPanic) {
+	next = p
+	defer func() {
+		if r := recover(); r != nil {
+			q, ok := r.(*goPanic)
+			if !ok {
+				switch r.(type) {
+				case *irError, exitSignal:
+					panic(r)
+				}
+				q = &goPanic{val: r}
+			}
+			next = q
+		}
+	}()
+	if !p.recovered {
+		fr.th.recoverable = p
+	}
+	fr.in.callValue(fr.th, d.callee, d.args)
+	fr.th.recoverable = nil
+	return next
+}
+
+func (fr *frame) runDefers() {
+	for len(fr.defers) > 0 {
+		d := fr.defers[len(fr.defers)-1]
+		fr.defers = fr.defers[:len(fr.defers)-1]
+		fr.in.callValue(fr.th, d.callee, d.args)
+	}
+}
+
+func (fr *frame) run() []any {
+	return fr.runFrom(0)
+}
+
+func (fr *frame) runFrom(b int) []any {
+	blocks := fr.fn.Body.Blocks
+	prev := -1
+	for {
+		if b < 0 || b >= len(blocks) {
+			fr.failf("jump to missing block %d", b)
+		}
+		block := &blocks[b]
+		instrs := block.Instructions
+
+		// The phis of a block take their values from the edge just taken,
+		// all at once.
+		i := 0
+		phis := make(map[string]any)
+		for ; i < len(instrs) && instrs[i].Op == "Phi"; i++ {
+			phis[instrs[i].Result] = fr.phi(&instrs[i], prev)
+		}
+		for name, v := range phis {
+			fr.env[name] = v
+		}
+
+		next := -1
+		for ; i < len(instrs); i++ {
+			inst := &instrs[i]
+			switch inst.Op {
+			case "Jump":
+				next = fr.successor(inst, block, 0)
+			case "If":
+				if fr.value(inst.Args[0]).(bool) {
+					next = fr.successor(inst, block, 0)
+				} else {
+					next = fr.successor(inst, block, 1)
+				}
+			case "Return":
+				fr.encoded(inst, inst.Return != nil)
+				return fr.values(inst.Return.Values)
+			case "Panic":
+				panic(&goPanic{val: fr.value(inst.Args[0])})
+			default:
+				if v := fr.exec(inst); inst.Result != "" {
+					fr.env[inst.Result] = v
+				}
+			}
+		}
+		if next < 0 {
+			fr.failf("block %d does not end in a jump, if, return or panic", b)
+		}
+		prev, b = b, next
+	}
+}
+
+func (fr *frame) successor(inst *ir.Instruction, block *ir.BlockIR, i int) int {
+	if i >= len(block.Successors) {
+		fr.fail(inst, "block %d has %d successors", block.ID, len(block.Successors))
+	}
+	return block.Successors[i]
+}
+
+// phi returns the edge of inst coming from block pred.
+func (fr *frame) phi(inst *ir.Instruction, pred int) any {
+	fr.encoded(inst, inst.Phi != nil)
+	for _, e := range inst.Phi.Edges {
+		if e.Block == pred {
+			return fr.value(e.Value)
+		}
+	}
+	fr.fail(inst, "no edge from block %d", pred)
+	return nil
+}
+
+// encoded fails inst unless it carries the structured encoding of its op,
+// which the interpreter executes rather than the comment.
+func (fr *frame) encoded(inst *ir.Instruction, ok bool) {
+	if !ok {
+		fr.fail(inst, "%s without its structured encoding", inst.Op)
+	}
+}
+
+func (fr *frame) values(names []string) []any {
+	vals := make([]any, len(names))
+	for i, name := range names {
+		vals[i] = fr.value(name)
+	}
+	return vals
+}
+
+// value resolves an operand: a register, parameter or free variable of the
+// frame, a constant, a global or a function.
+func (fr *frame) value(name string) any {
+	if v, ok := fr.env[name]; ok {
+		return v
+	}
+	if v := fr.fn.Body.Value(name); v != nil {
+		switch v.Kind {
+		case ir.ValueGlobal:
+			if g := fr.in.globals[v.Ref]; g != nil {
+				return g
+			}
+		case ir.ValueFunction:
+			if fn := fr.in.prog.Function(v.Ref); fn != nil {
+				return &closure{fn: fn}
+			}
+		}
+	}
+	if v, ok, err := fr.in.constant(name); ok {
+		if err != nil {
+			fr.failf("constant %s: %v", name, err)
+		}
+		return v
+	}
+	if g := fr.in.global(fr.fn.Package, name); g != nil {
+		return g
+	}
+	if fn := fr.in.function(fr.fn.Package, name); fn != nil {
+		return &closure{fn: fn}
+	}
+	fr.failf("undefined operand %q", name)
+	return nil
+}
+
+func (in *interp) global(pkg, name string) *any {
+	if g, ok := in.globals[pkg+"."+name]; ok {
+		return g
+	}
+	if g, ok := in.globals[name]; ok {
+		return g
+	}
+	// Globals of other packages are operands by their bare name.
+	for key, g := range in.globals {
+		if _, n := ir.SplitQualified(key); n == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func (in *interp) function(pkg, name string) *ir.FunctionIR {
+	if fn := in.prog.Function(pkg + "." + name); fn != nil {
+		return fn
+	}
+	return in.prog.Function(name)
+}
+
+// constant parses a constant operand, which is its value and type separated
+// by a colon, as in 1:int, "s":string or nil:error.
+func (in *interp) constant(name string) (any, bool, error) {
+	if v, ok := in.consts.Load(name); ok {
+		return v, true, nil
+	}
+	var text, typ string
+	if strings.HasPrefix(name, `"`) {
+		q, err := strconv.QuotedPrefix(name)
+		if err != nil || !strings.HasPrefix(name[len(q):], ":") {
+			return nil, false, nil
+		}
+		text, _ = strconv.Unquote(q)
+		typ = name[len(q)+1:]
+	} else {
+		i := strings.Index(name, ":")
+		if i <= 0 {
+			return nil, false, nil
+		}
+		text, typ = name[:i], name[i+1:]
+		if text == "nil" || strings.HasSuffix(text, "{}") {
+			return in.zero(typ), true, nil
+		}
+	}
+	t := in.basicType(typ)
+	if t == nil {
+		return nil, true, fmt.Errorf("unsupported constant type %s", typ)
+	}
+	v, err := parseBasicConst(text, t)
+	if err == nil {
+		in.consts.Store(name, v)
+	}
+	return v, true, err
+}
+
+// underlying resolves named types with a basic or composite underlying type
+// to it. Struct and interface types are returned unchanged.
+func (in *interp) underlying(t string) string {
+	for i := 0; i < 10; i++ {
+		if _, ok := basicTypes[t]; ok {
+			return t
+		}
+		if rt, ok := nativeTypes[t]; ok {
+			if name, ok := kindNames[rt.Kind()]; ok {
+				return name
+			}
+			return t
+		}
+		td := in.typeDef(t)
+		if td == nil || td.Kind != "alias" {
+			return t
+		}
+		t = td.Underlying
+	}
+	return t
+}
+
+func (in *interp) basicType(t string) reflect.Type {
+	return basicTypes[in.underlying(t)]
+}
+
+func (in *interp) typeDef(t string) *ir.TypeDef {
+	return in.prog.Type(t)
+}
+
+// zero returns the zero value of type t.
+func (in *interp) zero(t string) any {
+	t = in.underlying(t)
+	if bt, ok := basicTypes[t]; ok {
+		return reflect.Zero(bt).Interface()
+	}
+	switch {
+	case strings.HasPrefix(t, "*"):
+		return (*any)(nil)
+	case strings.HasPrefix(t, "[]"):
+		return sliceVal{elem: t[2:]}
+	case strings.HasPrefix(t, "map["):
+		return (*mapVal)(nil)
+	case strings.HasPrefix(t, "chan ") || strings.HasPrefix(t, "<-chan ") || strings.HasPrefix(t, "chan<- "):
+		return (chan any)(nil)
+	case strings.HasPrefix(t, "func("):
+		return (*closure)(nil)
+	case strings.HasPrefix(t, "["):
+		n, elem := arrayType(t)
+		arr := &arrayVal{elem: elem, elems: make([]any, n)}
+		for i := range arr.elems {
+			arr.elems[i] = in.zero(elem)
+		}
+		return arr
+	case strings.HasPrefix(t, "struct{"):
+		fields := structFields(t)
+		s := &structVal{typ: t, fields: make([]any, len(fields))}
+		for i, f := range fields {
+			s.fields[i] = in.zero(f.Type)
+		}
+		return s
+	}
+	if rt, ok := nativeTypes[t]; ok && rt.Kind() == reflect.Struct {
+		return nativeVal{ptr: reflect.New(rt)}
+	}
+	if td := in.typeDef(t); td != nil && td.Kind == "struct" {
+		s := &structVal{typ: t, fields: make([]any, len(td.Fields))}
+		for i, f := range td.Fields {
+			s.fields[i] = in.zero(f.Type)
+		}
+		return s
+	}
+	return nil
+}
+
+// arrayType splits an array type such as [4]int.
+func arrayType(t string) (int, string) {
+	end := strings.Index(t, "]")
+	n, _ := strconv.Atoi(t[1:end])
+	return n, t[end+1:]
+}
+
+// structFields parses the fields of a struct type literal.
+func structFields(t string) []ir.FieldDef {
+	inner := strings.TrimSuffix(strings.TrimPrefix(t, "struct{"), "}")
+	if inner == "" {
+		return nil
+	}
+	var fields []ir.FieldDef
+	for _, f := range splitTopLevel(inner, "; ") {
+		if i := strings.Index(f, ` "`); i > 0 {
+			f = f[:i]
+		}
+		name, typ, ok := strings.Cut(f, " ")
+		if !ok {
+			// An embedded field is named after its type.
+			_, name = ir.SplitQualified(strings.TrimPrefix(f, "*"))
+			typ = f
+		}
+		fields = append(fields, ir.FieldDef{Name: name, Type: typ})
+	}
+	return fields
+}
+
+// exec executes an instruction that does not end its block and returns the
+// value it defines.
+func (fr *frame) exec(inst *ir.Instruction) any {
+	in := fr.in
+	arg := func(i int) any {
+		if i >= len(inst.Args) {
+			fr.fail(inst, "missing operand %d", i)
+		}
+		return fr.value(inst.Args[i])
+	}
+	switch inst.Op {
+	case "DebugRef":
+		return nil
+	case "Alloc":
+		fr.encoded(inst, inst.Alloc != nil)
+		p := new(any)
+		*p = in.zero(inst.Alloc.Elem)
+		return p
+	case "Store":
+		*fr.pointer(inst, arg(0)) = copyValue(arg(1))
+		return nil
+	case "UnOp":
+		fr.encoded(inst, inst.Operation != nil)
+		return fr.unop(inst, inst.Operation, fr.value(inst.Operation.X))
+	case "BinOp":
+		fr.encoded(inst, inst.Operation != nil)
+		op := inst.Operation
+		return fr.binop(inst, op.Operator, fr.value(op.X), fr.value(op.Y))
+	case "Call":
+		if v, ok := fr.builtinCall(inst); ok {
+			return v
+		}
+		callee, args := fr.callee(inst)
+		results := in.callValue(fr.th, callee, args)
+		switch len(results) {
+		case 0:
+			return nil
+		case 1:
+			return results[0]
+		}
+		return tuple(results)
+	case "Go":
+		callee, args := fr.callee(inst)
+		go in.goroutine(callee, args)
+		return nil
+	case "Defer":
+		callee, args := fr.callee(inst)
+		fr.defers = append(fr.defers, deferred{callee: callee, args: args})
+		return nil
+	case "RunDefers":
+		fr.runDefers()
+		return nil
+	case "MakeClosure":
+		fr.encoded(inst, inst.Make != nil)
+		var fn *ir.FunctionIR
+		if v := fr.fn.Body.Value(inst.Make.Fn); v != nil && v.Kind == ir.ValueFunction {
+			fn = fr.in.prog.Function(v.Ref)
+		}
+		if fn == nil {
+			fr.fail(inst, "%s is not in the IR", inst.Make.Fn)
+		}
+		return &closure{fn: fn, free: fr.values(inst.Make.Bindings)}
+	case "MakeInterface":
+		fr.encoded(inst, inst.Convert != nil)
+		return iface{typ: inst.Convert.From, val: copyValue(fr.value(inst.Convert.X))}
+	case "ChangeInterface", "ChangeType":
+		fr.encoded(inst, inst.Convert != nil)
+		return fr.value(inst.Convert.X)
+	case "Convert":
+		fr.encoded(inst, inst.Convert != nil)
+		return fr.convert(inst, inst.Convert, fr.value(inst.Convert.X))
+	case "TypeAssert":
+		fr.encoded(inst, inst.TypeAssert != nil)
+		return fr.typeAssert(inst, inst.TypeAssert, fr.value(inst.TypeAssert.X))
+	case "Extract":
+		t, ok := arg(0).(tuple)
+		i := inst.Extract.Index
+		if !ok || i < 0 || i >= len(t) {
+			fr.fail(inst, "no tuple element %d", i)
+		}
+		return t[i]
+	case "FieldAddr":
+		fr.encoded(inst, inst.Field != nil)
+		s := (*fr.pointer(inst, fr.value(inst.Field.X))).(*structVal)
+		return &s.fields[fr.fieldIndex(inst, len(s.fields))]
+	case "Field":
+		fr.encoded(inst, inst.Field != nil)
+		s := fr.value(inst.Field.X).(*structVal)
+		return s.fields[fr.fieldIndex(inst, len(s.fields))]
+	case "IndexAddr":
+		fr.encoded(inst, inst.Access != nil)
+		x, i := fr.value(inst.Access.X), toInt(fr.value(inst.Access.Key))
+		switch inst.Access.Kind {
+		case ir.KindSlice:
+			return &x.(sliceVal).s[i]
+		case ir.KindArrayPointer:
+			return &(*fr.pointer(inst, x)).(*arrayVal).elems[i]
+		}
+		fr.fail(inst, "cannot take the address of an element of a %s", inst.Access.Kind)
+	case "Index":
+		fr.encoded(inst, inst.Access != nil)
+		x, i := fr.value(inst.Access.X), toInt(fr.value(inst.Access.Key))
+		switch inst.Access.Kind {
+		case ir.KindArray:
+			return x.(*arrayVal).elems[i]
+		case ir.KindString:
+			return x.(string)[i]
+		}
+		fr.fail(inst, "cannot index a %s", inst.Access.Kind)
+	case "Lookup":
+		fr.encoded(inst, inst.Access != nil)
+		return fr.lookup(inst, inst.Access, fr.value(inst.Access.X), fr.value(inst.Access.Key))
+	case "MapUpdate":
+		fr.encoded(inst, inst.Access != nil)
+		a := inst.Access
+		fr.value(a.X).(*mapVal).update(fr.value(a.Key), copyValue(fr.value(a.Value)))
+		return nil
+	case "MakeMap":
+		fr.encoded(inst, inst.Make != nil)
+		return newMap(inst.Make.KeyType, inst.Make.ElemType)
+	case "MakeSlice":
+		fr.encoded(inst, inst.Make != nil)
+		n, c := toInt(fr.value(inst.Make.Len)), toInt(fr.value(inst.Make.Cap))
+		if n < 0 || n > c {
+			panic(plainError("makeslice: len out of range"))
+		}
+		elem := inst.Make.ElemType
+		s := make([]any, n, c)
+		for i := range s {
+			s[i] = in.zero(elem)
+		}
+		return sliceVal{elem: elem, s: s}
+	case "MakeChan":
+		fr.encoded(inst, inst.Make != nil)
+		return make(chan any, toInt(fr.value(inst.Make.Size)))
+	case "Slice":
+		fr.encoded(inst, inst.Slice != nil)
+		return fr.slice(inst, inst.Slice)
+	case "SliceToArrayPointer":
+		fr.encoded(inst, inst.Convert != nil)
+		s := fr.value(inst.Convert.X).(sliceVal)
+		n, elem := arrayType(in.underlying(strings.TrimPrefix(inst.Convert.To, "*")))
+		if len(s.s) < n {
+			panic(plainError(fmt.Sprintf("cannot convert slice with length %d to array or pointer to array with length %d", len(s.s), n)))
+		}
+		p := new(any)
+		*p = &arrayVal{elem: elem, elems: s.s[:n:n]}
+		return p
+	case "Send":
+		arg(0).(chan any) <- copyValue(arg(1))
+		return nil
+	case "Select":
+		fr.encoded(inst, inst.Select != nil)
+		return fr.selectOp(inst, inst.Select)
+	case "Range":
+		fr.encoded(inst, inst.Range != nil)
+		x := fr.value(inst.Range.X)
+		switch inst.Range.Kind {
+		case ir.KindString:
+			return &stringIter{s: x.(string)}
+		case ir.KindMap:
+			m := x.(*mapVal)
+			it := &mapIter{m: m}
+			if m != nil {
+				it.keys = append(it.keys, m.order...)
+			}
+			return it
+		}
+		fr.fail(inst, "cannot range over a %s", inst.Range.Kind)
+	case "Next":
+		fr.encoded(inst, inst.Range != nil)
+		switch it := fr.value(inst.Range.X).(type) {
+		case *stringIter:
+			return it.next()
+		case *mapIter:
+			return it.next()
+		}
+		fr.fail(inst, "%s is not an iterator", inst.Range.X)
+	}
+	fr.fail(inst, "unsupported instruction %s", inst.Op)
+	return nil
+}
+
+func (fr *frame) pointer(inst *ir.Instruction, v any) *any {
+	p, ok := v.(*any)
+	if !ok {
+		fr.fail(inst, "%T is not a pointer", v)
+	}
+	if p == nil {
+		panic(plainError("invalid memory address or nil pointer dereference"))
+	}
+	return p
+}
+
+func (fr *frame) fieldIndex(inst *ir.Instruction, n int) int {
+	i := inst.Field.Index
+	if i < 0 || i >= n {
+		fr.fail(inst, "no field %d in a struct of %d fields", i, n)
+	}
+	return i
+}
+
+func mapTypes(t string) (string, string) {
+	inner := strings.TrimPrefix(t, "map[")
+	depth := 1
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return inner[:i], inner[i+1:]
+			}
+		}
+	}
+	return "", ""
+}
+
+func toInt(v any) int {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(rv.Uint())
+	}
+	panic(&irError{fmt.Sprintf("%T is not an integer", v)})
+}
+
+func (fr *frame) unop(inst *ir.Instruction, op *ir.OperationIR, x any) any {
+	switch op.Operator {
+	case "*":
+		return copyValue(*fr.pointer(inst, x))
+	case "<-":
+		v, ok := <-x.(chan any)
+		if op.CommaOk {
+			if !ok {
+				v = fr.in.zero(inst.Tuple[0])
+			}
+			return tuple{v, ok}
+		}
+		if !ok {
+			v = fr.in.zero(inst.Type)
+		}
+		return v
+	case "!":
+		return !x.(bool)
+	case "-":
+		return negate(x)
+	case "^":
+		return complement(x)
+	}
+	fr.fail(inst, "unknown operator %q", op.Operator)
+	return nil
+}
+
+func (fr *frame) binop(inst *ir.Instruction, op string, x, y any) any {
+	switch op {
+	case "==":
+		return equal(x, y)
+	case "!=":
+		return !equal(x, y)
+	case "<<", ">>":
+		return shift(op, x, y)
+	}
+	v, ok := arith(op, x, y)
+	if !ok {
+		fr.fail(inst, "unsupported operation %T %s %T", x, op, y)
+	}
+	return v
+}
+
+func (fr *frame) convert(inst *ir.Instruction, c *ir.ConvertIR, v any) any {
+	in := fr.in
+	switch {
+	case c.FromKind == ir.KindSlice && c.ToKind == ir.KindString:
+		var b strings.Builder
+		for _, e := range v.(sliceVal).s {
+			switch e := e.(type) {
+			case uint8:
+				b.WriteByte(e)
+			case int32:
+				b.WriteRune(e)
+			}
+		}
+		return b.String()
+	case c.FromKind == ir.KindString && c.ToKind == ir.KindSlice:
+		x := v.(string)
+		switch in.underlying(strings.TrimPrefix(in.underlying(c.To), "[]")) {
+		case "byte", "uint8":
+			s := make([]any, len(x))
+			for i := 0; i < len(x); i++ {
+				s[i] = x[i]
+			}
+			return sliceVal{elem: "uint8", s: s}
+		case "rune", "int32":
+			s := make([]any, 0, utf8.RuneCountInString(x))
+			for _, r := range x {
+				s = append(s, r)
+			}
+			return sliceVal{elem: "int32", s: s}
+		}
+	}
+	bt := in.basicType(c.To)
+	if bt == nil {
+		fr.fail(inst, "unsupported conversion from %s to %s", c.FromKind, c.ToKind)
+	}
+	return reflect.ValueOf(v).Convert(bt).Interface()
+}
+
+func (fr *frame) typeAssert(inst *ir.Instruction, ta *ir.TypeAssertIR, v any) any {
+	t := ta.Asserted
+	res, ok := fr.in.assert(v, t)
+	if ta.CommaOk {
+		if !ok {
+			res = fr.in.zero(t)
+		}
+		return tuple{res, ok}
+	}
+	if !ok {
+		panic(convError(fmt.Sprintf("interface {} is %s, not %s", fr.in.dynamicType(v), t)))
+	}
+	return res
+}
+
+func (in *interp) dynamicType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case iface:
+		return v.typ
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// assert implements x.(t).
+func (in *interp) assert(v any, t string) (any, bool) {
+	if v == nil {
+		return nil, false
+	}
+	if methods, ok := in.interfaceMethods(t); ok {
+		for _, m := range methods {
+			if !in.hasMethod(v, m) {
+				return nil, false
+			}
+		}
+		return v, true
+	}
+	if x, ok := v.(iface); ok {
+		return x.val, x.typ == t
+	}
+	if rt, ok := nativeTypes[strings.TrimPrefix(t, "*")]; ok {
+		if strings.HasPrefix(t, "*") {
+			rt = reflect.PointerTo(rt)
+		}
+		return v, reflect.TypeOf(v) == rt
+	}
+	return nil, false
+}
+
+// interfaceMethods returns the methods of t if it is an interface type.
+func (in *interp) interfaceMethods(t string) ([]string, bool) {
+	switch {
+	case t == "any" || t == "interface{}":
+		return nil, true
+	case t == "error":
+		return []string{"Error"}, true
+	case strings.HasPrefix(t, "interface{"):
+		var methods []string
+		for _, m := range splitTopLevel(strings.TrimSuffix(strings.TrimPrefix(t, "interface{"), "}"), "; ") {
+			if i := strings.Index(m, "("); i > 0 {
+				methods = append(methods, m[:i])
+			}
+		}
+		return methods, true
+	}
+	if rt, ok := nativeTypes[t]; ok && rt.Kind() == reflect.Interface {
+		methods := make([]string, rt.NumMethod())
+		for i := range methods {
+			methods[i] = rt.Method(i).Name
+		}
+		return methods, true
+	}
+	if td := in.typeDef(t); td != nil && td.Kind == "interface" {
+		methods := make([]string, len(td.Fields))
+		for i, f := range td.Fields {
+			methods[i] = f.Name
+		}
+		return methods, true
+	}
+	return nil, false
+}
+
+func (in *interp) hasMethod(v any, name string) bool {
+	if x, ok := v.(iface); ok {
+		if in.method(x.typ, name) != nil {
+			return true
+		}
+		if td := in.typeDef(strings.TrimPrefix(x.typ, "*")); td != nil {
+			for _, m := range td.Methods {
+				if m == name {
+					return true
+				}
+			}
+			return false
+		}
+		v = in.native(x)
+	}
+	rv := reflect.ValueOf(v)
+	return rv.IsValid() && rv.MethodByName(name).IsValid()
+}
+
+// method returns the IR function implementing method name of type t.
+func (in *interp) method(t, name string) *ir.FunctionIR {
+	pointer := strings.HasPrefix(t, "*")
+	pkg, typeName := ir.SplitQualified(ir.StripTypeArgs(strings.TrimPrefix(t, "*")))
+	if pointer {
+		if fn := in.prog.Function(ir.MethodKey(pkg, typeName, true, name)); fn != nil {
+			return fn
+		}
+	}
+	return in.prog.Function(ir.MethodKey(pkg, typeName, false, name))
+}
+
+func (fr *frame) lookup(inst *ir.Instruction, a *ir.AccessIR, x, k any) any {
+	if a.Kind != ir.KindMap {
+		fr.fail(inst, "cannot look up in a %s", a.Kind)
+	}
+	v, ok := x.(*mapVal).lookup(k)
+	if !ok {
+		v = fr.in.zero(a.ElemType)
+	}
+	if a.CommaOk {
+		return tuple{v, ok}
+	}
+	return v
+}
+
+// slice implements x[low:high:max]; absent bounds are empty.
+func (fr *frame) slice(inst *ir.Instruction, sl *ir.SliceIR) any {
+	bound := func(name string, def int) int {
+		if name == "" {
+			return def
+		}
+		return toInt(fr.value(name))
+	}
+
+	x := fr.value(sl.X)
+	switch sl.Kind {
+	case ir.KindString:
+		s := x.(string)
+		return s[bound(sl.Low, 0):bound(sl.High, len(s))]
+	case ir.KindSlice:
+		s := x.(sliceVal)
+		return sliceVal{elem: s.elem, s: s.s[bound(sl.Low, 0):bound(sl.High, len(s.s)):bound(sl.Max, cap(s.s))]}
+	case ir.KindArrayPointer:
+		arr := (*fr.pointer(inst, x)).(*arrayVal)
+		return sliceVal{elem: arr.elem, s: arr.elems[bound(sl.Low, 0):bound(sl.High, len(arr.elems)):bound(sl.Max, len(arr.elems))]}
+	}
+	fr.fail(inst, "cannot slice a %s", sl.Kind)
+	return nil
+}
+
+// selectOp implements a select. Its result holds the index of the case
+// taken, -1 for the default case, whether the receive succeeded, and a
+// value for every receive state.
+func (fr *frame) selectOp(inst *ir.Instruction, sel *ir.SelectIR) any {
+	var cases []reflect.SelectCase
+	var recvs []int
+	for _, st := range sel.States {
+		ch := fr.value(st.Chan).(chan any)
+		if st.Dir == "recv" {
+			recvs = append(recvs, len(cases))
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: chanValue(ch)})
+			continue
+		}
+		v := copyValue(fr.value(st.Send))
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: chanValue(ch), Send: reflect.ValueOf(&v).Elem()})
+	}
+	if !sel.Blocking {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+
+	res := make(tuple, 2+len(recvs))
+	chosen, recv, recvOk := reflect.Select(cases)
+	if chosen == len(cases)-1 && !sel.Blocking {
+		chosen = -1
+	}
+	res[0], res[1] = chosen, recvOk
+	for i, c := range recvs {
+		res[2+i] = fr.in.zero(inst.Tuple[2+i])
+		if c == chosen && recvOk {
+			res[2+i] = recv.Interface()
+		}
+	}
+	return res
+}
+
+func chanValue(ch chan any) reflect.Value {
+	if ch == nil {
+		return reflect.Value{}
+	}
+	return reflect.ValueOf(ch)
+}
+
+type stringIter struct {
+	s string
+	i int
+}
+
+func (it *stringIter) next() tuple {
+	if it.i >= len(it.s) {
+		return tuple{false, 0, int32(0)}
+	}
+	r, size := utf8.DecodeRuneInString(it.s[it.i:])
+	i := it.i
+	it.i += size
+	return tuple{true, i, r}
+}
+
+type mapIter struct {
+	m    *mapVal
+	keys []any
+}
+
+func (it *mapIter) next() tuple {
+	for len(it.keys) > 0 {
+		key := it.keys[0]
+		it.keys = it.keys[1:]
+		// Entries deleted during the iteration are not produced.
+		if e, ok := it.m.entries[key]; ok {
+			return tuple{true, e.key, e.val}
+		}
+	}
+	return tuple{false, nil, nil}
+}
+
+// callee resolves the function called by a Call, Go or Defer instruction
+// and evaluates its arguments.
+func (fr *frame) callee(inst *ir.Instruction) (any, []any) {
+	c := inst.Call
+	fr.encoded(inst, c != nil)
+	args := fr.values(c.Args)
+	switch c.Kind {
+	case ir.CallInvoke:
+		return fr.in.invoke(fr.value(c.Value), c.Method, args)
+	case ir.CallClosure:
+		return fr.value(c.Value), args
+	case ir.CallBuiltin:
+		fr.encoded(inst, c.Builtin != nil)
+		name := c.Builtin.Name
+		return nativeFunc(func(th *thread, args []any) []any {
+			return []any{fr.builtin(inst, name, args)}
+		}), args
+	}
+	if inst.Stdlib != nil {
+		return fr.in.stdlibCallee(inst.Stdlib, args)
+	}
+	if inst.Callee != nil {
+		return &closure{fn: inst.Callee}, args
+	}
+	if fn := fr.staticFunction(c.Target); fn != nil {
+		return &closure{fn: fn}, args
+	}
+	// Standard library calls the manifest does not map have no target.
+	if f, ok := fr.in.natives[c.Target]; ok {
+		return f, args
+	}
+	if i, j := strings.Index(c.Target, ".("), strings.LastIndex(c.Target, ")."); c.Kind == ir.CallMethod && i >= 0 && j > i && len(args) > 0 {
+		if fr.in.prog.Package(c.Target[:i]) == nil {
+			return fr.in.nativeMethod(args[0], c.Target[j+2:]), args[1:]
+		}
+	}
+	fr.fail(inst, "%s is not in the IR", c.Target)
+	return nil, nil
+}
+
+// staticFunction returns the IR of the function with FuncKey key. Package
+// initializers outside the IR are no-ops.
+func (fr *frame) staticFunction(key string) *ir.FunctionIR {
+	if fn := fr.in.prog.Function(key); fn != nil {
+		return fn
+	}
+	if pkg, name := ir.SplitQualified(key); name == "init" {
+		return &ir.FunctionIR{Name: "init", Package: pkg, Body: &ir.BodyIR{Blocks: []ir.BlockIR{{Instructions: []ir.Instruction{{Op: "Return", Return: &ir.ReturnIR{}}}}}}}
+	}
+	return nil
+}
+
+// invoke resolves the method called through an interface value.
+func (in *interp) invoke(recv any, method string, args []any) (any, []any) {
+	switch v := recv.(type) {
+	case nil:
+		panic(plainError("invalid memory address or nil pointer dereference"))
+	case iface:
+		if fn := in.method(v.typ, method); fn != nil {
+			r := v.val
+			if !fn.Receiver.Pointer && strings.HasPrefix(v.typ, "*") {
+				p, _ := r.(*any)
+				if p == nil {
+					panic(plainError("invalid memory address or nil pointer dereference"))
+				}
+				r = copyValue(*p)
+			}
+			return &closure{fn: fn}, append([]any{r}, args...)
+		}
+		if td := in.typeDef(strings.TrimPrefix(v.typ, "*")); td != nil {
+			panic(&irError{fmt.Sprintf("method (%s).%s is not in the IR", v.typ, method)})
+		}
+		return in.nativeMethod(in.native(v), method), args
+	}
+	return in.nativeMethod(recv, method), args
+}
+
+// builtinCall executes a call of a builtin function, reported by ok.
+func (fr *frame) builtinCall(inst *ir.Instruction) (any, bool) {
+	if inst.Call == nil || inst.Call.Builtin == nil {
+		return nil, false
+	}
+	return fr.builtin(inst, inst.Call.Builtin.Name, fr.values(inst.Call.Args)), true
+}
+
+func (fr *frame) builtin(inst *ir.Instruction, name string, args []any) any {
+	in := fr.in
+	switch name {
+	case "len", "cap":
+		switch x := args[0].(type) {
+		case string:
+			return len(x)
+		case sliceVal:
+			if name == "cap" {
+				return cap(x.s)
+			}
+			return len(x.s)
+		case *mapVal:
+			return x.len()
+		case chan any:
+			if name == "cap" {
+				return cap(x)
+			}
+			return len(x)
+		case *arrayVal:
+			return len(x.elems)
+		case *any:
+			return len((*fr.pointer(inst, x)).(*arrayVal).elems)
+		}
+	case "append":
+		s := args[0].(sliceVal)
+		if s.elem == "" {
+			s.elem = strings.TrimPrefix(in.underlying(inst.Type), "[]")
+		}
+		switch x := args[1].(type) {
+		case sliceVal:
+			for _, e := range x.s {
+				s.s = append(s.s, copyValue(e))
+			}
+		case string:
+			for i := 0; i < len(x); i++ {
+				s.s = append(s.s, x[i])
+			}
+		}
+		return s
+	case "copy":
+		dst := args[0].(sliceVal)
+		var n int
+		switch src := args[1].(type) {
+		case sliceVal:
+			n = copy(dst.s, src.s)
+			for i := 0; i < n; i++ {
+				dst.s[i] = copyValue(dst.s[i])
+			}
+		case string:
+			for n = 0; n < len(dst.s) && n < len(src); n++ {
+				dst.s[n] = src[n]
+			}
+		}
+		return n
+	case "delete":
+		args[0].(*mapVal).delete(args[1])
+		return nil
+	case "clear":
+		switch x := args[0].(type) {
+		case *mapVal:
+			if x != nil {
+				x.entries = make(map[any]*mapEntry)
+				x.order = nil
+			}
+		case sliceVal:
+			for i := range x.s {
+				x.s[i] = in.zero(x.elem)
+			}
+		}
+		return nil
+	case "close":
+		close(args[0].(chan any))
+		return nil
+	case "min", "max":
+		best := args[0]
+		for _, a := range args[1:] {
+			less, _ := arith("<", a, best)
+			if less == (name == "min") && !equal(a, best) {
+				best = a
+			}
+		}
+		return best
+	case "recover":
+		if p := fr.recoverable; p != nil && !p.recovered {
+			p.recovered = true
+			return p.val
+		}
+		return nil
+	case "print", "println":
+		vals := make([]any, len(args))
+		for i, a := range args {
+			vals[i] = in.native(a)
+		}
+		in.stdout.flush()
+		if name == "println" {
+			fmt.Fprintln(in.stderr, vals...)
+		} else {
+			fmt.Fprint(in.stderr, vals...)
+		}
+		return nil
+	case "real", "imag", "complex":
+		return complexBuiltin(name, args)
+	case "ssa:wrapnilchk":
+		if isNil(args[0]) {
+			panic(plainError("invalid memory address or nil pointer dereference"))
+		}
+		return args[0]
+	}
+	fr.fail(inst, "unsupported builtin call")
+	return nil
+}
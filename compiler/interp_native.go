@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unsafe"
+
+	"github.com/gonim/compiler/ir"
+)
+
+// The interpreter runs standard library calls with the real Go functions.
+// Arguments are converted to the Go types of their parameters and results
+// back to interpreter values.
+
+// nativeTypes are the standard library types the interpreter can create
+// values of.
+var nativeTypes = map[string]reflect.Type{
+	"bytes.Buffer":    reflect.TypeOf(bytes.Buffer{}),
+	"error":           reflect.TypeOf((*error)(nil)).Elem(),
+	"fmt.Stringer":    reflect.TypeOf((*fmt.Stringer)(nil)).Elem(),
+	"io.Reader":       reflect.TypeOf((*io.Reader)(nil)).Elem(),
+	"io.Writer":       reflect.TypeOf((*io.Writer)(nil)).Elem(),
+	"strings.Builder": reflect.TypeOf(strings.Builder{}),
+	"sync.Mutex":      reflect.TypeOf(sync.Mutex{}),
+	"sync.Once":       reflect.TypeOf(sync.Once{}),
+	"sync.RWMutex":    reflect.TypeOf(sync.RWMutex{}),
+	"sync.WaitGroup":  reflect.TypeOf(sync.WaitGroup{}),
+	"time.Duration":   reflect.TypeOf(time.Duration(0)),
+	"time.Month":      reflect.TypeOf(time.Month(0)),
+	"time.Time":       reflect.TypeOf(time.Time{}),
+	"time.Weekday":    reflect.TypeOf(time.Weekday(0)),
+}
+
+var kindNames = map[reflect.Kind]string{
+	reflect.Bool:       "bool",
+	reflect.Int:        "int",
+	reflect.Int8:       "int8",
+	reflect.Int16:      "int16",
+	reflect.Int32:      "int32",
+	reflect.Int64:      "int64",
+	reflect.Uint:       "uint",
+	reflect.Uint8:      "uint8",
+	reflect.Uint16:     "uint16",
+	reflect.Uint32:     "uint32",
+	reflect.Uint64:     "uint64",
+	reflect.Uintptr:    "uintptr",
+	reflect.Float32:    "float32",
+	reflect.Float64:    "float64",
+	reflect.Complex64:  "complex64",
+	reflect.Complex128: "complex128",
+	reflect.String:     "string",
+}
+
+// nativeFuncs returns the standard library functions the interpreter
+// implements, keyed by package path and name. Output goes to the
+// interpreter's standard output.
+func (in *interp) nativeFuncs() map[string]any {
+	return map[string]any{
+		"errors.Is":     errors.Is,
+		"errors.New":    errors.New,
+		"errors.Unwrap": errors.Unwrap,
+
+		"fmt.Errorf":   fmt.Errorf,
+		"fmt.Fprint":   fmt.Fprint,
+		"fmt.Fprintf":  fmt.Fprintf,
+		"fmt.Fprintln": fmt.Fprintln,
+		"fmt.Print": func(a ...any) (int, error) {
+			return fmt.Fprint(in.stdout, a...)
+		},
+		"fmt.Printf": func(format string, a ...any) (int, error) {
+			return fmt.Fprintf(in.stdout, format, a...)
+		},
+		"fmt.Println": func(a ...any) (int, error) {
+			return fmt.Fprintln(in.stdout, a...)
+		},
+		"fmt.Sprint":   fmt.Sprint,
+		"fmt.Sprintf":  fmt.Sprintf,
+		"fmt.Sprintln": fmt.Sprintln,
+
+		"math.Abs":   math.Abs,
+		"math.Ceil":  math.Ceil,
+		"math.Cos":   math.Cos,
+		"math.Exp":   math.Exp,
+		"math.Floor": math.Floor,
+		"math.Inf":   math.Inf,
+		"math.IsInf": math.IsInf,
+		"math.IsNaN": math.IsNaN,
+		"math.Log":   math.Log,
+		"math.Max":   math.Max,
+		"math.Min":   math.Min,
+		"math.Mod":   math.Mod,
+		"math.NaN":   math.NaN,
+		"math.Pow":   math.Pow,
+		"math.Round": math.Round,
+		"math.Sin":   math.Sin,
+		"math.Sqrt":  math.Sqrt,
+		"math.Trunc": math.Trunc,
+
+		"os.Exit": nativeFunc(func(th *thread, args []any) []any {
+			panic(exitSignal{code: toInt(args[0])})
+		}),
+		"os.Getenv": os.Getenv,
+
+		"sort.Float64s": sort.Float64s,
+		"sort.Ints":     sort.Ints,
+		"sort.Strings":  sort.Strings,
+		"sort.Slice": nativeFunc(func(th *thread, args []any) []any {
+			return in.sortSlice(th, args, false)
+		}),
+		"sort.SliceStable": nativeFunc(func(th *thread, args []any) []any {
+			return in.sortSlice(th, args, true)
+		}),
+
+		"strconv.Atoi":        strconv.Atoi,
+		"strconv.FormatBool":  strconv.FormatBool,
+		"strconv.FormatFloat": strconv.FormatFloat,
+		"strconv.FormatInt":   strconv.FormatInt,
+		"strconv.Itoa":        strconv.Itoa,
+		"strconv.ParseBool":   strconv.ParseBool,
+		"strconv.ParseFloat":  strconv.ParseFloat,
+		"strconv.ParseInt":    strconv.ParseInt,
+		"strconv.Quote":       strconv.Quote,
+
+		"strings.Contains":     strings.Contains,
+		"strings.ContainsRune": strings.ContainsRune,
+		"strings.Count":        strings.Count,
+		"strings.EqualFold":    strings.EqualFold,
+		"strings.Fields":       strings.Fields,
+		"strings.HasPrefix":    strings.HasPrefix,
+		"strings.HasSuffix":    strings.HasSuffix,
+		"strings.Index":        strings.Index,
+		"strings.IndexByte":    strings.IndexByte,
+		"strings.Join":         strings.Join,
+		"strings.LastIndex":    strings.LastIndex,
+		"strings.Repeat":       strings.Repeat,
+		"strings.Replace":      strings.Replace,
+		"strings.ReplaceAll":   strings.ReplaceAll,
+		"strings.Split":        strings.Split,
+		"strings.SplitN":       strings.SplitN,
+		"strings.ToLower":      strings.ToLower,
+		"strings.ToUpper":      strings.ToUpper,
+		"strings.Trim":         strings.Trim,
+		"strings.TrimLeft":     strings.TrimLeft,
+		"strings.TrimPrefix":   strings.TrimPrefix,
+		"strings.TrimRight":    strings.TrimRight,
+		"strings.TrimSpace":    strings.TrimSpace,
+		"strings.TrimSuffix":   strings.TrimSuffix,
+
+		"time.Now":   time.Now,
+		"time.Since": time.Since,
+		"time.Sleep": time.Sleep,
+
+		"unicode.IsDigit":  unicode.IsDigit,
+		"unicode.IsLetter": unicode.IsLetter,
+		"unicode.IsLower":  unicode.IsLower,
+		"unicode.IsSpace":  unicode.IsSpace,
+		"unicode.IsUpper":  unicode.IsUpper,
+		"unicode.ToLower":  unicode.ToLower,
+		"unicode.ToUpper":  unicode.ToUpper,
+	}
+}
+
+// nativeGlobals returns the standard library variables programs may use.
+func (in *interp) nativeGlobals(args []string) map[string]*any {
+	argv := sliceVal{elem: "string", s: make([]any, len(args))}
+	for i, a := range args {
+		argv.s[i] = a
+	}
+	var stdout, stderr, osArgs any = io.Writer(in.stdout), in.stderr, argv
+	return map[string]*any{
+		"os.Args":   &osArgs,
+		"os.Stderr": &stderr,
+		"os.Stdout": &stdout,
+	}
+}
+
+func (in *interp) sortSlice(th *thread, args []any, stable bool) []any {
+	s := in.unwrap(args[0]).(sliceVal)
+	less := func(i, j int) bool {
+		return in.callValue(th, args[1], []any{i, j})[0].(bool)
+	}
+	if stable {
+		sort.SliceStable(s.s, less)
+	} else {
+		sort.Slice(s.s, less)
+	}
+	return nil
+}
+
+// unwrap returns the value held by an interface value.
+func (in *interp) unwrap(v any) any {
+	if x, ok := v.(iface); ok {
+		return x.val
+	}
+	return v
+}
+
+// stdlibCallee returns the function a call into the standard library
+// resolves to. Methods take their receiver as the first argument.
+func (in *interp) stdlibCallee(t *ir.StdlibTarget, args []any) (any, []any) {
+	if i := strings.Index(t.Symbol, "."); i >= 0 {
+		if len(args) == 0 {
+			panic(&irError{fmt.Sprintf("call of %s.%s without a receiver", t.Package, t.Symbol)})
+		}
+		return in.nativeMethod(args[0], t.Symbol[i+1:]), args[1:]
+	}
+	key := t.Package + "." + t.Symbol
+	f, ok := in.natives[key]
+	if !ok {
+		panic(&irError{fmt.Sprintf("%s is not implemented by the interpreter", key)})
+	}
+	return f, args
+}
+
+// nativeMethod returns method name of a standard library value.
+func (in *interp) nativeMethod(recv any, name string) nativeFunc {
+	var rv reflect.Value
+	switch r := recv.(type) {
+	case *any:
+		if r == nil {
+			panic(plainError("invalid memory address or nil pointer dereference"))
+		}
+		if nv, ok := (*r).(nativeVal); ok {
+			rv = nv.ptr
+		} else {
+			rv = reflect.ValueOf(in.native(r))
+		}
+	case nativeVal:
+		rv = r.ptr
+	case iface:
+		rv = reflect.ValueOf(in.native(r))
+	default:
+		rv = reflect.ValueOf(r)
+	}
+	if !rv.IsValid() {
+		panic(plainError("invalid memory address or nil pointer dereference"))
+	}
+	m := rv.MethodByName(name)
+	if !m.IsValid() {
+		panic(&irError{fmt.Sprintf("%s has no method %s", rv.Type(), name)})
+	}
+	return func(th *thread, args []any) []any {
+		return in.callReflect(m, args)
+	}
+}
+
+// callReflect calls a Go function. The IR passes variadic arguments as one
+// slice.
+func (in *interp) callReflect(fn reflect.Value, args []any) []any {
+	ft := fn.Type()
+	if len(args) != ft.NumIn() {
+		panic(&irError{fmt.Sprintf("%s called with %d arguments", ft, len(args))})
+	}
+	params := make([]reflect.Value, len(args))
+	for i, a := range args {
+		params[i] = in.toNative(a, ft.In(i))
+	}
+	var out []reflect.Value
+	if ft.IsVariadic() {
+		out = fn.CallSlice(params)
+	} else {
+		out = fn.Call(params)
+	}
+	// Functions such as sort.Ints modify the slices they are passed.
+	for i, a := range args {
+		if s, ok := a.(sliceVal); ok && params[i].Kind() == reflect.Slice && params[i].Len() == len(s.s) {
+			if _, basic := basicTypes[in.underlying(s.elem)]; basic {
+				for j := range s.s {
+					s.s[j] = in.fromNative(params[i].Index(j))
+				}
+			}
+		}
+	}
+	results := make([]any, len(out))
+	for i, r := range out {
+		results[i] = in.fromNative(r)
+	}
+	return results
+}
+
+// toNative converts v to a Go value of type t.
+func (in *interp) toNative(v any, t reflect.Type) reflect.Value {
+	if rv := reflect.ValueOf(v); rv.IsValid() && rv.Type() == t {
+		return rv
+	}
+	if t.Kind() == reflect.Interface {
+		x := in.native(v)
+		if x == nil {
+			return reflect.Zero(t)
+		}
+		return reflect.ValueOf(x)
+	}
+	if isNil(v) {
+		return reflect.Zero(t)
+	}
+	switch x := v.(type) {
+	case iface:
+		return in.toNative(x.val, t)
+	case *any:
+		if nv, ok := (*x).(nativeVal); ok && nv.ptr.Type() == t {
+			return nv.ptr
+		}
+	case nativeVal:
+		if x.ptr.Type() == t {
+			return x.ptr
+		}
+		return x.ptr.Elem()
+	case sliceVal:
+		if t.Kind() == reflect.Slice {
+			s := reflect.MakeSlice(t, len(x.s), len(x.s))
+			for i, e := range x.s {
+				s.Index(i).Set(in.toNative(e, t.Elem()))
+			}
+			return s
+		}
+	case *mapVal:
+		if t.Kind() == reflect.Map {
+			m := reflect.MakeMapWithSize(t, x.len())
+			for _, key := range x.order {
+				e := x.entries[key]
+				m.SetMapIndex(in.toNative(e.key, t.Key()), in.toNative(e.val, t.Elem()))
+			}
+			return m
+		}
+	case *closure:
+		if t.Kind() == reflect.Func {
+			return reflect.MakeFunc(t, func(params []reflect.Value) []reflect.Value {
+				args := make([]any, len(params))
+				for i, p := range params {
+					args[i] = in.fromNative(p)
+				}
+				res := in.callValue(&thread{}, x, args)
+				out := make([]reflect.Value, t.NumOut())
+				for i := range out {
+					out[i] = in.toNative(res[i], t.Out(i))
+				}
+				return out
+			})
+		}
+	}
+	rv := reflect.ValueOf(v)
+	if rv.IsValid() && rv.Type().ConvertibleTo(t) {
+		return rv.Convert(t)
+	}
+	panic(&irError{fmt.Sprintf("cannot pass %T as %s", v, t)})
+}
+
+// native converts v to the Go value printing functions and interface
+// parameters receive.
+func (in *interp) native(v any) any {
+	switch x := v.(type) {
+	case iface:
+		return in.nativeIface(x)
+	case *structVal:
+		return in.nativeStruct(x).Interface()
+	case *arrayVal:
+		return in.nativeSlice(sliceVal{elem: x.elem, s: x.elems}).Interface()
+	case sliceVal:
+		return in.nativeSlice(x).Interface()
+	case *mapVal:
+		return in.nativeMap(x).Interface()
+	case nativeVal:
+		return x.ptr.Elem().Interface()
+	case *any:
+		if x == nil {
+			return (*struct{})(nil)
+		}
+		switch target := (*x).(type) {
+		case nativeVal:
+			return target.ptr.Interface()
+		case *structVal:
+			s := in.nativeStruct(target)
+			p := reflect.New(s.Type())
+			p.Elem().Set(s)
+			return p.Interface()
+		}
+		elem := in.native(*x)
+		if elem == nil {
+			return new(any)
+		}
+		p := reflect.New(reflect.TypeOf(elem))
+		p.Elem().Set(reflect.ValueOf(elem))
+		return p.Interface()
+	case tuple:
+		panic(&irError{"tuple used as a value"})
+	}
+	return v
+}
+
+// nativeIface converts an interface value. Types whose Error or String
+// methods are in the IR print through them.
+func (in *interp) nativeIface(x iface) any {
+	if in.method(x.typ, "Error") != nil {
+		return errorValue{in: in, v: x}
+	}
+	if in.method(x.typ, "String") != nil {
+		return stringerValue{in: in, v: x}
+	}
+	v := in.native(x.val)
+	if rt, ok := nativeTypes[x.typ]; ok && v != nil && reflect.TypeOf(v).ConvertibleTo(rt) && rt.Kind() != reflect.Interface {
+		return reflect.ValueOf(v).Convert(rt).Interface()
+	}
+	return v
+}
+
+var anyType = reflect.TypeOf((*any)(nil)).Elem()
+
+// nativeStruct builds a Go struct with the field names and values of s, so
+// that fmt prints it like the original.
+func (in *interp) nativeStruct(s *structVal) reflect.Value {
+	var names []string
+	if td := in.typeDef(s.typ); td != nil {
+		for _, f := range td.Fields {
+			names = append(names, f.Name)
+		}
+	} else {
+		for _, f := range structFields(s.typ) {
+			names = append(names, f.Name)
+		}
+	}
+
+	fields := make([]reflect.StructField, len(s.fields))
+	vals := make([]any, len(s.fields))
+	for i, f := range s.fields {
+		vals[i] = in.native(f)
+		name := fmt.Sprintf("F%d", i)
+		if i < len(names) && names[i] != "_" {
+			name = names[i]
+		}
+		fields[i] = reflect.StructField{Name: name, Type: anyType}
+		if vals[i] != nil {
+			fields[i].Type = reflect.TypeOf(vals[i])
+		}
+		if !token.IsExported(name) {
+			fields[i].PkgPath = "main"
+		}
+	}
+	sv := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, val := range vals {
+		if val == nil {
+			continue
+		}
+		// Unexported fields can only be set through their address.
+		f := sv.Field(i)
+		reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem().Set(reflect.ValueOf(val))
+	}
+	return sv
+}
+
+func (in *interp) nativeSlice(s sliceVal) reflect.Value {
+	if bt := in.basicType(s.elem); bt != nil {
+		st := reflect.SliceOf(bt)
+		if s.s == nil {
+			return reflect.Zero(st)
+		}
+		out := reflect.MakeSlice(st, len(s.s), len(s.s))
+		for i, e := range s.s {
+			out.Index(i).Set(reflect.ValueOf(e).Convert(bt))
+		}
+		return out
+	}
+	if s.s == nil {
+		return reflect.ValueOf([]any(nil))
+	}
+	out := make([]any, len(s.s))
+	for i, e := range s.s {
+		out[i] = in.native(e)
+	}
+	return reflect.ValueOf(out)
+}
+
+func (in *interp) nativeMap(m *mapVal) reflect.Value {
+	kt, et := anyType, anyType
+	if m != nil {
+		if bt := in.basicType(m.key); bt != nil {
+			kt = bt
+		}
+		if bt := in.basicType(m.elem); bt != nil {
+			et = bt
+		}
+	}
+	mt := reflect.MapOf(kt, et)
+	if m == nil {
+		return reflect.Zero(mt)
+	}
+	out := reflect.MakeMapWithSize(mt, m.len())
+	for _, key := range m.order {
+		e := m.entries[key]
+		k, v := reflect.ValueOf(in.native(e.key)), reflect.ValueOf(in.native(e.val))
+		if kt != anyType {
+			k = k.Convert(kt)
+		}
+		if !v.IsValid() {
+			v = reflect.Zero(et)
+		} else if et != anyType {
+			v = v.Convert(et)
+		}
+		out.SetMapIndex(k, v)
+	}
+	return out
+}
+
+// fromNative converts a Go value returned by a native function.
+func (in *interp) fromNative(rv reflect.Value) any {
+	if !rv.IsValid() {
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return in.fromNative(rv.Elem())
+	case reflect.Slice:
+		elem := rv.Type().Elem()
+		name := elem.String()
+		if n, ok := kindNames[elem.Kind()]; ok {
+			name = n
+		}
+		if rv.IsNil() {
+			return sliceVal{elem: name}
+		}
+		s := make([]any, rv.Len())
+		for i := range s {
+			s[i] = in.fromNative(rv.Index(i))
+		}
+		return sliceVal{elem: name, s: s}
+	case reflect.Map:
+		m := newMap(kindNames[rv.Type().Key().Kind()], kindNames[rv.Type().Elem().Kind()])
+		iter := rv.MapRange()
+		for iter.Next() {
+			m.update(in.fromNative(iter.Key()), in.fromNative(iter.Value()))
+		}
+		return m
+	}
+	if name, ok := kindNames[rv.Kind()]; ok && rv.Type() != basicTypes[name] {
+		// Named basic types such as time.Duration become their underlying
+		// type; interface conversions restore them.
+		return rv.Convert(basicTypes[name]).Interface()
+	}
+	switch x := rv.Interface().(type) {
+	case errorValue:
+		return x.v
+	case stringerValue:
+		return x.v
+	}
+	return rv.Interface()
+}
+
+type errorValue struct {
+	in *interp
+	v  iface
+}
+
+func (e errorValue) Error() string { return e.in.callString(e.v, "Error") }
+
+type stringerValue struct {
+	in *interp
+	v  iface
+}
+
+func (s stringerValue) String() string { return s.in.callString(s.v, "String") }
+
+func (in *interp) callString(v iface, method string) string {
+	callee, args := in.invoke(v, method, nil)
+	res := in.callValue(&thread{}, callee, args)
+	s, _ := res[0].(string)
+	return s
+}
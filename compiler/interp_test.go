@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"testing"
+
+	"github.com/gonim/compiler/ir"
+)
+
+// loadIR generates the IR of the packages matched by patterns, run from
+// dir, and resolves it.
+func loadIR(t *testing.T, dir string, patterns ...string) *ir.Program {
+	t.Helper()
+	opts := loadOptions{dir: dir, jobs: 1, lazyBuild: true}
+	opts.addPatterns(patterns)
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		var out bytes.Buffer
+		diags.print(&out)
+		t.Fatalf("loading %v: %v\n%s", patterns, err, out.String())
+	}
+	hir := buildIR(p, false)
+	if n := diags.count(severityError); n > 0 {
+		var out bytes.Buffer
+		diags.print(&out)
+		t.Fatalf("generating the IR of %v: %d errors\n%s", patterns, n, out.String())
+	}
+	prog, err := ir.Resolve(&hir)
+	if err != nil {
+		t.Fatalf("resolving the IR of %v: %v", patterns, err)
+	}
+	return prog
+}
+
+func TestInterpMatchesGoRun(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	tests := []struct {
+		name    string
+		dir     string
+		pattern string
+		// section, if set, splits the output into sections whose lines may
+		// come in any order, as goroutines print them, like difftest does.
+		section string
+	}{
+		{"example", "../tests", "example.go", `^--- Test \d+: (.+) ---$`},
+		{"closures", "", "./testdata/closures", ""},
+		{"conversions", "", "./testdata/conversions", ""},
+		{"localtypes", "", "./testdata/localtypes", ""},
+		{"wrappers", "", "./testdata/wrappers", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(goTool, "run", tt.pattern)
+			cmd.Dir = tt.dir
+			want, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("go run %s: %v\n%s", tt.pattern, err, want)
+			}
+
+			prog := loadIR(t, tt.dir, tt.pattern)
+			var got bytes.Buffer
+			code, err := newInterp(prog, []string{prog.MainPkg}, &got, &got).run()
+			if err != nil {
+				t.Fatalf("run: %v\n%s", err, got.String())
+			}
+			if code != 0 {
+				t.Errorf("run exited with %d", code)
+			}
+			if tt.section == "" {
+				if got.String() != string(want) {
+					t.Errorf("run printed\n%s\ngo run printed\n%s", got.String(), want)
+				}
+				return
+			}
+			r := &diffRunner{section: regexp.MustCompile(tt.section)}
+			for _, s := range r.compare(string(want), got.String()) {
+				if s.Status != sectionMatch && s.Status != sectionReordered {
+					t.Errorf("section %s: %s %s", s.Name, s.Status, s.Detail)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gonim/compiler/ir"
+)
+
+// Values of the IR interpreter. Basic values are the Go values of the same
+// type; named basic types use their underlying type. A pointer is an *any
+// addressing a variable, a struct field or an element, so pointer identity
+// is Go pointer identity. Structs and arrays are copied on load and store.
+// Values returned by natively implemented stdlib functions (errors,
+// time.Time, ...) are kept as they are.
+
+type structVal struct {
+	typ    string
+	fields []any
+}
+
+type arrayVal struct {
+	elem  string
+	elems []any
+}
+
+// sliceVal shares its backing array with the slices and arrays it was made
+// from, as Go slices do.
+type sliceVal struct {
+	elem string
+	s    []any
+}
+
+// mapVal keeps the keys in insertion order; iteration order is unspecified
+// in Go, so any order is correct.
+type mapVal struct {
+	key, elem string
+	entries   map[any]*mapEntry
+	order     []any
+}
+
+type mapEntry struct {
+	key, val any
+}
+
+// iface is a non-nil interface value with its dynamic type.
+type iface struct {
+	typ string
+	val any
+}
+
+type closure struct {
+	fn   *ir.FunctionIR
+	free []any
+}
+
+// nativeVal is a variable of a stdlib struct type such as sync.WaitGroup,
+// whose methods are called natively.
+type nativeVal struct {
+	ptr reflect.Value
+}
+
+type tuple []any
+
+func newMap(key, elem string) *mapVal {
+	return &mapVal{key: key, elem: elem, entries: make(map[any]*mapEntry)}
+}
+
+func (m *mapVal) len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.entries)
+}
+
+func (m *mapVal) lookup(k any) (any, bool) {
+	if m == nil {
+		return nil, false
+	}
+	e, ok := m.entries[mapKey(k)]
+	if !ok {
+		return nil, false
+	}
+	return e.val, true
+}
+
+func (m *mapVal) update(k, v any) {
+	if m == nil {
+		panic(plainError("assignment to entry in nil map"))
+	}
+	key := mapKey(k)
+	if e, ok := m.entries[key]; ok {
+		e.val = v
+		return
+	}
+	m.entries[key] = &mapEntry{key: k, val: v}
+	m.order = append(m.order, key)
+}
+
+func (m *mapVal) delete(k any) {
+	if m == nil {
+		return
+	}
+	key := mapKey(k)
+	if _, ok := m.entries[key]; !ok {
+		return
+	}
+	delete(m.entries, key)
+	for i, o := range m.order {
+		if o == key {
+			m.order = append(m.order[:i:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// mapKey returns a comparable Go value identifying a key; structs, arrays
+// and interfaces compare by their contents.
+func mapKey(k any) any {
+	switch k := k.(type) {
+	case *structVal, *arrayVal, iface:
+		return fmt.Sprintf("%T:%s", k, keyString(k))
+	}
+	return k
+}
+
+func keyString(v any) string {
+	switch v := v.(type) {
+	case *structVal:
+		parts := make([]string, len(v.fields))
+		for i, f := range v.fields {
+			parts[i] = keyString(f)
+		}
+		return v.typ + "{" + strings.Join(parts, ",") + "}"
+	case *arrayVal:
+		parts := make([]string, len(v.elems))
+		for i, e := range v.elems {
+			parts[i] = keyString(e)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case iface:
+		return v.typ + "(" + keyString(v.val) + ")"
+	case string:
+		return strconv.Quote(v)
+	}
+	return fmt.Sprintf("%T(%v)", v, v)
+}
+
+// plainError is a runtime error raised by the interpreter itself, printed
+// like the Go runtime prints it.
+type plainError string
+
+func (e plainError) Error() string { return "runtime error: " + string(e) }
+
+// copyValue copies the struct and array parts of v, which have value
+// semantics.
+func copyValue(v any) any {
+	switch v := v.(type) {
+	case *structVal:
+		fields := make([]any, len(v.fields))
+		for i, f := range v.fields {
+			fields[i] = copyValue(f)
+		}
+		return &structVal{typ: v.typ, fields: fields}
+	case *arrayVal:
+		elems := make([]any, len(v.elems))
+		for i, e := range v.elems {
+			elems[i] = copyValue(e)
+		}
+		return &arrayVal{elem: v.elem, elems: elems}
+	}
+	return v
+}
+
+// equal implements == for interpreter values.
+func equal(a, b any) bool {
+	if isNil(a) || isNil(b) {
+		return isNil(a) && isNil(b)
+	}
+	switch a := a.(type) {
+	case *structVal:
+		b, ok := b.(*structVal)
+		if !ok || len(a.fields) != len(b.fields) {
+			return false
+		}
+		for i := range a.fields {
+			if !equal(a.fields[i], b.fields[i]) {
+				return false
+			}
+		}
+		return true
+	case *arrayVal:
+		b, ok := b.(*arrayVal)
+		if !ok || len(a.elems) != len(b.elems) {
+			return false
+		}
+		for i := range a.elems {
+			if !equal(a.elems[i], b.elems[i]) {
+				return false
+			}
+		}
+		return true
+	case iface:
+		b, ok := b.(iface)
+		return ok && a.typ == b.typ && equal(a.val, b.val)
+	case *closure, sliceVal, *mapVal:
+		panic(plainError(fmt.Sprintf("comparing uncomparable type %T", a)))
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	return a == b
+}
+
+// isNil reports whether v is a nil pointer, slice, map, channel, function or
+// interface.
+func isNil(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case *any:
+		return v == nil
+	case sliceVal:
+		return v.s == nil
+	case *mapVal:
+		return v == nil
+	case chan any:
+		return v == nil
+	case *closure:
+		return v == nil
+	case error:
+		return false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+var basicTypes = map[string]reflect.Type{
+	"bool":       reflect.TypeOf(false),
+	"int":        reflect.TypeOf(int(0)),
+	"int8":       reflect.TypeOf(int8(0)),
+	"int16":      reflect.TypeOf(int16(0)),
+	"int32":      reflect.TypeOf(int32(0)),
+	"rune":       reflect.TypeOf(int32(0)),
+	"int64":      reflect.TypeOf(int64(0)),
+	"uint":       reflect.TypeOf(uint(0)),
+	"uint8":      reflect.TypeOf(uint8(0)),
+	"byte":       reflect.TypeOf(uint8(0)),
+	"uint16":     reflect.TypeOf(uint16(0)),
+	"uint32":     reflect.TypeOf(uint32(0)),
+	"uint64":     reflect.TypeOf(uint64(0)),
+	"uintptr":    reflect.TypeOf(uintptr(0)),
+	"float32":    reflect.TypeOf(float32(0)),
+	"float64":    reflect.TypeOf(float64(0)),
+	"complex64":  reflect.TypeOf(complex64(0)),
+	"complex128": reflect.TypeOf(complex128(0)),
+	"string":     reflect.TypeOf(""),
+
+	"untyped bool":    reflect.TypeOf(false),
+	"untyped int":     reflect.TypeOf(int(0)),
+	"untyped rune":    reflect.TypeOf(int32(0)),
+	"untyped float":   reflect.TypeOf(float64(0)),
+	"untyped complex": reflect.TypeOf(complex128(0)),
+	"untyped string":  reflect.TypeOf(""),
+}
+
+// splitTopLevel splits s at sep outside of brackets, braces and parentheses.
+func splitTopLevel(s, sep string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		default:
+			if depth == 0 && strings.HasPrefix(s[i:], sep) {
+				parts = append(parts, s[start:i])
+				start = i + len(sep)
+				i += len(sep) - 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// tupleTypes returns the element types of a tuple type string such as
+// "(n int, err error)".
+func tupleTypes(t string) []string {
+	t = strings.TrimSuffix(strings.TrimPrefix(t, "("), ")")
+	if t == "" {
+		return nil
+	}
+	parts := splitTopLevel(t, ", ")
+	for i, p := range parts {
+		// Drop the element name, if any.
+		if sp := strings.Index(p, " "); sp > 0 && !strings.ContainsAny(p[:sp], "*[]().{}") && !isTypeKeyword(p[:sp]) {
+			p = p[sp+1:]
+		}
+		parts[i] = p
+	}
+	return parts
+}
+
+func isTypeKeyword(s string) bool {
+	switch s {
+	case "map", "chan", "func", "struct", "interface", "<-chan", "chan<-":
+		return true
+	}
+	return false
+}
+
+// parseBasicConst converts the text of a constant of basic type t.
+func parseBasicConst(text string, t reflect.Type) (any, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return text == "true", nil
+	case reflect.String:
+		return reflect.ValueOf(text).Convert(t).Interface(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := new(big.Int).SetString(text, 0)
+		if !ok {
+			f, _, err := big.ParseFloat(text, 10, 256, big.ToNearestEven)
+			if err != nil {
+				return nil, err
+			}
+			n, _ = f.Int(nil)
+		}
+		v := reflect.New(t).Elem()
+		if t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uintptr {
+			v.SetUint(n.Uint64())
+		} else {
+			v.SetInt(n.Int64())
+		}
+		return v.Interface(), nil
+	case reflect.Float32, reflect.Float64:
+		f, _, err := big.ParseFloat(text, 10, 256, big.ToNearestEven)
+		if err != nil {
+			return nil, err
+		}
+		x, _ := f.Float64()
+		return reflect.ValueOf(x).Convert(t).Interface(), nil
+	case reflect.Complex64, reflect.Complex128:
+		c, err := strconv.ParseComplex(strings.ReplaceAll(text, " ", ""), 128)
+		if err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(c).Convert(t).Interface(), nil
+	}
+	return nil, fmt.Errorf("unsupported constant type %s", t)
+}
+
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+type float interface {
+	~float32 | ~float64
+}
+
+type ordered interface {
+	integer | float | ~string
+}
+
+// arith implements the binary operators other than shifts and equality on
+// operands of the same basic type.
+func arith(op string, x, y any) (any, bool) {
+	switch x := x.(type) {
+	case int:
+		return intOp(op, x, y.(int))
+	case int8:
+		return intOp(op, x, y.(int8))
+	case int16:
+		return intOp(op, x, y.(int16))
+	case int32:
+		return intOp(op, x, y.(int32))
+	case int64:
+		return intOp(op, x, y.(int64))
+	case uint:
+		return intOp(op, x, y.(uint))
+	case uint8:
+		return intOp(op, x, y.(uint8))
+	case uint16:
+		return intOp(op, x, y.(uint16))
+	case uint32:
+		return intOp(op, x, y.(uint32))
+	case uint64:
+		return intOp(op, x, y.(uint64))
+	case uintptr:
+		return intOp(op, x, y.(uintptr))
+	case float32:
+		return floatOp(op, x, y.(float32))
+	case float64:
+		return floatOp(op, x, y.(float64))
+	case complex64:
+		return complexOp(op, x, y.(complex64))
+	case complex128:
+		return complexOp(op, x, y.(complex128))
+	case string:
+		if op == "+" {
+			return x + y.(string), true
+		}
+		return compare(op, x, y.(string))
+	}
+	return nil, false
+}
+
+func compare[T ordered](op string, x, y T) (any, bool) {
+	switch op {
+	case "<":
+		return x < y, true
+	case "<=":
+		return x <= y, true
+	case ">":
+		return x > y, true
+	case ">=":
+		return x >= y, true
+	}
+	return nil, false
+}
+
+func intOp[T integer](op string, x, y T) (any, bool) {
+	switch op {
+	case "+":
+		return x + y, true
+	case "-":
+		return x - y, true
+	case "*":
+		return x * y, true
+	case "/":
+		return x / y, true
+	case "%":
+		return x % y, true
+	case "&":
+		return x & y, true
+	case "|":
+		return x | y, true
+	case "^":
+		return x ^ y, true
+	case "&^":
+		return x &^ y, true
+	}
+	return compare(op, x, y)
+}
+
+func floatOp[T float](op string, x, y T) (any, bool) {
+	switch op {
+	case "+":
+		return x + y, true
+	case "-":
+		return x - y, true
+	case "*":
+		return x * y, true
+	case "/":
+		return x / y, true
+	}
+	return compare(op, x, y)
+}
+
+func complexOp[T ~complex64 | ~complex128](op string, x, y T) (any, bool) {
+	switch op {
+	case "+":
+		return x + y, true
+	case "-":
+		return x - y, true
+	case "*":
+		return x * y, true
+	case "/":
+		return x / y, true
+	}
+	return nil, false
+}
+
+// shift implements << and >>, whose count may have any integer type.
+func shift(op string, x, y any) any {
+	var n uint64
+	rv := reflect.ValueOf(y)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() < 0 {
+			panic(plainError("negative shift amount"))
+		}
+		n = uint64(rv.Int())
+	default:
+		n = rv.Uint()
+	}
+	switch x := x.(type) {
+	case int:
+		return shiftOp(op, x, n)
+	case int8:
+		return shiftOp(op, x, n)
+	case int16:
+		return shiftOp(op, x, n)
+	case int32:
+		return shiftOp(op, x, n)
+	case int64:
+		return shiftOp(op, x, n)
+	case uint:
+		return shiftOp(op, x, n)
+	case uint8:
+		return shiftOp(op, x, n)
+	case uint16:
+		return shiftOp(op, x, n)
+	case uint32:
+		return shiftOp(op, x, n)
+	case uint64:
+		return shiftOp(op, x, n)
+	case uintptr:
+		return shiftOp(op, x, n)
+	}
+	panic(&irError{fmt.Sprintf("cannot shift %T", x)})
+}
+
+func shiftOp[T integer](op string, x T, n uint64) T {
+	if op == "<<" {
+		return x << n
+	}
+	return x >> n
+}
+
+func negate(x any) any {
+	switch x := x.(type) {
+	case int:
+		return -x
+	case int8:
+		return -x
+	case int16:
+		return -x
+	case int32:
+		return -x
+	case int64:
+		return -x
+	case uint:
+		return -x
+	case uint8:
+		return -x
+	case uint16:
+		return -x
+	case uint32:
+		return -x
+	case uint64:
+		return -x
+	case uintptr:
+		return -x
+	case float32:
+		return -x
+	case float64:
+		return -x
+	case complex64:
+		return -x
+	case complex128:
+		return -x
+	}
+	panic(&irError{fmt.Sprintf("cannot negate %T", x)})
+}
+
+func complement(x any) any {
+	switch x := x.(type) {
+	case int:
+		return ^x
+	case int8:
+		return ^x
+	case int16:
+		return ^x
+	case int32:
+		return ^x
+	case int64:
+		return ^x
+	case uint:
+		return ^x
+	case uint8:
+		return ^x
+	case uint16:
+		return ^x
+	case uint32:
+		return ^x
+	case uint64:
+		return ^x
+	case uintptr:
+		return ^x
+	}
+	panic(&irError{fmt.Sprintf("cannot complement %T", x)})
+}
+
+func complexBuiltin(name string, args []any) any {
+	switch name {
+	case "real", "imag":
+		switch c := args[0].(type) {
+		case complex64:
+			if name == "real" {
+				return real(c)
+			}
+			return imag(c)
+		case complex128:
+			if name == "real" {
+				return real(c)
+			}
+			return imag(c)
+		}
+	case "complex":
+		switch r := args[0].(type) {
+		case float32:
+			return complex(r, args[1].(float32))
+		case float64:
+			return complex(r, args[1].(float64))
+		}
+	}
+	panic(&irError{fmt.Sprintf("cannot apply %s to %T", name, args[0])})
+}
@@ -0,0 +1,686 @@
+package ir
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The binary IR encoding stores the same data model as the JSON encoding
+// (null, booleans, numbers, strings, arrays and objects keyed by the JSON
+// field names) in a compact, self-describing form:
+//
+//	file    = magic version stringCount string* value
+//	magic   = "GNIR"
+//	version = uvarint
+//	string  = uvarint(len) bytes
+//	value   = tag payload
+//
+// Every string, including object keys, is stored once in the string table
+// and referenced by its uvarint index. Tags and payloads:
+//
+//	0 null
+//	1 false
+//	2 true
+//	3 int     zigzag varint
+//	4 float   8 bytes, IEEE 754 little endian
+//	5 string  uvarint string index
+//	6 array   uvarint count, values
+//	7 object  uvarint count, (uvarint key index, value) pairs
+const (
+	binaryMagic   = "GNIR"
+	binaryVersion = 1
+)
+
+const (
+	tagNull byte = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagFloat
+	tagString
+	tagArray
+	tagObject
+)
+
+// IsBinary reports whether data starts with the binary IR magic.
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryMagic))
+}
+
+type binaryEncoder struct {
+	body    bytes.Buffer
+	strings []string
+	index   map[string]uint64
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// MarshalBinary encodes v, a value of the IR types or a tree produced by
+// DecodeBinaryTree, in the binary IR encoding.
+func MarshalBinary(v any) ([]byte, error) {
+	e := &binaryEncoder{index: make(map[string]uint64)}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(binaryMagic)
+	e.writeUvarint(&out, binaryVersion)
+	e.writeUvarint(&out, uint64(len(e.strings)))
+	for _, s := range e.strings {
+		e.writeUvarint(&out, uint64(len(s)))
+		out.WriteString(s)
+	}
+	out.Write(e.body.Bytes())
+	return out.Bytes(), nil
+}
+
+func (e *binaryEncoder) writeUvarint(w *bytes.Buffer, x uint64) {
+	n := binary.PutUvarint(e.scratch[:], x)
+	w.Write(e.scratch[:n])
+}
+
+func (e *binaryEncoder) writeString(s string) {
+	idx, ok := e.index[s]
+	if !ok {
+		idx = uint64(len(e.strings))
+		e.index[s] = idx
+		e.strings = append(e.strings, s)
+	}
+	e.writeUvarint(&e.body, idx)
+}
+
+func (e *binaryEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.body.WriteByte(tagNull)
+		return nil
+	}
+
+	if tree, ok := v.Interface().(*binaryObject); ok {
+		return e.encodeObject(tree)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.body.WriteByte(tagNull)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.body.WriteByte(tagTrue)
+		} else {
+			e.body.WriteByte(tagFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.body.WriteByte(tagInt)
+		n := binary.PutVarint(e.scratch[:], v.Int())
+		e.body.Write(e.scratch[:n])
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return fmt.Errorf("binary IR: integer %d out of range", v.Uint())
+		}
+		e.body.WriteByte(tagInt)
+		n := binary.PutVarint(e.scratch[:], int64(v.Uint()))
+		e.body.Write(e.scratch[:n])
+	case reflect.Float32, reflect.Float64:
+		e.body.WriteByte(tagFloat)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v.Float()))
+		e.body.Write(b[:])
+	case reflect.String:
+		e.body.WriteByte(tagString)
+		e.writeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.body.WriteByte(tagNull)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.body.WriteByte(tagNull)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("binary IR: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *binaryEncoder) encodeArray(v reflect.Value) error {
+	e.body.WriteByte(tagArray)
+	e.writeUvarint(&e.body, uint64(v.Len()))
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *binaryEncoder) encodeMap(v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("binary IR: unsupported map key type %s", v.Type().Key())
+	}
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+
+	e.body.WriteByte(tagObject)
+	e.writeUvarint(&e.body, uint64(len(keys)))
+	for _, k := range keys {
+		e.writeString(k)
+		if err := e.encode(v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *binaryEncoder) encodeStruct(v reflect.Value) error {
+	fields := jsonFields(v.Type())
+	present := make([]jsonField, 0, len(fields))
+	for _, f := range fields {
+		if f.omitEmpty && isEmptyValue(v.Field(f.index)) {
+			continue
+		}
+		present = append(present, f)
+	}
+
+	e.body.WriteByte(tagObject)
+	e.writeUvarint(&e.body, uint64(len(present)))
+	for _, f := range present {
+		e.writeString(f.name)
+		if err := e.encode(v.Field(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *binaryEncoder) encodeObject(obj *binaryObject) error {
+	e.body.WriteByte(tagObject)
+	e.writeUvarint(&e.body, uint64(len(obj.Keys)))
+	for i, k := range obj.Keys {
+		e.writeString(k)
+		if err := e.encode(reflect.ValueOf(obj.Values[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// jsonFields lists the exported fields of t under their encoding/json names.
+func jsonFields(t reflect.Type) []jsonField {
+	fields := make([]jsonField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, jsonField{
+			name:      name,
+			index:     i,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+type binaryDecoder struct {
+	r       *bufio.Reader
+	strings []string
+}
+
+func newBinaryDecoder(r io.Reader) (*binaryDecoder, error) {
+	d := &binaryDecoder{r: bufio.NewReader(r)}
+
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != binaryMagic {
+		return nil, errors.New("binary IR: bad magic")
+	}
+	version, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, fmt.Errorf("binary IR: %w", err)
+	}
+	if version != binaryVersion {
+		return nil, fmt.Errorf("binary IR: unsupported version %d", version)
+	}
+
+	count, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, fmt.Errorf("binary IR: %w", err)
+	}
+	d.strings = make([]string, 0, min(count, 1<<16))
+	for i := uint64(0); i < count; i++ {
+		n, err := binary.ReadUvarint(d.r)
+		if err != nil {
+			return nil, fmt.Errorf("binary IR: %w", err)
+		}
+		if n > math.MaxInt32 {
+			return nil, fmt.Errorf("binary IR: string length %d too large", n)
+		}
+		// Lengths are not trusted: the string grows as its bytes arrive.
+		var sb strings.Builder
+		if _, err := io.CopyN(&sb, d.r, int64(n)); err != nil {
+			return nil, fmt.Errorf("binary IR: %w", err)
+		}
+		d.strings = append(d.strings, sb.String())
+	}
+	return d, nil
+}
+
+// UnmarshalBinary decodes binary IR into v, which must be a pointer to one
+// of the IR types.
+func UnmarshalBinary(data []byte, v any) error {
+	d, err := newBinaryDecoder(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("binary IR: decode target must be a non-nil pointer")
+	}
+	return d.decode(rv.Elem())
+}
+
+func (d *binaryDecoder) readString() (string, error) {
+	idx, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return "", err
+	}
+	if idx >= uint64(len(d.strings)) {
+		return "", fmt.Errorf("binary IR: string index %d out of range", idx)
+	}
+	return d.strings[idx], nil
+}
+
+func (d *binaryDecoder) readCount() (int, error) {
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("binary IR: count %d too large", n)
+	}
+	return int(n), nil
+}
+
+func (d *binaryDecoder) decode(v reflect.Value) error {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if tag == tagNull {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if err := d.r.UnreadByte(); err != nil {
+			return err
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		if err := d.r.UnreadByte(); err != nil {
+			return err
+		}
+		tree, err := d.decodeTree()
+		if err != nil {
+			return err
+		}
+		if tree != nil {
+			v.Set(reflect.ValueOf(tree))
+		}
+		return nil
+	}
+
+	switch tag {
+	case tagFalse, tagTrue:
+		if v.Kind() != reflect.Bool {
+			return d.mismatch("bool", v)
+		}
+		v.SetBool(tag == tagTrue)
+	case tagInt:
+		n, err := binary.ReadVarint(d.r)
+		if err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v.SetUint(uint64(n))
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(n))
+		default:
+			return d.mismatch("int", v)
+		}
+	case tagFloat:
+		var b [8]byte
+		if _, err := io.ReadFull(d.r, b[:]); err != nil {
+			return err
+		}
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return d.mismatch("float", v)
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b[:])))
+	case tagString:
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		if v.Kind() != reflect.String {
+			return d.mismatch("string", v)
+		}
+		v.SetString(s)
+	case tagArray:
+		n, err := d.readCount()
+		if err != nil {
+			return err
+		}
+		if v.Kind() != reflect.Slice {
+			return d.mismatch("array", v)
+		}
+		v.Set(reflect.MakeSlice(v.Type(), 0, min(n, 1<<16)))
+		for i := 0; i < n; i++ {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(elem); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v, elem))
+		}
+	case tagObject:
+		switch v.Kind() {
+		case reflect.Struct:
+			return d.decodeStruct(v)
+		case reflect.Map:
+			return d.decodeMap(v)
+		default:
+			return d.mismatch("object", v)
+		}
+	default:
+		return fmt.Errorf("binary IR: unknown tag %d", tag)
+	}
+	return nil
+}
+
+func (d *binaryDecoder) mismatch(kind string, v reflect.Value) error {
+	return fmt.Errorf("binary IR: cannot decode %s into %s", kind, v.Type())
+}
+
+func (d *binaryDecoder) decodeStruct(v reflect.Value) error {
+	n, err := d.readCount()
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]int)
+	for _, f := range jsonFields(v.Type()) {
+		byName[f.name] = f.index
+	}
+
+	for i := 0; i < n; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return err
+		}
+		idx, ok := byName[key]
+		if !ok {
+			// Unknown fields are skipped, as encoding/json does.
+			if _, err := d.decodeTree(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decode(v.Field(idx)); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (d *binaryDecoder) decodeMap(v reflect.Value) error {
+	n, err := d.readCount()
+	if err != nil {
+		return err
+	}
+	if v.Type().Key().Kind() != reflect.String {
+		return d.mismatch("object", v)
+	}
+
+	v.Set(reflect.MakeMapWithSize(v.Type(), min(n, 1<<16)))
+	for i := 0; i < n; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return err
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := d.decode(elem); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+	}
+	return nil
+}
+
+// binaryObject is an object of a schema-less tree; it keeps the order of its
+// keys so that conversions between encodings are lossless.
+type binaryObject struct {
+	Keys   []string
+	Values []any
+}
+
+func (o *binaryObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.Keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		val, err := json.Marshal(o.Values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeTree decodes the next value into nil, bool, int64, float64, string,
+// []any or *binaryObject.
+func (d *binaryDecoder) decodeTree() (any, error) {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case tagNull:
+		return nil, nil
+	case tagFalse:
+		return false, nil
+	case tagTrue:
+		return true, nil
+	case tagInt:
+		return binary.ReadVarint(d.r)
+	case tagFloat:
+		var b [8]byte
+		if _, err := io.ReadFull(d.r, b[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:])), nil
+	case tagString:
+		return d.readString()
+	case tagArray:
+		n, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+		arr := make([]any, 0, min(n, 1<<16))
+		for i := 0; i < n; i++ {
+			elem, err := d.decodeTree()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, elem)
+		}
+		return arr, nil
+	case tagObject:
+		n, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+		obj := &binaryObject{}
+		for i := 0; i < n; i++ {
+			key, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.decodeTree()
+			if err != nil {
+				return nil, err
+			}
+			obj.Keys = append(obj.Keys, key)
+			obj.Values = append(obj.Values, val)
+		}
+		return obj, nil
+	default:
+		return nil, fmt.Errorf("binary IR: unknown tag %d", tag)
+	}
+}
+
+// DecodeBinaryTree decodes a whole binary IR file into a schema-less tree.
+func DecodeBinaryTree(data []byte) (any, error) {
+	d, err := newBinaryDecoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeTree()
+}
+
+// DecodeJSONTree decodes JSON into the same schema-less tree as
+// DecodeBinaryTree, keeping object key order.
+func DecodeJSONTree(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tree, err := readJSONTree(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return tree, nil
+}
+
+func readJSONTree(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '[':
+			arr := make([]any, 0)
+			for dec.More() {
+				elem, err := readJSONTree(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, elem)
+			}
+			_, err := dec.Token()
+			return arr, err
+		case '{':
+			obj := &binaryObject{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := readJSONTree(dec)
+				if err != nil {
+					return nil, err
+				}
+				obj.Keys = append(obj.Keys, keyTok.(string))
+				obj.Values = append(obj.Values, val)
+			}
+			_, err := dec.Token()
+			return obj, err
+		}
+		return nil, fmt.Errorf("unexpected delimiter %v", t)
+	case json.Number:
+		if n, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return n, nil
+		}
+		return t.Float64()
+	default:
+		// nil, bool and string.
+		return t, nil
+	}
+}
@@ -0,0 +1,162 @@
+package ir
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// sampleIR exercises the value kinds of the encoding: empty and nil slices,
+// maps, nested pointers, negative numbers and non-ASCII strings.
+func sampleIR() *HybridIR {
+	return &HybridIR{
+		Build:   BuildInfo{Patterns: []string{"./..."}, Tags: []string{}, GOOS: "linux", GOARCH: "amd64", Mode: "executable"},
+		MainPkg: "example.com/app",
+		Packages: []PackageIR{{
+			Path: "example.com/app",
+			Name: "main",
+			Types: []TypeDef{{
+				Name:     "Point",
+				Kind:     "struct",
+				Fields:   []FieldDef{{Name: "X", Type: "int", Tag: `json:"x"`}, {Name: "Y", Type: "int"}},
+				Methods:  []string{"Move"},
+				Exported: true,
+				Doc:      "Point is a point in the plane — with unicode.\n",
+			}},
+			Functions: []FunctionIR{{
+				Name:      "main",
+				Package:   "example.com/app",
+				Signature: FuncSignature{Params: []Param{}, Results: []Param{}},
+				Body: &BodyIR{
+					Blocks: []BlockIR{{
+						ID: 0,
+						Instructions: []Instruction{
+							{
+								Op: "Call", Args: []string{"fmt.Println", "t0"}, Type: "(n int, err error)", Result: "t1",
+								Tuple: []string{"int", "error"},
+								Call:  &CallIR{Kind: CallStatic, Value: "fmt.Println", Target: "fmt.Println", Args: []string{"t0"}},
+							},
+							{Op: "BinOp", Args: []string{"t1", "-1:int"}, Type: "int", Result: "t2", Operation: &OperationIR{Operator: "*", X: "t1", Y: "-1:int"}},
+							{Op: "Return", Return: &ReturnIR{Values: []string{}}},
+						},
+						Successors:   []int{},
+						Predecessors: []int{},
+						Idom:         -1,
+						PostIdom:     -1,
+						Loop:         -1,
+					}},
+					Values:      []ValueIR{{Name: "t1", Kind: ValueRegister, Type: "int", Index: -1}},
+					StructHints: map[string]HintIR{"if_3_2": {Kind: "if", Lines: []int{3}}, "for_5_2": {Kind: "for", Lines: []int{5}}},
+				},
+			}},
+			Constants: []ConstDef{{Name: "Pi", Type: "untyped float", Value: "3.14159", Exported: true}},
+			Imports:   []string{"fmt"},
+		}},
+		Diagnostics: []Diagnostic{},
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	hir := sampleIR()
+	data, err := MarshalBinary(hir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsBinary(data) {
+		t.Fatalf("encoding does not start with %q", binaryMagic)
+	}
+	var got HybridIR
+	if err := UnmarshalBinary(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(hir)
+	gotJSON, _ := json.Marshal(&got)
+	if !bytes.Equal(gotJSON, want) {
+		t.Errorf("round trip changed the IR:\n got %s\nwant %s", gotJSON, want)
+	}
+}
+
+func TestBinaryTreeRoundTrip(t *testing.T) {
+	hir := sampleIR()
+	data, err := MarshalBinary(hir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := DecodeBinaryTree(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := MarshalBinary(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("re-encoding the tree changed the encoding")
+	}
+
+	// The JSON encoding decodes to the same tree.
+	js, _ := json.Marshal(hir)
+	jsonTree, err := DecodeJSONTree(js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := MarshalBinary(jsonTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b HybridIR
+	if err := UnmarshalBinary(fromJSON, &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmarshalBinary(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	aJSON, _ := json.Marshal(&a)
+	bJSON, _ := json.Marshal(&b)
+	if !bytes.Equal(aJSON, bJSON) {
+		t.Errorf("JSON and binary trees differ:\n%s\n%s", aJSON, bJSON)
+	}
+}
+
+func TestBinaryUntrustedLengths(t *testing.T) {
+	header := func(values ...uint64) []byte {
+		out := []byte(binaryMagic)
+		out = binary.AppendUvarint(out, binaryVersion)
+		for _, v := range values {
+			out = binary.AppendUvarint(out, v)
+		}
+		return out
+	}
+	// packages holds the array of the given count bytes in an object.
+	packages := func(count ...byte) []byte {
+		out := append(header(1, uint64(len("packages"))), "packages"...)
+		return append(append(out, tagObject, 1, 0, tagArray), count...)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"huge string count", header(1 << 62), "EOF"},
+		{"huge string length", header(1, 1<<40), "too large"},
+		{"truncated string", append(header(1, 1<<20), "abc"...), "EOF"},
+		{"huge array", packages(0xff, 0xff, 0xff, 0x7f), "EOF"},
+		{"huge object", append(header(0), tagObject, 0xff, 0xff, 0xff, 0x7f), "EOF"},
+		{"array count too large", packages(0xff, 0xff, 0xff, 0xff, 0x0f), "too large"},
+		{"bad magic", []byte("JSON"), "bad magic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hir HybridIR
+			err := UnmarshalBinary(tt.data, &hir)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+			if _, err := DecodeBinaryTree(tt.data); err == nil {
+				t.Errorf("DecodeBinaryTree succeeded")
+			}
+		})
+	}
+}
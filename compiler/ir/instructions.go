@@ -0,0 +1,204 @@
+package ir
+
+// The structured encodings of instructions. Each instruction sets the one
+// matching its op, so backends do not have to parse the comment; operands
+// are named as in Args. Jump, If, Panic, RunDefers, Send and Store are
+// fully described by their Args (If: cond, Panic: value, Send: channel and
+// value, Store: address and value) and the successors of their block.
+
+// AllocIR describes an Alloc of a value of type Elem, on the heap if Heap.
+// Var is the source variable it holds, if any.
+type AllocIR struct {
+	Elem string `json:"elem"`
+	Heap bool   `json:"heap"`
+	Var  string `json:"var,omitempty"`
+}
+
+// OperationIR describes a BinOp or UnOp. Operator is the Go operator, with
+// <- for receives and * for loads; CommaOk receives also yield whether the
+// channel was open.
+type OperationIR struct {
+	Operator string `json:"operator"`
+	X        string `json:"x"`
+	Y        string `json:"y,omitempty"`
+	CommaOk  bool   `json:"comma_ok,omitempty"`
+}
+
+// PhiIR describes a Phi: the value of each edge, by predecessor block, and
+// the source variable it merges, if any.
+type PhiIR struct {
+	Edges []PhiEdge `json:"edges"`
+	Var   string    `json:"var,omitempty"`
+}
+
+type PhiEdge struct {
+	Block int    `json:"block"`
+	Value string `json:"value"`
+}
+
+// Kinds of the operand of a Slice or element access.
+const (
+	KindSlice        = "slice"
+	KindArray        = "array"
+	KindArrayPointer = "array_pointer"
+	KindString       = "string"
+	KindMap          = "map"
+	KindTypeParam    = "type_param"
+)
+
+// SliceIR describes a Slice of X, which is a slice, string or pointer to
+// array; the bounds are empty when omitted.
+type SliceIR struct {
+	X    string `json:"x"`
+	Kind string `json:"kind"`
+	Low  string `json:"low,omitempty"`
+	High string `json:"high,omitempty"`
+	Max  string `json:"max,omitempty"`
+}
+
+// AccessIR describes an element access: IndexAddr and Index of a slice,
+// array, pointer to array or string, and Lookup and MapUpdate of a map. Key
+// is the index or map key, Value the value MapUpdate stores, and CommaOk
+// lookups also yield whether the key was present.
+type AccessIR struct {
+	X        string `json:"x"`
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	KeyType  string `json:"key_type"`
+	ElemType string `json:"elem_type"`
+	CommaOk  bool   `json:"comma_ok,omitempty"`
+}
+
+// FieldIR describes a FieldAddr of the struct X points to, or a Field of
+// the struct X.
+type FieldIR struct {
+	X        string `json:"x"`
+	Struct   string `json:"struct"`
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	Embedded bool   `json:"embedded,omitempty"`
+}
+
+// ConvertIR describes a Convert, ChangeType, ChangeInterface, MultiConvert,
+// SliceToArrayPointer or MakeInterface of X from type From to type To. The
+// kinds are the names of basic underlying types (int, uint8, string,
+// unsafe.Pointer, ...) or slice, array, pointer, map, chan, func, struct,
+// interface and type_param.
+type ConvertIR struct {
+	X        string `json:"x"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	FromKind string `json:"from_kind"`
+	ToKind   string `json:"to_kind"`
+}
+
+// TypeAssertIR describes a TypeAssert of X to Asserted, which is an
+// interface type if Interface.
+type TypeAssertIR struct {
+	X         string `json:"x"`
+	Asserted  string `json:"asserted"`
+	Interface bool   `json:"interface,omitempty"`
+	CommaOk   bool   `json:"comma_ok,omitempty"`
+}
+
+// MakeIR describes a MakeSlice (Len, Cap), MakeMap (Reserve, empty for
+// the default), MakeChan (Size) or MakeClosure (Fn, Bindings for its free
+// variables).
+type MakeIR struct {
+	Len      string   `json:"len,omitempty"`
+	Cap      string   `json:"cap,omitempty"`
+	Reserve  string   `json:"reserve,omitempty"`
+	Size     string   `json:"size,omitempty"`
+	Fn       string   `json:"fn,omitempty"`
+	Bindings []string `json:"bindings,omitempty"`
+	KeyType  string   `json:"key_type,omitempty"`
+	ElemType string   `json:"elem_type,omitempty"`
+}
+
+// RangeIR describes a Range over the string or map X, which yields an
+// iterator, or a Next of the iterator X, which yields (ok, key, value).
+type RangeIR struct {
+	X         string `json:"x"`
+	Kind      string `json:"kind"`
+	KeyType   string `json:"key_type"`
+	ValueType string `json:"value_type"`
+}
+
+// SelectIR describes a Select. It yields (index, recvOk, values of the
+// receive states in order); the index is -1 if a non-blocking select took
+// the default case.
+type SelectIR struct {
+	Blocking bool          `json:"blocking"`
+	States   []SelectState `json:"states"`
+}
+
+// SelectState is a case of a select: Dir is "send" or "recv", and Send the
+// value sent.
+type SelectState struct {
+	Dir  string `json:"dir"`
+	Chan string `json:"chan"`
+	Send string `json:"send,omitempty"`
+}
+
+// ExtractIR describes an Extract of element Index of Tuple, the result of
+// a call, comma-ok operation, Next or Select.
+type ExtractIR struct {
+	Tuple string `json:"tuple"`
+	Index int    `json:"index"`
+
+	// Source is set by Resolve to the instruction defining Tuple.
+	Source *Instruction `json:"-"`
+}
+
+// ReturnIR describes a Return of Values, one per result. Names holds the
+// names of named results, and is empty otherwise.
+type ReturnIR struct {
+	Values []string `json:"values"`
+	Names  []string `json:"names,omitempty"`
+}
+
+// Kinds of calls.
+const (
+	CallStatic  = "static"  // of a function
+	CallMethod  = "method"  // of a method of a concrete type, receiver first
+	CallInvoke  = "invoke"  // of a method of an interface value
+	CallClosure = "closure" // of a function value
+	CallBuiltin = "builtin"
+)
+
+// CallIR describes the call of a Call, Go or Defer: the function value
+// called, or for invocations of interface methods the receiver and Method,
+// and the arguments. Target is the FuncKey of the function called by static
+// and method calls, and by closure calls of a closure made in the caller;
+// generic functions are referred to by their origin. Recv is the receiver
+// type of a method, or the interface type of an invocation.
+type CallIR struct {
+	Kind    string     `json:"kind"`
+	Value   string     `json:"value"`
+	Target  string     `json:"target,omitempty"`
+	Recv    string     `json:"recv,omitempty"`
+	Method  string     `json:"method,omitempty"`
+	Args    []string   `json:"args"`
+	Builtin *BuiltinIR `json:"builtin,omitempty"`
+}
+
+// BuiltinIR describes a call of a builtin function. Name is the builtin,
+// qualified as unsafe.Slice for those of package unsafe, and ArgTypes the
+// types of its arguments; SSA passes the variadic arguments of append as a
+// single slice or string. Kind is the kind of the operand the runtime
+// specializes on: the appended or copied source for append and copy, the
+// result for min and max, and the first argument otherwise.
+type BuiltinIR struct {
+	Name     string   `json:"name"`
+	ArgTypes []string `json:"arg_types"`
+	Kind     string   `json:"kind,omitempty"`
+}
+
+// DebugRefIR describes a DebugRef, emitted only in debug mode: X is the
+// value, or the address if IsAddr, of the source expression Expr.
+type DebugRefIR struct {
+	X      string `json:"x"`
+	Expr   string `json:"expr"`
+	IsAddr bool   `json:"is_addr,omitempty"`
+}
@@ -0,0 +1,382 @@
+// Package ir defines the hybrid IR that gonim-compile emits for the Nim
+// backend, its JSON and binary encodings, and a loader that reads the IR
+// back with its references resolved.
+package ir
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type HybridIR struct {
+	Build       BuildInfo       `json:"build"`
+	Module      *ModuleManifest `json:"module,omitempty"`
+	Tests       *TestManifest   `json:"tests,omitempty"`
+	Packages    []PackageIR     `json:"packages"`
+	MainPkg     string          `json:"main_package"`
+	Diagnostics []Diagnostic    `json:"diagnostics"`
+}
+
+type BuildInfo struct {
+	Patterns []string `json:"patterns"`
+	Tags     []string `json:"tags"`
+	GOOS     string   `json:"goos"`
+	GOARCH   string   `json:"goarch"`
+	Mod      string   `json:"mod,omitempty"`
+	Mode     string   `json:"mode"`
+}
+
+type PackageIR struct {
+	Path       string       `json:"path"`
+	Name       string       `json:"name"`
+	Types      []TypeDef    `json:"types"`
+	Functions  []FunctionIR `json:"functions"`
+	Globals    []GlobalVar  `json:"globals"`
+	Constants  []ConstDef   `json:"constants"`
+	Imports    []string     `json:"imports"`
+	CGOImports []CGOImport  `json:"cgo_imports"`
+	API        []APISymbol  `json:"api,omitempty"`
+}
+
+type CGOImport struct {
+	CFlags  []string `json:"cflags"`
+	LDFlags []string `json:"ldflags"`
+	Headers []string `json:"headers"`
+	PkgPath string   `json:"pkg_path"`
+}
+
+type TypeDef struct {
+	Name       string         `json:"name"`
+	Kind       string         `json:"kind"`
+	Fields     []FieldDef     `json:"fields,omitempty"`
+	Methods    []string       `json:"methods,omitempty"`
+	Underlying string         `json:"underlying,omitempty"`
+	Signature  *FuncSignature `json:"signature,omitempty"`
+	Exported   bool           `json:"exported"`
+	Doc        string         `json:"doc,omitempty"`
+	Deprecated string         `json:"deprecated,omitempty"`
+}
+
+type FieldDef struct {
+	Name string `json:"name"`
+	Type string `json:"typ"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+type FunctionIR struct {
+	Name       string        `json:"name"`
+	Receiver   *ReceiverInfo `json:"receiver,omitempty"`
+	Signature  FuncSignature `json:"signature"`
+	Body       *BodyIR       `json:"body,omitempty"`
+	IsMethod   bool          `json:"is_method"`
+	Package    string        `json:"package"`
+	Exported   bool          `json:"exported"`
+	Doc        string        `json:"doc,omitempty"`
+	Deprecated string        `json:"deprecated,omitempty"`
+	Wrapper    *WrapperIR    `json:"wrapper,omitempty"`
+
+	// Pkg and Recv are set by Resolve; Recv is nil if the receiver type is
+	// not in the IR.
+	Pkg  *PackageIR `json:"-"`
+	Recv *TypeDef   `json:"-"`
+}
+
+type ReceiverInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Pointer bool   `json:"pointer"`
+}
+
+type FuncSignature struct {
+	Params   []Param `json:"params"`
+	Results  []Param `json:"results"`
+	Variadic bool    `json:"variadic"`
+}
+
+type Param struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type BodyIR struct {
+	Blocks       []BlockIR         `json:"blocks"`
+	Values       []ValueIR         `json:"values"`
+	NamedResults []NamedResult     `json:"named_results"`
+	Locals       []LocalVar        `json:"locals"`
+	FreeVars     []string          `json:"free_vars"`
+	StructHints  map[string]HintIR `json:"struct_hints"`
+	Defers       []DeferInfo       `json:"defers"`
+	Loops        []LoopIR          `json:"loops"`
+
+	values map[string]*ValueIR
+}
+
+// Kinds of wrappers.
+const (
+	WrapperBound = "bound" // for the method value x.M
+	WrapperThunk = "thunk" // for the method expression T.M
+)
+
+// WrapperIR describes a function SSA synthesizes for a method value or
+// method expression. A bound wrapper has the receiver of type Recv as its
+// only free variable, which the closure binds; a thunk takes it as first
+// parameter. Both call Method: statically through Target, its FuncKey, or
+// by invoking it if Recv is an interface. Wrappers belong to the package
+// that uses them and are named like (*T).M$bound relative to it.
+type WrapperIR struct {
+	Kind      string `json:"kind"`
+	Recv      string `json:"recv"`
+	Method    string `json:"method"`
+	Target    string `json:"target,omitempty"`
+	Interface bool   `json:"interface,omitempty"`
+}
+
+// NamedResult is a named result variable of a function. Alloc is the
+// register of its Alloc while it lives in memory, as when a deferred call
+// may change it, and empty once SSA lifted it to registers.
+type NamedResult struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Alloc string `json:"alloc,omitempty"`
+}
+
+// Kinds of values.
+const (
+	ValueReceiver = "receiver"
+	ValueParam    = "param"
+	ValueFreeVar  = "free_var"
+	ValueRegister = "register"
+	ValueGlobal   = "global"
+	ValueFunction = "function"
+	ValueConst    = "const"
+	ValueBuiltin  = "builtin"
+)
+
+// ValueIR declares a value a function body defines or uses as an operand,
+// under the name instruction Args refer to it by. Index is the position of
+// parameters (not counting the receiver) and free variables, and Block the
+// block defining a register; both are -1 for other kinds. Ref is the
+// qualified name of globals (example.com/lib.Count) and the FuncKey of
+// functions. Value is the exact value of a constant, in Go syntax, or empty
+// for the zero value of its type.
+type ValueIR struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Block int    `json:"block"`
+	Ref   string `json:"ref,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Value returns the value named name in the body, or nil. Parameters and
+// registers shadow globals and functions of the same name.
+func (b *BodyIR) Value(name string) *ValueIR {
+	if b.values != nil {
+		return b.values[name]
+	}
+	for i := range b.Values {
+		if b.Values[i].Name == name {
+			return &b.Values[i]
+		}
+	}
+	return nil
+}
+
+// LoopIR is a natural loop: the blocks dominated by Header that reach one of
+// the Latches, which branch back to it. Exits are the blocks outside the
+// loop that it branches to. Loops are sorted by header; Parent is the index
+// of the innermost enclosing loop or -1, and Depth is 1 for outermost loops.
+type LoopIR struct {
+	Header  int   `json:"header"`
+	Latches []int `json:"latches"`
+	Blocks  []int `json:"blocks"`
+	Exits   []int `json:"exits"`
+	Parent  int   `json:"parent"`
+	Depth   int   `json:"depth"`
+}
+
+type HintIR struct {
+	Kind   string   `json:"kind"`
+	Lines  []int    `json:"lines"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+type DeferInfo struct {
+	BlockID int    `json:"block_id"`
+	Call    string `json:"call"`
+
+	Block *BlockIR `json:"-"`
+}
+
+// BlockIR is a basic block. Predecessors are in the order of the edges of
+// the block's phis. Idom and PostIdom are the immediate dominator and
+// post-dominator, or -1 for the entry block and unreachable blocks, and for
+// blocks post-dominated only by the function exit or that never reach it.
+// Loop is the index of the innermost loop containing the block or -1, and
+// LoopDepth the number of loops containing it.
+type BlockIR struct {
+	ID           int           `json:"id"`
+	Instructions []Instruction `json:"instructions"`
+	Successors   []int         `json:"successors"`
+	Predecessors []int         `json:"predecessors"`
+	Idom         int           `json:"idom"`
+	Dominees     []int         `json:"dominees"`
+	PostIdom     int           `json:"post_idom"`
+	Loop         int           `json:"loop"`
+	LoopDepth    int           `json:"loop_depth"`
+	Comment      string        `json:"comment,omitempty"`
+
+	// Succs and Preds are set by Resolve.
+	Succs []*BlockIR `json:"-"`
+	Preds []*BlockIR `json:"-"`
+}
+
+type Instruction struct {
+	Op       string        `json:"op"`
+	Args     []string      `json:"args,omitempty"`
+	Type     string        `json:"type,omitempty"`
+	Tuple    []string      `json:"tuple,omitempty"` // element types of a tuple Type
+	Result   string        `json:"result,omitempty"`
+	Comment  string        `json:"comment,omitempty"`
+	Position int           `json:"position,omitempty"`
+	Stdlib   *StdlibTarget `json:"stdlib,omitempty"`
+
+	Alloc      *AllocIR      `json:"alloc,omitempty"`
+	Operation  *OperationIR  `json:"operation,omitempty"`
+	Phi        *PhiIR        `json:"phi,omitempty"`
+	Slice      *SliceIR      `json:"slice,omitempty"`
+	Access     *AccessIR     `json:"access,omitempty"`
+	Field      *FieldIR      `json:"field,omitempty"`
+	Convert    *ConvertIR    `json:"convert,omitempty"`
+	TypeAssert *TypeAssertIR `json:"type_assert,omitempty"`
+	Make       *MakeIR       `json:"make,omitempty"`
+	Range      *RangeIR      `json:"range,omitempty"`
+	Select     *SelectIR     `json:"select,omitempty"`
+	Extract    *ExtractIR    `json:"extract,omitempty"`
+	Return     *ReturnIR     `json:"return,omitempty"`
+	Call       *CallIR       `json:"call,omitempty"`
+	DebugRef   *DebugRefIR   `json:"debug_ref,omitempty"`
+
+	// Callee is set by Resolve for static calls of functions in the IR.
+	Callee *FunctionIR `json:"-"`
+}
+
+type LocalVar struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type GlobalVar struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Value      string `json:"value,omitempty"`
+	Exported   bool   `json:"exported"`
+	Doc        string `json:"doc,omitempty"`
+	Deprecated string `json:"deprecated,omitempty"`
+}
+
+type ConstDef struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Exported   bool   `json:"exported"`
+	Doc        string `json:"doc,omitempty"`
+	Deprecated string `json:"deprecated,omitempty"`
+}
+
+type StdlibTarget struct {
+	Package   string `json:"package"`
+	Symbol    string `json:"symbol"`
+	NimModule string `json:"nim_module"`
+	NimProc   string `json:"nim_proc"`
+}
+
+// ModuleManifest describes the Nim modules generated for a Go module in
+// library mode, so hand-written Nim code knows what to import.
+type ModuleManifest struct {
+	Path      string          `json:"path"`
+	Version   string          `json:"version,omitempty"`
+	GoVersion string          `json:"go_version,omitempty"`
+	Packages  []ModulePackage `json:"packages"`
+}
+
+type ModulePackage struct {
+	Path      string   `json:"path"`
+	Name      string   `json:"name"`
+	NimModule string   `json:"nim_module"`
+	Imports   []string `json:"imports"`
+	Exports   int      `json:"exports"`
+}
+
+// APISymbol is an exported declaration of a library package. NimName is
+// the name the generated Nim module exports it under, with the export
+// marker.
+type APISymbol struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Receiver string `json:"receiver,omitempty"`
+	Type     string `json:"type"`
+	NimName  string `json:"nim_name"`
+}
+
+// TestManifest lists the tests, benchmarks, examples and fuzz targets
+// declared in the _test.go files of the loaded packages, grouped by the
+// package they test.
+type TestManifest struct {
+	Packages []TestPackage `json:"packages"`
+}
+
+// TestPackage holds the test functions of one package and of its external
+// _test package, in the order go test runs them.
+type TestPackage struct {
+	Path       string     `json:"path"`
+	Tests      []TestFunc `json:"tests"`
+	Benchmarks []TestFunc `json:"benchmarks"`
+	Examples   []TestFunc `json:"examples"`
+	Fuzz       []TestFunc `json:"fuzz"`
+	TestMain   *TestFunc  `json:"test_main,omitempty"`
+}
+
+// TestFunc is a test function. Examples carry their expected output;
+// examples without an output comment are compiled but not run.
+type TestFunc struct {
+	Name      string `json:"name"`
+	Package   string `json:"package"`
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Output    string `json:"output,omitempty"`
+	HasOutput bool   `json:"has_output,omitempty"`
+	Unordered bool   `json:"unordered,omitempty"`
+}
+
+type Diagnostic struct {
+	Severity   string `json:"severity"`
+	Code       string `json:"code"`
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line,omitempty"`
+	Column     int    `json:"column,omitempty"`
+	Package    string `json:"package,omitempty"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (d Diagnostic) position() string {
+	if d.File == "" {
+		return "-"
+	}
+	pos := d.File
+	if d.Line > 0 {
+		pos += ":" + strconv.Itoa(d.Line)
+		if d.Column > 0 {
+			pos += ":" + strconv.Itoa(d.Column)
+		}
+	}
+	return pos
+}
+
+// String formats the diagnostic the way compilers do: file:line:col: severity: message.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s [%s]", d.position(), d.Severity, d.Message, d.Code)
+}
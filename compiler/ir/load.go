@@ -0,0 +1,337 @@
+package ir
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Program is a HybridIR with its symbolic references resolved. Packages,
+// functions and types can be looked up by name, and the IR structs point at
+// what they refer to: functions at their package and receiver type, blocks
+// at their successors and predecessors, defers at their block and static
+// calls at the function they call.
+type Program struct {
+	*HybridIR
+	packages  map[string]*PackageIR
+	functions map[string]*FunctionIR
+	types     map[string]*TypeDef
+}
+
+// ValidationError lists the invariants an IR violates.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid IR: " + e.Problems[0]
+	}
+	return fmt.Sprintf("invalid IR: %s (and %d more problems)", e.Problems[0], len(e.Problems)-1)
+}
+
+// Load reads an IR file in the JSON or binary encoding. Like Resolve, it
+// returns the program along with a validation error.
+func Load(path string) (*Program, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := Decode(data)
+	if err != nil {
+		return prog, fmt.Errorf("%s: %w", path, err)
+	}
+	return prog, nil
+}
+
+// Decode decodes IR in the JSON or binary encoding and resolves it.
+func Decode(data []byte) (*Program, error) {
+	var hir HybridIR
+	var err error
+	if IsBinary(data) {
+		err = UnmarshalBinary(data, &hir)
+	} else {
+		err = json.Unmarshal(data, &hir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Resolve(&hir)
+}
+
+// Resolve resolves the references of hir and validates it: block IDs match
+// their index, every successor exists, the predecessors of every block are
+// the blocks branching to it, every block ends in a terminator with as many
+// successors as it branches to, every register an instruction uses is
+// defined in its function, every operand is in the value table of its
+// function, every phi has one edge per predecessor, and the functions that
+// static calls and wrappers target are in the IR when their package is.
+// Violations are reported as a *ValidationError; the program is returned
+// either way, with the references that could be resolved. hir must not be
+// modified afterwards.
+func Resolve(hir *HybridIR) (*Program, error) {
+	p := &Program{
+		HybridIR:  hir,
+		packages:  make(map[string]*PackageIR),
+		functions: make(map[string]*FunctionIR),
+		types:     make(map[string]*TypeDef),
+	}
+	r := &resolver{prog: p, globals: make(map[string]bool)}
+
+	for i := range hir.Packages {
+		pkg := &hir.Packages[i]
+		if p.packages[pkg.Path] != nil {
+			r.problem("duplicate package %s", pkg.Path)
+			continue
+		}
+		p.packages[pkg.Path] = pkg
+		for j := range pkg.Types {
+			p.types[pkg.Path+"."+pkg.Types[j].Name] = &pkg.Types[j]
+		}
+		for j := range pkg.Functions {
+			fn := &pkg.Functions[j]
+			fn.Pkg = pkg
+			key := FuncKey(fn)
+			if p.functions[key] != nil {
+				r.problem("duplicate function %s", key)
+				continue
+			}
+			p.functions[key] = fn
+		}
+		for _, g := range pkg.Globals {
+			r.globals[g.Name] = true
+		}
+	}
+	if hir.MainPkg != "" && p.packages[hir.MainPkg] == nil {
+		r.problem("main package %s is not in the IR", hir.MainPkg)
+	}
+
+	for i := range hir.Packages {
+		for j := range hir.Packages[i].Functions {
+			r.function(&hir.Packages[i].Functions[j])
+		}
+	}
+	if len(r.problems) > 0 {
+		return p, &ValidationError{Problems: r.problems}
+	}
+	return p, nil
+}
+
+// Package returns the package with the given path, or nil.
+func (p *Program) Package(path string) *PackageIR {
+	return p.packages[path]
+}
+
+// Function returns the function with the given FuncKey, or nil.
+func (p *Program) Function(key string) *FunctionIR {
+	return p.functions[key]
+}
+
+// Type returns the definition of the named type t, such as
+// example.com/lib.Stack, *example.com/lib.Stack or example.com/lib.Stack[int],
+// or nil if t is not a named type of the IR.
+func (p *Program) Type(t string) *TypeDef {
+	return p.types[StripTypeArgs(strings.TrimPrefix(t, "*"))]
+}
+
+// Main returns the main function of the main package, or nil.
+func (p *Program) Main() *FunctionIR {
+	return p.functions[p.MainPkg+".main"]
+}
+
+var registerRe = regexp.MustCompile(`^t\d+$`)
+
+// terminators maps the ops that end a block to their number of successors.
+var terminators = map[string]int{
+	"Jump":   1,
+	"If":     2,
+	"Return": 0,
+	"Panic":  0,
+}
+
+type resolver struct {
+	prog     *Program
+	globals  map[string]bool
+	problems []string
+}
+
+func (r *resolver) problem(format string, args ...any) {
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+func (r *resolver) function(fn *FunctionIR) {
+	name := FuncKey(fn)
+	if fn.Receiver != nil {
+		fn.Recv = r.prog.Type(fn.Receiver.Type)
+		pkg, _ := SplitQualified(StripTypeArgs(fn.Receiver.Type))
+		if fn.Recv == nil && r.prog.packages[pkg] != nil {
+			r.problem("%s: receiver type %s is not in the IR", name, fn.Receiver.Type)
+		}
+	}
+	if w := fn.Wrapper; w != nil && w.Target != "" && r.missing(w.Target) {
+		r.problem("%s: wraps %s, which is not in the IR", name, w.Target)
+	}
+	if fn.Body == nil {
+		return
+	}
+	body := fn.Body
+	blocks := body.Blocks
+	body.values = make(map[string]*ValueIR, len(body.Values))
+	for i := range body.Values {
+		if v := &body.Values[i]; body.values[v.Name] == nil {
+			body.values[v.Name] = v
+		}
+	}
+
+	defined := make(map[string]bool)
+	if fn.Receiver != nil {
+		defined[fn.Receiver.Name] = true
+	}
+	for _, param := range fn.Signature.Params {
+		defined[param.Name] = true
+	}
+	for _, fv := range body.FreeVars {
+		defined[fv] = true
+	}
+	definers := make(map[string]*Instruction)
+	for i := range blocks {
+		b := &blocks[i]
+		b.Succs, b.Preds = nil, nil
+		if b.ID != i {
+			r.problem("%s: block %d has ID %d", name, i, b.ID)
+		}
+		for j := range b.Instructions {
+			if res := b.Instructions[j].Result; res != "" {
+				defined[res] = true
+				definers[res] = &b.Instructions[j]
+			}
+		}
+	}
+	exists := func(b int) bool { return b >= 0 && b < len(blocks) }
+	edges := make([][]int, len(blocks))
+	for i := range blocks {
+		b := &blocks[i]
+		for _, s := range b.Successors {
+			if !exists(s) {
+				r.problem("%s: block %d: successor %d does not exist", name, i, s)
+				continue
+			}
+			b.Succs = append(b.Succs, &blocks[s])
+			edges[s] = append(edges[s], i)
+		}
+		for _, d := range []int{b.Idom, b.PostIdom} {
+			if d != -1 && !exists(d) {
+				r.problem("%s: block %d: dominator %d does not exist", name, i, d)
+			}
+		}
+		if b.Loop < -1 || b.Loop >= len(body.Loops) {
+			r.problem("%s: block %d: loop %d does not exist", name, i, b.Loop)
+		}
+	}
+	for i := range blocks {
+		b := &blocks[i]
+		preds := append([]int(nil), b.Predecessors...)
+		sort.Ints(preds)
+		if !slices.Equal(preds, edges[i]) {
+			r.problem("%s: block %d: predecessors %v do not match the edges from %v", name, i, b.Predecessors, edges[i])
+			continue
+		}
+		for _, p := range b.Predecessors {
+			b.Preds = append(b.Preds, &blocks[p])
+		}
+	}
+
+	for i := range blocks {
+		b := &blocks[i]
+		if len(b.Instructions) == 0 {
+			r.problem("%s: block %d is empty", name, i)
+			continue
+		}
+		last := b.Instructions[len(b.Instructions)-1]
+		if n, ok := terminators[last.Op]; !ok {
+			r.problem("%s: block %d ends in %s instead of a terminator", name, i, last.Op)
+		} else if n != len(b.Successors) {
+			r.problem("%s: block %d: %s has %d successors, want %d", name, i, last.Op, len(b.Successors), n)
+		}
+		for j := range b.Instructions {
+			inst := &b.Instructions[j]
+			if _, ok := terminators[inst.Op]; ok && j != len(b.Instructions)-1 {
+				r.problem("%s: block %d: %s before the end of the block", name, i, inst.Op)
+			}
+			for _, arg := range inst.Args {
+				if registerRe.MatchString(arg) && !defined[arg] && !r.globals[arg] {
+					r.problem("%s: block %d: %s uses undefined register %s", name, i, inst.Op, arg)
+				} else if body.values[arg] == nil {
+					r.problem("%s: block %d: operand %s of %s is not in the value table", name, i, arg, inst.Op)
+				}
+			}
+			if inst.Op == "Phi" && len(inst.Args) != len(b.Preds) {
+				r.problem("%s: block %d: phi %s has %d edges for %d predecessors", name, i, inst.Result, len(inst.Args), len(b.Preds))
+			}
+			inst.Callee = r.callee(fn, inst, defined)
+			if c := inst.Call; inst.Callee == nil && c != nil && (c.Kind == CallStatic || c.Kind == CallMethod) && r.missing(c.Target) {
+				r.problem("%s: block %d: %s of %s, which is not in the IR", name, i, inst.Op, c.Target)
+			}
+			if e := inst.Extract; e != nil {
+				e.Source = definers[e.Tuple]
+				if e.Source == nil {
+					r.problem("%s: block %d: extract from %s, which no instruction defines", name, i, e.Tuple)
+				} else if n := len(e.Source.Tuple); n > 0 && (e.Index < 0 || e.Index >= n) {
+					r.problem("%s: block %d: extract of element %d of %s, which has %d", name, i, e.Index, e.Tuple, n)
+				}
+			}
+			if ret := inst.Return; ret != nil && len(ret.Values) != len(fn.Signature.Results) {
+				r.problem("%s: block %d: return of %d values for %d results", name, i, len(ret.Values), len(fn.Signature.Results))
+			}
+		}
+	}
+
+	for i := range body.Defers {
+		d := &body.Defers[i]
+		if d.BlockID < 0 || d.BlockID >= len(blocks) {
+			r.problem("%s: defer in block %d, which does not exist", name, d.BlockID)
+			continue
+		}
+		d.Block = &blocks[d.BlockID]
+	}
+}
+
+// callee returns the function of the IR a Call, Go or Defer instruction
+// calls statically, or nil for dynamic calls and calls of functions that
+// are not in the IR, such as builtins and the standard library.
+func (r *resolver) callee(fn *FunctionIR, inst *Instruction, defined map[string]bool) *FunctionIR {
+	switch inst.Op {
+	case "Call", "Go", "Defer":
+	default:
+		return nil
+	}
+	if c := inst.Call; c != nil {
+		if c.Kind != CallStatic && c.Kind != CallMethod {
+			return nil
+		}
+		return r.prog.functions[c.Target]
+	}
+	call := strings.TrimPrefix(strings.TrimPrefix(inst.Comment, "go "), "defer ")
+	if len(inst.Args) == 0 || defined[inst.Args[0]] || strings.HasPrefix(call, "invoke ") {
+		return nil
+	}
+	return r.prog.functions[QualifyFunc(fn.Package, CalleeName(call))]
+}
+
+// missing reports whether the function with FuncKey key belongs to a
+// package of the IR but is not in it. Functions of other packages, such as
+// the standard library, are not expected in the IR.
+func (r *resolver) missing(key string) bool {
+	if r.prog.functions[key] != nil {
+		return false
+	}
+	pkg, _ := SplitQualified(key)
+	if i := strings.Index(key, ".("); i >= 0 {
+		pkg = key[:i]
+	}
+	return r.prog.packages[pkg] != nil
+}
@@ -0,0 +1,139 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+)
+
+// diamondIR returns a program whose main function branches on its
+// parameter and joins both branches with a phi:
+//
+//	0: if c goto 1 else 2
+//	1: jump 3
+//	2: jump 3
+//	3: t0 = phi [1: 1:int, 2: 2:int]; return t0
+func diamondIR() *HybridIR {
+	jump := func(id, to int) BlockIR {
+		return BlockIR{
+			ID:           id,
+			Instructions: []Instruction{{Op: "Jump"}},
+			Successors:   []int{to},
+			Predecessors: []int{0},
+			Idom:         0,
+			PostIdom:     to,
+			Loop:         -1,
+		}
+	}
+	return &HybridIR{
+		MainPkg: "example.com/app",
+		Packages: []PackageIR{{
+			Path: "example.com/app",
+			Name: "main",
+			Functions: []FunctionIR{{
+				Name:    "main",
+				Package: "example.com/app",
+				Signature: FuncSignature{
+					Params:  []Param{{Name: "c", Type: "bool"}},
+					Results: []Param{{Type: "int"}},
+				},
+				Body: &BodyIR{
+					Blocks: []BlockIR{
+						{
+							ID:           0,
+							Instructions: []Instruction{{Op: "If", Args: []string{"c"}}},
+							Successors:   []int{1, 2},
+							Idom:         -1,
+							PostIdom:     3,
+							Loop:         -1,
+						},
+						jump(1, 3),
+						jump(2, 3),
+						{
+							ID: 3,
+							Instructions: []Instruction{
+								{Op: "Phi", Args: []string{"1:int", "2:int"}, Type: "int", Result: "t0"},
+								{Op: "Return", Args: []string{"t0"}, Return: &ReturnIR{Values: []string{"t0"}}},
+							},
+							Predecessors: []int{1, 2},
+							Idom:         0,
+							PostIdom:     -1,
+							Loop:         -1,
+						},
+					},
+					Values: []ValueIR{
+						{Name: "c", Kind: ValueParam, Type: "bool", Block: -1},
+						{Name: "t0", Kind: ValueRegister, Type: "int", Index: -1, Block: 3},
+						{Name: "1:int", Kind: ValueConst, Type: "int", Index: -1, Block: -1},
+						{Name: "2:int", Kind: ValueConst, Type: "int", Index: -1, Block: -1},
+					},
+				},
+			}},
+		}},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	prog, err := Resolve(diamondIR())
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := prog.Main()
+	if main == nil {
+		t.Fatal("no main function")
+	}
+	if join := &main.Body.Blocks[3]; len(join.Preds) != 2 || join.Preds[0] != &main.Body.Blocks[1] {
+		t.Errorf("block 3 has predecessors %v", join.Preds)
+	}
+}
+
+func TestResolveInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(body *BodyIR)
+		problem string
+	}{
+		{
+			"successor out of range",
+			func(body *BodyIR) { body.Blocks[1].Successors = []int{7} },
+			"block 1: successor 7 does not exist",
+		},
+		{
+			"negative successor",
+			func(body *BodyIR) { body.Blocks[2].Successors = []int{-1} },
+			"block 2: successor -1 does not exist",
+		},
+		{
+			"undefined register",
+			func(body *BodyIR) {
+				ret := &body.Blocks[3].Instructions[1]
+				ret.Args, ret.Return.Values = []string{"t9"}, []string{"t9"}
+			},
+			"Return uses undefined register t9",
+		},
+		{
+			"phi edges",
+			func(body *BodyIR) { body.Blocks[3].Instructions[0].Args = []string{"1:int"} },
+			"phi t0 has 1 edges for 2 predecessors",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hir := diamondIR()
+			tt.corrupt(hir.Packages[0].Functions[0].Body)
+			prog, err := Resolve(hir)
+			if prog == nil {
+				t.Error("Resolve returned no program")
+			}
+			verr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("got error %v, want a *ValidationError", err)
+			}
+			for _, p := range verr.Problems {
+				if strings.Contains(p, tt.problem) {
+					return
+				}
+			}
+			t.Errorf("problems %q do not report %q", verr.Problems, tt.problem)
+		})
+	}
+}
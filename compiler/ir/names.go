@@ -0,0 +1,99 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FuncKey returns the name a function is referenced by across the IR: the
+// package path and name, such as example.com/app.main, or for methods the
+// receiver in parentheses, such as example.com/app.(*Point).Move.
+func FuncKey(fn *FunctionIR) string {
+	if fn.Receiver != nil {
+		_, name := SplitQualified(StripTypeArgs(fn.Receiver.Type))
+		return MethodKey(fn.Package, name, fn.Receiver.Pointer, fn.Name)
+	}
+	return fn.Package + "." + fn.Name
+}
+
+// MethodKey returns the FuncKey of the method name of the named type
+// typeName of package pkg.
+func MethodKey(pkg, typeName string, pointer bool, name string) string {
+	star := ""
+	if pointer {
+		star = "*"
+	}
+	return fmt.Sprintf("%s.(%s%s).%s", pkg, star, typeName, name)
+}
+
+// CalleeName returns the function called in a call comment, such as fib,
+// example.com/lib.New or (*Point).Move.
+func CalleeName(call string) string {
+	if strings.HasPrefix(call, "(") {
+		depth := 0
+		for i := 0; i < len(call); i++ {
+			switch call[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					rest := call[i+1:]
+					if j := strings.Index(rest, "("); j >= 0 {
+						rest = rest[:j]
+					}
+					return call[:i+1] + StripTypeArgs(rest)
+				}
+			}
+		}
+	}
+	if i := strings.Index(call, "("); i >= 0 {
+		call = call[:i]
+	}
+	return StripTypeArgs(call)
+}
+
+// SplitMethodName splits a method name such as (*Point).Move.
+func SplitMethodName(name string) (string, string, bool) {
+	end := strings.LastIndex(name, ").")
+	if !strings.HasPrefix(name, "(") || end < 0 {
+		return "", "", false
+	}
+	return name[1:end], name[end+2:], true
+}
+
+// QualifyFunc returns the FuncKey of a function named in a call comment of
+// package pkg, where names of pkg are unqualified.
+func QualifyFunc(pkg, name string) string {
+	if recv, method, ok := SplitMethodName(name); ok {
+		pointer := strings.HasPrefix(recv, "*")
+		recvPkg, typeName := SplitQualified(StripTypeArgs(strings.TrimPrefix(recv, "*")))
+		if recvPkg == "" {
+			recvPkg = pkg
+		}
+		return MethodKey(recvPkg, typeName, pointer, method)
+	}
+	if p, _ := SplitQualified(name); p != "" {
+		return name
+	}
+	return pkg + "." + name
+}
+
+// SplitQualified splits a qualified name such as example.com/lib.Stack into
+// the package path and the name.
+func SplitQualified(s string) (string, string) {
+	slash := strings.LastIndex(s, "/")
+	dot := strings.Index(s[slash+1:], ".")
+	if dot < 0 {
+		return "", s
+	}
+	return s[:slash+1+dot], s[slash+2+dot:]
+}
+
+// StripTypeArgs removes the type arguments of instantiated generic names.
+func StripTypeArgs(s string) string {
+	if i := strings.Index(s, "["); i > 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"go/types"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+)
+
+const (
+	modeExecutable = "executable"
+	modeLibrary    = "library"
+	modeTest       = "test"
+)
+
+// extractAPI lists the exported types, methods, functions, constants and
+// variables of pkg, sorted by name with methods following their type.
+func extractAPI(pkg *ssa.Package) []ir.APISymbol {
+	api := make([]ir.APISymbol, 0)
+	qualifier := types.RelativeTo(pkg.Pkg)
+
+	scope := pkg.Pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+
+		sym := ir.APISymbol{
+			Name:    obj.Name(),
+			Type:    types.TypeString(obj.Type(), qualifier),
+			NimName: obj.Name() + "*",
+		}
+		switch obj := obj.(type) {
+		case *types.TypeName:
+			sym.Kind = "type"
+			sym.Type = types.TypeString(obj.Type().Underlying(), qualifier)
+			api = append(api, sym)
+			api = append(api, extractAPIMethods(obj, qualifier)...)
+			continue
+		case *types.Func:
+			sym.Kind = "func"
+		case *types.Const:
+			sym.Kind = "const"
+		case *types.Var:
+			sym.Kind = "var"
+		default:
+			continue
+		}
+		api = append(api, sym)
+	}
+	return api
+}
+
+func extractAPIMethods(tn *types.TypeName, qualifier types.Qualifier) []ir.APISymbol {
+	named, ok := tn.Type().(*types.Named)
+	if !ok || tn.IsAlias() {
+		return nil
+	}
+
+	methods := make([]ir.APISymbol, 0)
+	for i := 0; i < named.NumMethods(); i++ {
+		m := named.Method(i)
+		if !m.Exported() {
+			continue
+		}
+		methods = append(methods, ir.APISymbol{
+			Name:     m.Name(),
+			Kind:     "method",
+			Receiver: types.TypeString(m.Signature().Recv().Type(), qualifier),
+			Type:     types.TypeString(m.Signature(), qualifier),
+			NimName:  m.Name() + "*",
+		})
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
+
+var unsafeNimChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// nimModuleName maps a package to the path of its Nim module relative to
+// the output directory: the package path below the module path, or the last
+// element of the module path for the root package.
+func nimModuleName(pkgPath, modPath string) string {
+	rel := path.Base(pkgPath)
+	if modPath != "" && strings.HasPrefix(pkgPath, modPath+"/") {
+		rel = strings.TrimPrefix(pkgPath, modPath+"/")
+	}
+
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		part = unsafeNimChars.ReplaceAllString(part, "_")
+		if part == "" || (part[0] >= '0' && part[0] <= '9') {
+			part = "m" + part
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "/")
+}
+
+// newModuleManifest describes the initial packages, which are expected to
+// belong to one module; the module of the first one names the manifest.
+func newModuleManifest(initial []*packages.Package, pkgs []*ssa.Package) *ir.ModuleManifest {
+	m := &ir.ModuleManifest{Packages: make([]ir.ModulePackage, 0)}
+	for _, pkg := range initial {
+		if pkg.Module != nil {
+			m.Path = pkg.Module.Path
+			m.Version = pkg.Module.Version
+			m.GoVersion = pkg.Module.GoVersion
+			break
+		}
+	}
+
+	paths := make(map[string]bool)
+	for _, pkg := range pkgs {
+		paths[pkg.Pkg.Path()] = true
+	}
+
+	for _, pkg := range pkgs {
+		mp := ir.ModulePackage{
+			Path:      pkg.Pkg.Path(),
+			Name:      pkg.Pkg.Name(),
+			NimModule: nimModuleName(pkg.Pkg.Path(), m.Path),
+			Imports:   make([]string, 0),
+		}
+		for _, imp := range pkg.Pkg.Imports() {
+			if paths[imp.Path()] {
+				mp.Imports = append(mp.Imports, imp.Path())
+			}
+		}
+		sort.Strings(mp.Imports)
+		for _, name := range pkg.Pkg.Scope().Names() {
+			if pkg.Pkg.Scope().Lookup(name).Exported() {
+				mp.Exports++
+			}
+		}
+		m.Packages = append(m.Packages, mp)
+	}
+	return m
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// stringList is a flag.Value that accumulates every occurrence of a flag and
+// splits each occurrence on commas.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+type loadOptions struct {
+	patterns stringList
+	tags     stringList
+	goos     string
+	goarch   string
+	mod      string
+	verbose  bool
+	jobs     int
+	tests    bool
+
+	// dir is the directory the go command runs in; the working directory
+	// if empty.
+	dir string
+
+	// lazyBuild leaves building the SSA of each package to its user, so
+	// packages whose IR is cached are never built.
+	lazyBuild bool
+
+	stdlibManifest string
+	allowUnmapped  bool
+}
+
+func (o *loadOptions) register(fs *flag.FlagSet) {
+	fs.Var(&o.patterns, "input", "Input Go package pattern; may be repeated or comma separated (default \".\")")
+	fs.Var(&o.tags, "tags", "Build tags; may be repeated or comma separated")
+	fs.StringVar(&o.goos, "goos", "", "Target operating system (default $GOOS or the host)")
+	fs.StringVar(&o.goarch, "goarch", "", "Target architecture (default $GOARCH or the host)")
+	fs.StringVar(&o.mod, "mod", "", "Module download mode passed to the go command (readonly, vendor or mod)")
+	fs.BoolVar(&o.verbose, "v", false, "Verbose output")
+	fs.BoolVar(&o.tests, "test", false, "Test mode: also load _test.go files and synthesize a test main that runs their tests")
+	fs.IntVar(&o.jobs, "j", runtime.GOMAXPROCS(0), "Number of packages and functions processed in parallel; 1 builds serially")
+	fs.StringVar(&o.stdlibManifest, "stdlib-manifest", "", "Go to Nim stdlib mapping manifest (default: the built-in manifest)")
+	fs.BoolVar(&o.allowUnmapped, "allow-unmapped", false, "Report calls to unmapped stdlib symbols as warnings instead of errors")
+}
+
+// addPatterns appends positional package patterns to those given with -input.
+func (o *loadOptions) addPatterns(args []string) {
+	o.patterns = append(o.patterns, args...)
+}
+
+func (o *loadOptions) validate() error {
+	switch o.mod {
+	case "", "readonly", "vendor", "mod":
+	default:
+		return fmt.Errorf("invalid -mod value %q: must be readonly, vendor or mod", o.mod)
+	}
+	if o.jobs < 1 {
+		return fmt.Errorf("invalid -j value %d: must be at least 1", o.jobs)
+	}
+	return nil
+}
+
+func (o *loadOptions) buildInfo() ir.BuildInfo {
+	info := ir.BuildInfo{
+		Patterns: o.patterns,
+		Tags:     o.tags,
+		GOOS:     o.goos,
+		GOARCH:   o.goarch,
+		Mod:      o.mod,
+		Mode:     modeExecutable,
+	}
+	if o.tests {
+		info.Mode = modeTest
+	}
+	if len(info.Patterns) == 0 {
+		info.Patterns = []string{"."}
+	}
+	if info.Tags == nil {
+		info.Tags = make([]string, 0)
+	}
+	if info.GOOS == "" {
+		info.GOOS = build.Default.GOOS
+	}
+	if info.GOARCH == "" {
+		info.GOARCH = build.Default.GOARCH
+	}
+	return info
+}
+
+func (o *loadOptions) config() *packages.Config {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+			packages.NeedImports | packages.NeedDeps | packages.NeedTypes |
+			packages.NeedSyntax | packages.NeedTypesInfo | packages.NeedModule,
+		Dir: o.dir,
+	}
+	if o.tests {
+		cfg.Mode |= packages.NeedForTest
+		cfg.Tests = true
+	}
+
+	if o.goos != "" || o.goarch != "" {
+		cfg.Env = os.Environ()
+		if o.goos != "" {
+			cfg.Env = append(cfg.Env, "GOOS="+o.goos)
+		}
+		if o.goarch != "" {
+			cfg.Env = append(cfg.Env, "GOARCH="+o.goarch)
+		}
+	}
+
+	if len(o.tags) > 0 {
+		cfg.BuildFlags = append(cfg.BuildFlags, "-tags="+strings.Join(o.tags, ","))
+	}
+	if o.mod != "" {
+		cfg.BuildFlags = append(cfg.BuildFlags, "-mod="+o.mod)
+	}
+
+	return cfg
+}
+
+type program struct {
+	build  ir.BuildInfo
+	diags  *diagnostics
+	stdlib *stdlibResolver
+	jobs   int
+	// golden makes the IR independent of the checkout location.
+	golden bool
+	// tests is the test manifest in test mode.
+	tests   *ir.TestManifest
+	initial []*packages.Package
+	prog    *ssa.Program
+	pkgs    []*ssa.Package
+	// locals names the types declared inside the functions of initial.
+	locals localNames
+}
+
+// loadProgram loads and type-checks the packages selected by opts and builds
+// their SSA. Package errors and warnings about unsupported features are
+// recorded in diags; a non-nil error means no program could be built.
+func loadProgram(opts *loadOptions, diags *diagnostics) (*program, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	manifest, err := loadStdlibManifest(opts.stdlibManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	info := opts.buildInfo()
+	if opts.verbose {
+		log.Printf("Loading %s (GOOS=%s GOARCH=%s tags=%s)",
+			strings.Join(info.Patterns, " "), info.GOOS, info.GOARCH, strings.Join(info.Tags, ","))
+	}
+
+	initial, err := packages.Load(opts.config(), info.Patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages: %w", err)
+	}
+	if opts.tests {
+		initial = testVariants(initial)
+	}
+
+	diags.addPackageErrors(initial)
+	if n := diags.count(severityError); n > 0 {
+		return nil, fmt.Errorf("package loading failed with %d errors", n)
+	}
+
+	for _, pkg := range initial {
+		diags.scanUnsupported(pkg)
+	}
+
+	// Only the initial packages need function bodies; dependencies are
+	// created from their type information.
+	mode := ssa.SanityCheckFunctions
+	if opts.jobs == 1 {
+		mode |= ssa.BuildSerially
+	}
+	prog, pkgs := ssautil.Packages(initial, mode)
+	if !opts.lazyBuild {
+		prog.Build()
+	}
+
+	var tests *ir.TestManifest
+	if opts.tests {
+		tests = extractTests(initial, diags)
+	}
+
+	return &program{
+		build:   info,
+		diags:   diags,
+		stdlib:  newStdlibResolver(manifest, diags, standardPackages(initial), opts.allowUnmapped),
+		jobs:    opts.jobs,
+		tests:   tests,
+		initial: initial,
+		prog:    prog,
+		pkgs:    pkgs,
+		locals:  newLocalNames(initial),
+	}, nil
+}
+
+// testVariants replaces every package that has _test.go files with its test
+// variant and drops the test mains generated by the go command; the frontend
+// synthesizes its own.
+func testVariants(initial []*packages.Package) []*packages.Package {
+	tested := make(map[string]bool)
+	for _, pkg := range initial {
+		if pkg.ForTest != "" && pkg.PkgPath == pkg.ForTest {
+			tested[pkg.PkgPath] = true
+		}
+	}
+
+	pkgs := make([]*packages.Package, 0, len(initial))
+	for _, pkg := range initial {
+		if pkg.Name == "main" && pkg.ID == pkg.PkgPath && strings.HasSuffix(pkg.ID, ".test") {
+			continue
+		}
+		if pkg.ForTest == "" && tested[pkg.PkgPath] {
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
+// initialFunctions returns every function with a body that belongs to one of
+// the initially loaded packages, including methods and anonymous functions.
+func (p *program) initialFunctions() []*ssa.Function {
+	initial := make(map[*ssa.Package]bool)
+	for _, pkg := range p.pkgs {
+		if pkg != nil {
+			initial[pkg] = true
+		}
+	}
+
+	fns := make([]*ssa.Function, 0)
+	for fn := range ssautil.AllFunctions(p.prog) {
+		if fn.Blocks != nil && initial[fn.Pkg] {
+			fns = append(fns, fn)
+		}
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		return fns[i].String() < fns[j].String()
+	})
+	return fns
+}
+
+// standardPackages returns the import paths of the standard library packages
+// among the dependencies of initial. Standard packages are the ones outside
+// any module; initial packages given as files also have no module, so they
+// are excluded explicitly.
+func standardPackages(initial []*packages.Package) map[string]bool {
+	roots := make(map[string]bool)
+	for _, pkg := range initial {
+		roots[pkg.ID] = true
+	}
+
+	std := make(map[string]bool)
+	packages.Visit(initial, nil, func(pkg *packages.Package) {
+		if pkg.Module == nil && !roots[pkg.ID] {
+			std[pkg.PkgPath] = true
+		}
+	})
+	return std
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// localType is a named type declared inside a function body, which types
+// prints like a package-level type although several functions may declare
+// one of the same name.
+type localType struct {
+	obj  *types.TypeName
+	name string
+}
+
+// localNames maps the local types of a program to stand-ins of the same
+// underlying type named after their mangled names. A nil localNames renames
+// nothing.
+type localNames map[*types.TypeName]types.Type
+
+// localTypes returns the types declared inside the functions of pkg in
+// source order, named <function>$<type>. Methods are named <recv>.<method>,
+// init functions init#1, init#2... as SSA names them, and the function
+// literals of package variables belong to init. A later type of the same
+// name in one function gets $2, $3... appended.
+func localTypes(pkg *packages.Package) []localType {
+	locals := make([]localType, 0)
+	if pkg == nil || pkg.TypesInfo == nil {
+		return locals
+	}
+
+	scope := pkg.Types.Scope()
+	seen := make(map[string]int)
+	inits := 0
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			fn := "init"
+			if decl, ok := decl.(*ast.FuncDecl); ok {
+				switch {
+				case decl.Recv != nil && len(decl.Recv.List) > 0:
+					fn = receiverBaseName(pkg.TypesInfo.TypeOf(decl.Recv.List[0].Type)) + "." + decl.Name.Name
+				case decl.Name.Name == "init":
+					inits++
+					fn = fmt.Sprintf("init#%d", inits)
+				default:
+					fn = decl.Name.Name
+				}
+			}
+			ast.Inspect(decl, func(n ast.Node) bool {
+				spec, ok := n.(*ast.TypeSpec)
+				if !ok {
+					return true
+				}
+				obj, ok := pkg.TypesInfo.Defs[spec.Name].(*types.TypeName)
+				if !ok || obj.Parent() == scope {
+					return true
+				}
+				name := fn + "$" + obj.Name()
+				seen[name]++
+				if n := seen[name]; n > 1 {
+					name = fmt.Sprintf("%s$%d", name, n)
+				}
+				locals = append(locals, localType{obj: obj, name: name})
+				return true
+			})
+		}
+	}
+	return locals
+}
+
+// newLocalNames records the mangled names of the local types of pkgs.
+func newLocalNames(pkgs []*packages.Package) localNames {
+	names := make(localNames)
+	for _, pkg := range pkgs {
+		for _, lt := range localTypes(pkg) {
+			obj := types.NewTypeName(lt.obj.Pos(), lt.obj.Pkg(), lt.name, nil)
+			names[lt.obj] = types.NewNamed(obj, lt.obj.Type().Underlying(), nil)
+		}
+	}
+	return names
+}
+
+// typeString prints t as the IR spells types, with local types under their
+// mangled names.
+func (names localNames) typeString(t types.Type) string {
+	return types.TypeString(names.rename(t), nil)
+}
+
+// rename returns t with the local types it refers to replaced by their
+// stand-ins, or t itself if it refers to none. Generic signatures are left
+// alone, as their type parameters cannot be rebound.
+func (names localNames) rename(t types.Type) types.Type {
+	switch t := t.(type) {
+	case *types.Alias:
+		if named, ok := names[t.Obj()]; ok {
+			return named
+		}
+	case *types.Named:
+		if named, ok := names[t.Obj()]; ok {
+			return named
+		}
+		args := t.TypeArgs()
+		if args.Len() == 0 {
+			return t
+		}
+		renamed, changed := make([]types.Type, args.Len()), false
+		for i := range renamed {
+			renamed[i] = names.rename(args.At(i))
+			changed = changed || renamed[i] != args.At(i)
+		}
+		if changed {
+			if inst, err := types.Instantiate(nil, t.Origin(), renamed, false); err == nil {
+				return inst
+			}
+		}
+	case *types.Pointer:
+		if elem := names.rename(t.Elem()); elem != t.Elem() {
+			return types.NewPointer(elem)
+		}
+	case *types.Slice:
+		if elem := names.rename(t.Elem()); elem != t.Elem() {
+			return types.NewSlice(elem)
+		}
+	case *types.Array:
+		if elem := names.rename(t.Elem()); elem != t.Elem() {
+			return types.NewArray(elem, t.Len())
+		}
+	case *types.Chan:
+		if elem := names.rename(t.Elem()); elem != t.Elem() {
+			return types.NewChan(t.Dir(), elem)
+		}
+	case *types.Map:
+		key, elem := names.rename(t.Key()), names.rename(t.Elem())
+		if key != t.Key() || elem != t.Elem() {
+			return types.NewMap(key, elem)
+		}
+	case *types.Tuple:
+		if vars, changed := names.renameVars(t); changed {
+			return types.NewTuple(vars...)
+		}
+	case *types.Signature:
+		if t.TypeParams().Len() > 0 || t.RecvTypeParams().Len() > 0 {
+			return t
+		}
+		params, pchanged := names.renameVars(t.Params())
+		results, rchanged := names.renameVars(t.Results())
+		if pchanged || rchanged {
+			return types.NewSignatureType(t.Recv(), nil, nil, types.NewTuple(params...), types.NewTuple(results...), t.Variadic())
+		}
+	case *types.Struct:
+		fields, tags, changed := make([]*types.Var, t.NumFields()), make([]string, t.NumFields()), false
+		for i := range fields {
+			f := t.Field(i)
+			fields[i], tags[i] = f, t.Tag(i)
+			if ft := names.rename(f.Type()); ft != f.Type() {
+				fields[i], changed = types.NewField(f.Pos(), f.Pkg(), f.Name(), ft, f.Embedded()), true
+			}
+		}
+		if changed {
+			return types.NewStruct(fields, tags)
+		}
+	case *types.Interface:
+		methods, changed := make([]*types.Func, t.NumExplicitMethods()), false
+		for i := range methods {
+			m := t.ExplicitMethod(i)
+			methods[i] = m
+			if sig := names.rename(m.Type()); sig != m.Type() {
+				methods[i], changed = types.NewFunc(m.Pos(), m.Pkg(), m.Name(), sig.(*types.Signature)), true
+			}
+		}
+		embeddeds := make([]types.Type, t.NumEmbeddeds())
+		for i := range embeddeds {
+			embeddeds[i] = names.rename(t.EmbeddedType(i))
+			changed = changed || embeddeds[i] != t.EmbeddedType(i)
+		}
+		if changed {
+			return types.NewInterfaceType(methods, embeddeds).Complete()
+		}
+	}
+	return t
+}
+
+// renameVars renames the local types of the variables of tuple, reporting
+// whether any changed.
+func (names localNames) renameVars(tuple *types.Tuple) ([]*types.Var, bool) {
+	vars, changed := make([]*types.Var, tuple.Len()), false
+	for i := range vars {
+		v := tuple.At(i)
+		vars[i] = v
+		if vt := names.rename(v.Type()); vt != v.Type() {
+			vars[i], changed = types.NewParam(v.Pos(), v.Pkg(), v.Name(), vt), true
+		}
+	}
+	return vars, changed
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalTypes(t *testing.T) {
+	prog := loadIR(t, "", "./testdata/localtypes")
+	pkg := prog.Package(prog.MainPkg)
+	kinds := make(map[string]string)
+	for _, td := range pkg.Types {
+		kinds[td.Name] = td.Kind
+	}
+	want := map[string]string{
+		"T":              "struct",
+		"T.Sum$pair":     "struct",
+		"first$pair":     "struct",
+		"second$pair":    "struct",
+		"second$pair$2":  "alias",
+		"init#1$counter": "alias",
+	}
+	for name, kind := range want {
+		if kinds[name] != kind {
+			t.Errorf("type %s has kind %q, want %q", name, kinds[name], kind)
+		}
+	}
+	if len(kinds) != len(want) {
+		t.Errorf("got types %v, want %v", kinds, want)
+	}
+
+	// Values refer to the local types of their function by mangled name,
+	// possibly inside a composite type.
+	tests := []struct {
+		fn   string
+		want string
+	}{
+		{"first", pkg.Path + ".first$pair"},
+		{"second", pkg.Path + ".second$pair$2"},
+		{"(*T).Sum", pkg.Path + ".T.Sum$pair"},
+	}
+	for _, tt := range tests {
+		fn := prog.Function(pkg.Path + "." + tt.fn)
+		if fn == nil || fn.Body == nil {
+			t.Errorf("function %s is not in the IR", tt.fn)
+			continue
+		}
+		found := false
+		for _, v := range fn.Body.Values {
+			if strings.Contains(v.Type, pkg.Path+".pair") {
+				t.Errorf("%s: value %s has type %s", tt.fn, v.Name, v.Type)
+			}
+			found = found || strings.Contains(v.Type, tt.want)
+		}
+		if !found {
+			t.Errorf("%s: no value of type %s", tt.fn, tt.want)
+		}
+	}
+}
+
+func TestExplainLocalTypes(t *testing.T) {
+	// explain converts single functions with the names of its program.
+	opts := loadOptions{jobs: 1}
+	opts.addPatterns([]string{"./testdata/localtypes"})
+	p, err := loadProgram(&opts, newDiagnostics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := findFunctions(p.initialFunctions(), "first")
+	if len(matches) != 1 {
+		t.Fatalf("found %d functions first", len(matches))
+	}
+	fn := matches[0]
+	fnIR, _ := processFunction(fn, fn.Pkg, findGoPackage(fn.Pkg, p.initial), p.stdlib, p.locals)
+	want := fn.Pkg.Pkg.Path() + ".first$pair"
+	for _, v := range fnIR.Body.Values {
+		if v.Type == want {
+			return
+		}
+	}
+	t.Errorf("first has no value of type %s", want)
+}
@@ -1,215 +1,205 @@
 package main
 
 import (
-	"encoding/json"
-	"flag"
 	"fmt"
 	"go/ast"
+	"go/token"
 	"go/types"
 	"log"
 	"os"
+	"sort"
 	"strings"
 
+	"github.com/gonim/compiler/ir"
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/ssa"
-	"golang.org/x/tools/go/ssa/ssautil"
 )
 
-type HybridIR struct {
-	Packages []PackageIR `json:"packages"`
-	MainPkg  string      `json:"main_package"`
-}
-
-type PackageIR struct {
-	Path       string       `json:"path"`
-	Name       string       `json:"name"`
-	Types      []TypeDef    `json:"types"`
-	Functions  []FunctionIR `json:"functions"`
-	Globals    []GlobalVar  `json:"globals"`
-	Constants  []ConstDef   `json:"constants"`
-	Imports    []string     `json:"imports"`
-	CGOImports []CGOImport  `json:"cgo_imports"`
-}
-
-type CGOImport struct {
-	CFlags  []string `json:"cflags"`
-	LDFlags []string `json:"ldflags"`
-	Headers []string `json:"headers"`
-	PkgPath string   `json:"pkg_path"`
-}
-
-type TypeDef struct {
-	Name       string         `json:"name"`
-	Kind       string         `json:"kind"`
-	Fields     []FieldDef     `json:"fields,omitempty"`
-	Methods    []string       `json:"methods,omitempty"`
-	Underlying string         `json:"underlying,omitempty"`
-	Signature  *FuncSignature `json:"signature,omitempty"`
-}
-
-type FieldDef struct {
-	Name string `json:"name"`
-	Type string `json:"typ"`
-	Tag  string `json:"tag,omitempty"`
-}
-
-type FunctionIR struct {
-	Name      string         `json:"name"`
-	Receiver  *ReceiverInfo  `json:"receiver,omitempty"`
-	Signature FuncSignature  `json:"signature"`
-	Body      *BodyIR        `json:"body,omitempty"`
-	IsMethod  bool           `json:"is_method"`
-	Package   string         `json:"package"`
-}
-
-type ReceiverInfo struct {
-	Name    string `json:"name"`
-	Type    string `json:"type"`
-	Pointer bool   `json:"pointer"`
-}
-
-type FuncSignature struct {
-	Params   []Param `json:"params"`
-	Results  []Param `json:"results"`
-	Variadic bool    `json:"variadic"`
-}
-
-type Param struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
-}
-
-type BodyIR struct {
-	Blocks      []BlockIR         `json:"blocks"`
-	Locals      []LocalVar        `json:"locals"`
-	FreeVars    []string          `json:"free_vars"`
-	StructHints map[string]HintIR `json:"struct_hints"`
-	Defers      []DeferInfo       `json:"defers"`
-}
-
-type HintIR struct {
-	Kind   string   `json:"kind"`
-	Lines  []int    `json:"lines"`
-	Labels []string `json:"labels,omitempty"`
-}
-
-type DeferInfo struct {
-	BlockID int    `json:"block_id"`
-	Call    string `json:"call"`
-}
+func main() {
+	args := os.Args[1:]
+	name := "ir"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-type BlockIR struct {
-	ID           int           `json:"id"`
-	Instructions []Instruction `json:"instructions"`
-	Successors   []int         `json:"successors"`
-	Comment      string        `json:"comment,omitempty"`
-}
+	cmd := lookupCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "gonim-compile: unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(exitUsage)
+	}
 
-type Instruction struct {
-	Op       string   `json:"op"`
-	Args     []string `json:"args,omitempty"`
-	Type     string   `json:"type,omitempty"`
-	Result   string   `json:"result,omitempty"`
-	Comment  string   `json:"comment,omitempty"`
-	Position int      `json:"position,omitempty"`
+	os.Exit(cmd.run(args))
 }
 
-type LocalVar struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+func buildIR(p *program, verbose bool) ir.HybridIR {
+	w := &memoryWriter{}
+	// memoryWriter never fails.
+	_ = emitIR(p, w, nil, verbose)
+	return w.ir
 }
 
-type GlobalVar struct {
-	Name  string `json:"name"`
-	Type  string `json:"type"`
-	Value string `json:"value,omitempty"`
+type packageResult struct {
+	pkgIR    ir.PackageIR
+	unmapped []UnmappedUse
 }
 
-type ConstDef struct {
-	Name  string `json:"name"`
-	Type  string `json:"type"`
-	Value string `json:"value"`
-}
+// emitIR generates the IR of every initial package and hands it to w one
+// package and one function at a time, in package path order. Up to p.jobs
+// packages are generated concurrently, their functions by a shared pool of
+// p.jobs workers; packages found in cache are taken from it, the others are
+// stored there. In test mode the synthesized test main package comes last.
+func emitIR(p *program, w irWriter, cache *irCache, verbose bool) error {
+	pkgs := p.sortedPackages()
+	var module *ir.ModuleManifest
+	if p.build.Mode == modeLibrary {
+		module = newModuleManifest(p.initial, pkgs)
+	}
+	tests := p.tests
+	if tests != nil && p.golden {
+		tests = relativeTests(tests)
+	}
+	if err := w.begin(p.build, module, tests); err != nil {
+		return err
+	}
 
-var (
-	inputPath  = flag.String("input", ".", "Input Go package path")
-	outputPath = flag.String("output", "output.json", "Output JSON file")
-	verbose    = flag.Bool("v", false, "Verbose output")
-)
+	mainPkg := ""
+	for _, pkg := range pkgs {
+		if pkg.Func("main") != nil {
+			mainPkg = pkg.Pkg.Path()
+		}
+	}
 
-func main() {
-	flag.Parse()
+	pool := newWorkerPool(p.jobs)
+	defer pool.close()
 
-	cfg := &packages.Config{
-		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
-			packages.NeedImports | packages.NeedDeps | packages.NeedTypes |
-			packages.NeedSyntax | packages.NeedTypesInfo,
+	results := make([]chan packageResult, len(pkgs))
+	for i := range results {
+		results[i] = make(chan packageResult, 1)
 	}
+	// sem bounds the packages generated but not yet written.
+	sem := make(chan struct{}, p.jobs)
+	go func() {
+		for i, pkg := range pkgs {
+			sem <- struct{}{}
+			go func() {
+				results[i] <- generatePackage(p, pkg, cache, pool, verbose)
+			}()
+		}
+	}()
 
-	initial, err := packages.Load(cfg, *inputPath)
+	var err error
+	for i := range pkgs {
+		result := <-results[i]
+		<-sem
+		if err != nil {
+			continue
+		}
+		p.stdlib.report(result.unmapped)
+		err = writePackage(w, result.pkgIR)
+	}
 	if err != nil {
-		log.Fatalf("Failed to load packages: %v", err)
+		return err
 	}
 
-	if packages.PrintErrors(initial) > 0 {
-		log.Fatal("Package loading errors occurred")
+	if tests != nil {
+		if err := writePackage(w, testMainIR(tests, p.stdlib.manifest.module("testing"))); err != nil {
+			return err
+		}
+		mainPkg = testMainPackage
 	}
 
-	prog, pkgs := ssautil.AllPackages(initial, ssa.SanityCheckFunctions|ssa.BuildSerially)
-	prog.Build()
-
-	ir := HybridIR{
-		Packages: make([]PackageIR, 0),
+	diags := p.diags.sorted()
+	if p.golden {
+		diags = relativeDiagnostics(diags)
 	}
+	return w.end(mainPkg, diags)
+}
 
-	processedPkgs := make(map[string]bool)
-
-	for _, pkg := range pkgs {
-		if pkg == nil || processedPkgs[pkg.Pkg.Path()] {
+// sortedPackages returns the initial SSA packages ordered by path.
+func (p *program) sortedPackages() []*ssa.Package {
+	pkgs := make([]*ssa.Package, 0, len(p.pkgs))
+	seen := make(map[string]bool)
+	for _, pkg := range p.pkgs {
+		if pkg == nil || seen[pkg.Pkg.Path()] {
 			continue
 		}
-		processedPkgs[pkg.Pkg.Path()] = true
+		seen[pkg.Pkg.Path()] = true
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].Pkg.Path() < pkgs[j].Pkg.Path()
+	})
+	return pkgs
+}
 
-		if *verbose {
-			log.Printf("Processing package: %s", pkg.Pkg.Path())
+func generatePackage(p *program, pkg *ssa.Package, cache *irCache, pool *workerPool, verbose bool) packageResult {
+	goPackage := findGoPackage(pkg, p.initial)
+	if entry, ok := cache.lookup(goPackage); ok {
+		if verbose {
+			log.Printf("Reusing cached package: %s", pkg.Pkg.Path())
 		}
+		return packageResult{pkgIR: entry.Package, unmapped: entry.Unmapped}
+	}
 
-		pkgIR := processPackage(pkg, initial)
-		ir.Packages = append(ir.Packages, pkgIR)
-
-		if pkg.Func("main") != nil {
-			ir.MainPkg = pkg.Pkg.Path()
-		}
+	if verbose {
+		log.Printf("Processing package: %s", pkg.Pkg.Path())
 	}
 
-	data, err := json.MarshalIndent(ir, "", "  ")
-	if err != nil {
-		log.Fatalf("Failed to marshal IR: %v", err)
+	pkg.Build()
+	pkgIR := newPackageIR(p.locals, pkg, goPackage)
+	if p.build.Mode == modeLibrary {
+		pkgIR.API = extractAPI(pkg)
 	}
+	fns := withAnonFuncs(append(packageFunctions(pkg), packageMethods(pkg)...))
+	fns = append(fns, packageWrappers(pkg)...)
+	pkgIR.Functions = make([]ir.FunctionIR, len(fns))
+	unmapped := make([][]UnmappedUse, len(fns))
+	pool.run(len(fns), func(i int) {
+		pkgIR.Functions[i], unmapped[i] = processFunction(fns[i], pkg, goPackage, p.stdlib, p.locals)
+	})
 
-	if err := os.WriteFile(*outputPath, data, 0644); err != nil {
-		log.Fatalf("Failed to write output: %v", err)
+	result := packageResult{pkgIR: pkgIR, unmapped: firstUses(unmapped...)}
+	if err := cache.store(goPackage, result.pkgIR, result.unmapped); err != nil {
+		log.Printf("Failed to cache package %s: %v", pkg.Pkg.Path(), err)
 	}
+	return result
+}
 
-	log.Printf("Successfully generated IR: %s", *outputPath)
+// writePackage hands a complete package to w.
+func writePackage(w irWriter, pkgIR ir.PackageIR) error {
+	fns := pkgIR.Functions
+	pkgIR.Functions = make([]ir.FunctionIR, 0)
+	if err := w.beginPackage(pkgIR); err != nil {
+		return err
+	}
+	for _, fn := range fns {
+		if err := w.function(fn); err != nil {
+			return err
+		}
+	}
+	return w.endPackage()
 }
 
-func processPackage(pkg *ssa.Package, initial []*packages.Package) PackageIR {
-	var goPackage *packages.Package
+func findGoPackage(pkg *ssa.Package, initial []*packages.Package) *packages.Package {
 	for _, p := range initial {
 		if p.PkgPath == pkg.Pkg.Path() {
-			goPackage = p
-			break
+			return p
 		}
 	}
+	return nil
+}
 
-	pkgIR := PackageIR{
+// newPackageIR describes pkg without its functions.
+func newPackageIR(names localNames, pkg *ssa.Package, goPackage *packages.Package) ir.PackageIR {
+	docs := collectDocs(goPackage)
+	pkgIR := ir.PackageIR{
 		Path:      pkg.Pkg.Path(),
 		Name:      pkg.Pkg.Name(),
-		Types:     extractTypes(pkg),
-		Functions: make([]FunctionIR, 0),
-		Globals:   extractGlobals(pkg),
-		Constants: extractConstants(pkg),
+		Types:     extractTypes(names, pkg, goPackage, docs),
+		Functions: make([]ir.FunctionIR, 0),
+		Globals:   extractGlobals(names, pkg, docs),
+		Constants: extractConstants(names, pkg, docs),
 		Imports:   extractImports(pkg),
 	}
 
@@ -217,21 +207,72 @@ func processPackage(pkg *ssa.Package, initial []*packages.Package) PackageIR {
 		pkgIR.CGOImports = extractCGOImports(goPackage)
 	}
 
+	return pkgIR
+}
+
+// packageFunctions returns the package-level functions of pkg that have a
+// body, sorted by name.
+func packageFunctions(pkg *ssa.Package) []*ssa.Function {
+	fns := make([]*ssa.Function, 0)
 	for _, mem := range pkg.Members {
 		switch m := mem.(type) {
 		case *ssa.Function:
 			if m.Blocks != nil {
-				fnIR := processFunction(m, goPackage)
-				pkgIR.Functions = append(pkgIR.Functions, fnIR)
+				fns = append(fns, m)
 			}
 		}
 	}
+	sort.Slice(fns, func(i, j int) bool {
+		return fns[i].Name() < fns[j].Name()
+	})
+	return fns
+}
 
-	return pkgIR
+// packageMethods returns the methods with a body declared on the named
+// types of pkg, sorted by type and name.
+func packageMethods(pkg *ssa.Package) []*ssa.Function {
+	fns := make([]*ssa.Function, 0)
+	for _, name := range pkg.Pkg.Scope().Names() {
+		tn, ok := pkg.Pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok || tn.IsAlias() {
+			continue
+		}
+		named, ok := tn.Type().(*types.Named)
+		if !ok {
+			continue
+		}
+
+		methods := make([]*ssa.Function, 0)
+		for i := 0; i < named.NumMethods(); i++ {
+			if fn := pkg.Prog.FuncValue(named.Method(i)); fn != nil && fn.Blocks != nil {
+				methods = append(methods, fn)
+			}
+		}
+		sort.Slice(methods, func(i, j int) bool { return methods[i].Name() < methods[j].Name() })
+		fns = append(fns, methods...)
+	}
+	return fns
+}
+
+// withAnonFuncs returns fns, each followed by its anonymous functions and
+// theirs, depth first.
+func withAnonFuncs(fns []*ssa.Function) []*ssa.Function {
+	out := make([]*ssa.Function, 0, len(fns))
+	var add func(fn *ssa.Function)
+	add = func(fn *ssa.Function) {
+		out = append(out, fn)
+		for _, anon := range fn.AnonFuncs {
+			add(anon)
+		}
+	}
+	for _, fn := range fns {
+		add(fn)
+	}
+	return out
 }
 
-func extractCGOImports(pkg *packages.Package) []CGOImport {
-	cgoImports := make([]CGOImport, 0)
+func extractCGOImports(pkg *packages.Package) []ir.CGOImport {
+	cgoImports := make([]ir.CGOImport, 0)
 
 	for _, file := range pkg.Syntax {
 		for _, cg := range file.Comments {
@@ -250,7 +291,7 @@ func extractCGOImports(pkg *packages.Package) []CGOImport {
 	return cgoImports
 }
 
-func parseCGODirective(directive string, pkgPath string) *CGOImport {
+func parseCGODirective(directive string, pkgPath string) *ir.CGOImport {
 	directive = strings.TrimPrefix(directive, "//")
 	directive = strings.TrimPrefix(directive, "// ")
 	directive = strings.TrimSpace(directive)
@@ -259,7 +300,7 @@ func parseCGODirective(directive string, pkgPath string) *CGOImport {
 		return nil
 	}
 
-	cgo := &CGOImport{
+	cgo := &ir.CGOImport{
 		PkgPath: pkgPath,
 		CFlags:  make([]string, 0),
 		LDFlags: make([]string, 0),
@@ -284,8 +325,10 @@ func parseCGODirective(directive string, pkgPath string) *CGOImport {
 	return cgo
 }
 
-func extractTypes(pkg *ssa.Package) []TypeDef {
-	typeDefs := make([]TypeDef, 0)
+// extractTypes describes the package-level types of pkg by name, followed
+// by the local types declared in its functions.
+func extractTypes(names localNames, pkg *ssa.Package, goPackage *packages.Package, docs declDocs) []ir.TypeDef {
+	typeDefs := make([]ir.TypeDef, 0)
 	seen := make(map[string]bool)
 
 	scope := pkg.Pkg.Scope()
@@ -301,48 +344,59 @@ func extractTypes(pkg *ssa.Package) []TypeDef {
 		}
 		seen[tn.Name()] = true
 
-		typeDef := TypeDef{
-			Name:    tn.Name(),
-			Methods: make([]string, 0),
-		}
+		typeDefs = append(typeDefs, extractTypeDef(names, tn, tn.Name(), docs))
+	}
 
-		underlying := tn.Type().Underlying()
-
-		switch t := underlying.(type) {
-		case *types.Struct:
-			typeDef.Kind = "struct"
-			typeDef.Fields = extractStructFields(t)
-		case *types.Interface:
-			typeDef.Kind = "interface"
-			typeDef.Fields = extractInterfaceMethods(t)
-		case *types.Signature:
-			typeDef.Kind = "func"
-			sig := extractSignature(t)
-			typeDef.Signature = &sig
-		default:
-			typeDef.Kind = "alias"
-			typeDef.Underlying = types.TypeString(underlying, nil)
-		}
+	for _, lt := range localTypes(goPackage) {
+		typeDefs = append(typeDefs, extractTypeDef(names, lt.obj, lt.name, docs))
+	}
 
-		mset := types.NewMethodSet(types.NewPointer(tn.Type()))
-		for i := 0; i < mset.Len(); i++ {
-			m := mset.At(i)
-			typeDef.Methods = append(typeDef.Methods, m.Obj().Name())
-		}
+	return typeDefs
+}
 
-		typeDefs = append(typeDefs, typeDef)
+func extractTypeDef(names localNames, tn *types.TypeName, name string, docs declDocs) ir.TypeDef {
+	typeDef := ir.TypeDef{
+		Name:       name,
+		Methods:    make([]string, 0),
+		Exported:   tn.Exported() && tn.Parent() == tn.Pkg().Scope(),
+		Doc:        docs[tn],
+		Deprecated: deprecation(docs[tn]),
 	}
 
-	return typeDefs
+	underlying := tn.Type().Underlying()
+
+	switch t := underlying.(type) {
+	case *types.Struct:
+		typeDef.Kind = "struct"
+		typeDef.Fields = extractStructFields(names, t)
+	case *types.Interface:
+		typeDef.Kind = "interface"
+		typeDef.Fields = extractInterfaceMethods(names, t)
+	case *types.Signature:
+		typeDef.Kind = "func"
+		sig := extractSignature(names, t)
+		typeDef.Signature = &sig
+	default:
+		typeDef.Kind = "alias"
+		typeDef.Underlying = names.typeString(underlying)
+	}
+
+	mset := types.NewMethodSet(types.NewPointer(tn.Type()))
+	for i := 0; i < mset.Len(); i++ {
+		m := mset.At(i)
+		typeDef.Methods = append(typeDef.Methods, m.Obj().Name())
+	}
+
+	return typeDef
 }
 
-func extractStructFields(s *types.Struct) []FieldDef {
-	fields := make([]FieldDef, 0)
+func extractStructFields(names localNames, s *types.Struct) []ir.FieldDef {
+	fields := make([]ir.FieldDef, 0)
 	for i := 0; i < s.NumFields(); i++ {
 		f := s.Field(i)
-		field := FieldDef{
+		field := ir.FieldDef{
 			Name: f.Name(),
-			Type: types.TypeString(f.Type(), nil),
+			Type: names.typeString(f.Type()),
 		}
 		if s.Tag(i) != "" {
 			field.Tag = s.Tag(i)
@@ -352,71 +406,82 @@ func extractStructFields(s *types.Struct) []FieldDef {
 	return fields
 }
 
-func extractInterfaceMethods(iface *types.Interface) []FieldDef {
-	methods := make([]FieldDef, 0)
+func extractInterfaceMethods(names localNames, iface *types.Interface) []ir.FieldDef {
+	methods := make([]ir.FieldDef, 0)
 	for i := 0; i < iface.NumMethods(); i++ {
 		m := iface.Method(i)
-		method := FieldDef{
+		method := ir.FieldDef{
 			Name: m.Name(),
-			Type: types.TypeString(m.Type(), nil),
+			Type: names.typeString(m.Type()),
 		}
 		methods = append(methods, method)
 	}
 	return methods
 }
 
-func extractSignature(sig *types.Signature) FuncSignature {
-	fs := FuncSignature{
-		Params:   make([]Param, 0),
-		Results:  make([]Param, 0),
+func extractSignature(names localNames, sig *types.Signature) ir.FuncSignature {
+	fs := ir.FuncSignature{
+		Params:   make([]ir.Param, 0),
+		Results:  make([]ir.Param, 0),
 		Variadic: sig.Variadic(),
 	}
 
 	params := sig.Params()
 	for i := 0; i < params.Len(); i++ {
 		p := params.At(i)
-		fs.Params = append(fs.Params, Param{
+		fs.Params = append(fs.Params, ir.Param{
 			Name: p.Name(),
-			Type: types.TypeString(p.Type(), nil),
+			Type: names.typeString(p.Type()),
 		})
 	}
 
 	results := sig.Results()
 	for i := 0; i < results.Len(); i++ {
 		r := results.At(i)
-		fs.Results = append(fs.Results, Param{
+		fs.Results = append(fs.Results, ir.Param{
 			Name: r.Name(),
-			Type: types.TypeString(r.Type(), nil),
+			Type: names.typeString(r.Type()),
 		})
 	}
 
 	return fs
 }
 
-func extractGlobals(pkg *ssa.Package) []GlobalVar {
-	globals := make([]GlobalVar, 0)
+func extractGlobals(names localNames, pkg *ssa.Package, docs declDocs) []ir.GlobalVar {
+	globals := make([]ir.GlobalVar, 0)
 	for _, mem := range pkg.Members {
 		if g, ok := mem.(*ssa.Global); ok {
-			global := GlobalVar{
+			global := ir.GlobalVar{
 				Name: g.Name(),
-				Type: types.TypeString(g.Type(), nil),
+				Type: names.typeString(g.Type()),
+			}
+			if obj := g.Object(); obj != nil {
+				global.Exported = obj.Exported()
+				global.Doc = docs[obj]
+				global.Deprecated = deprecation(global.Doc)
 			}
 			globals = append(globals, global)
 		}
 	}
+	sort.Slice(globals, func(i, j int) bool {
+		return globals[i].Name < globals[j].Name
+	})
 	return globals
 }
 
-func extractConstants(pkg *ssa.Package) []ConstDef {
-	constants := make([]ConstDef, 0)
+func extractConstants(names localNames, pkg *ssa.Package, docs declDocs) []ir.ConstDef {
+	constants := make([]ir.ConstDef, 0)
 	scope := pkg.Pkg.Scope()
 	for _, name := range scope.Names() {
 		obj := scope.Lookup(name)
 		if c, ok := obj.(*types.Const); ok {
-			constant := ConstDef{
-				Name:  c.Name(),
-				Type:  types.TypeString(c.Type(), nil),
-				Value: c.Val().String(),
+			constant := ir.ConstDef{
+				Name:       c.Name(),
+				Type:       names.typeString(c.Type()),
+				Value:      c.Val().String(),
+				Exported:   c.Exported(),
+				Doc:        docs[c],
+				Deprecated: deprecation(docs[c]),
 			}
 			constants = append(constants, constant)
 		}
@@ -429,14 +494,34 @@ func extractImports(pkg *ssa.Package) []string {
 	for _, imp := range pkg.Pkg.Imports() {
 		imports = append(imports, imp.Path())
 	}
+	sort.Strings(imports)
 	return imports
 }
 
-func processFunction(fn *ssa.Function, goPackage *packages.Package) FunctionIR {
-	fnIR := FunctionIR{
+// processFunction converts fn and returns the uses of unmapped stdlib symbols
+// in it. It only reads the program, so functions can be processed
+// concurrently.
+func processFunction(fn *ssa.Function, pkg *ssa.Package, goPackage *packages.Package, stdlib *stdlibResolver, names localNames) (ir.FunctionIR, []UnmappedUse) {
+	var unmapped []UnmappedUse
+	fnIR := ir.FunctionIR{
 		Name:     fn.Name(),
-		Package:  fn.Pkg.Pkg.Path(),
+		Package:  pkg.Pkg.Path(),
 		IsMethod: fn.Signature.Recv() != nil,
+		Exported: fn.Object() != nil && fn.Object().Exported(),
+	}
+	if isWrapper(fn) {
+		fnIR.Name, fnIR.Exported = fn.RelString(pkg.Pkg), false
+		fnIR.Wrapper = describeWrapper(names, fn)
+	}
+	// Anonymous functions of methods are named after the method with its
+	// receiver, such as (*Pool).run$1, so they differ from those of a
+	// function run.
+	if fn.Parent() != nil {
+		fnIR.Name = anonName(fn, pkg.Pkg)
+	}
+	if decl, ok := fn.Syntax().(*ast.FuncDecl); ok {
+		fnIR.Doc = decl.Doc.Text()
+		fnIR.Deprecated = deprecation(fnIR.Doc)
 	}
 
 	if fn.Signature.Recv() != nil {
@@ -447,72 +532,80 @@ func processFunction(fn *ssa.Function, goPackage *packages.Package) FunctionIR {
 			recvType = ptr.Elem()
 			pointer = true
 		}
-		fnIR.Receiver = &ReceiverInfo{
+		fnIR.Receiver = &ir.ReceiverInfo{
 			Name:    recv.Name(),
-			Type:    types.TypeString(recvType, nil),
+			Type:    names.typeString(recvType),
 			Pointer: pointer,
 		}
 	}
 
-	fnIR.Signature = extractSignature(fn.Signature)
+	fnIR.Signature = extractSignature(names, fn.Signature)
+	// Unnamed parameters are named as the body refers to them.
+	params := fn.Params
+	if fn.Signature.Recv() != nil && len(params) > 0 {
+		params = params[1:]
+	}
+	for i := range fnIR.Signature.Params {
+		if fnIR.Signature.Params[i].Name == "" && i < len(params) {
+			fnIR.Signature.Params[i].Name = params[i].Name()
+		}
+	}
 
 	if fn.Blocks != nil {
-		body := &BodyIR{
-			Blocks:      make([]BlockIR, 0),
-			Locals:      extractLocals(fn),
-			FreeVars:    extractFreeVars(fn),
-			StructHints: extractASTHints(fn, goPackage),
-			Defers:      make([]DeferInfo, 0),
+		body := &ir.BodyIR{
+			Blocks:       make([]ir.BlockIR, 0),
+			Values:       extractValues(names, fn),
+			NamedResults: extractNamedResults(names, fn),
+			Locals:       extractLocals(names, fn),
+			FreeVars:     extractFreeVars(fn),
+			StructHints:  extractASTHints(fn, goPackage),
+			Defers:       make([]ir.DeferInfo, 0),
 		}
 
 		for i, block := range fn.Blocks {
-			blockIR := BlockIR{
+			blockIR := ir.BlockIR{
 				ID:           i,
-				Instructions: make([]Instruction, 0),
-				Successors:   make([]int, 0),
+				Instructions: make([]ir.Instruction, 0),
 			}
 
 			for _, instr := range block.Instrs {
-				inst := convertInstruction(instr)
+				inst := convertInstruction(names, instr)
+				target, use := stdlib.resolve(instr, pkg)
+				inst.Stdlib = target
+				if use != nil {
+					unmapped = append(unmapped, *use)
+				}
 				blockIR.Instructions = append(blockIR.Instructions, inst)
 
 				if _, ok := instr.(*ssa.Defer); ok {
-					body.Defers = append(body.Defers, DeferInfo{
+					body.Defers = append(body.Defers, ir.DeferInfo{
 						BlockID: i,
 						Call:    inst.Comment,
 					})
 				}
 			}
 
-			for _, succ := range block.Succs {
-				for j, b := range fn.Blocks {
-					if b == succ {
-						blockIR.Successors = append(blockIR.Successors, j)
-						break
-					}
-				}
-			}
-
 			body.Blocks = append(body.Blocks, blockIR)
 		}
+		extractCFG(fn, body)
 
 		fnIR.Body = body
 	}
 
-	return fnIR
+	return fnIR, firstUses(unmapped)
 }
 
-func extractLocals(fn *ssa.Function) []LocalVar {
-	locals := make([]LocalVar, 0)
+func extractLocals(names localNames, fn *ssa.Function) []ir.LocalVar {
+	locals := make([]ir.LocalVar, 0)
 	seen := make(map[string]bool)
 
 	for _, block := range fn.Blocks {
 		for _, instr := range block.Instrs {
 			if alloc, ok := instr.(*ssa.Alloc); ok {
 				if alloc.Comment != "" && !seen[alloc.Name()] {
-					locals = append(locals, LocalVar{
+					locals = append(locals, ir.LocalVar{
 						Name: alloc.Name(),
-						Type: types.TypeString(alloc.Type(), nil),
+						Type: names.typeString(alloc.Type()),
 					})
 					seen[alloc.Name()] = true
 				}
@@ -531,45 +624,37 @@ func extractFreeVars(fn *ssa.Function) []string {
 	return freeVars
 }
 
-func extractASTHints(fn *ssa.Function, goPackage *packages.Package) map[string]HintIR {
-	hints := make(map[string]HintIR)
+// extractASTHints records the control-flow statements of fn keyed by kind,
+// line and column, so keys do not depend on the order files were parsed in.
+func extractASTHints(fn *ssa.Function, goPackage *packages.Package) map[string]ir.HintIR {
+	hints := make(map[string]ir.HintIR)
 
 	if goPackage == nil || fn.Syntax() == nil {
 		return hints
 	}
 
+	add := func(prefix, kind string, pos token.Pos) {
+		p := goPackage.Fset.Position(pos)
+		hints[fmt.Sprintf("%s_%d_%d", prefix, p.Line, p.Column)] = ir.HintIR{
+			Kind:  kind,
+			Lines: []int{p.Line},
+		}
+	}
+
 	ast.Inspect(fn.Syntax(), func(n ast.Node) bool {
 		switch stmt := n.(type) {
 		case *ast.IfStmt:
-			hints[fmt.Sprintf("if_%d", stmt.Pos())] = HintIR{
-				Kind:  "if",
-				Lines: []int{int(stmt.Pos())},
-			}
+			add("if", "if", stmt.Pos())
 		case *ast.ForStmt:
-			hints[fmt.Sprintf("for_%d", stmt.Pos())] = HintIR{
-				Kind:  "for",
-				Lines: []int{int(stmt.Pos())},
-			}
+			add("for", "for", stmt.Pos())
 		case *ast.RangeStmt:
-			hints[fmt.Sprintf("range_%d", stmt.Pos())] = HintIR{
-				Kind:  "for",
-				Lines: []int{int(stmt.Pos())},
-			}
+			add("range", "for", stmt.Pos())
 		case *ast.SwitchStmt:
-			hints[fmt.Sprintf("switch_%d", stmt.Pos())] = HintIR{
-				Kind:  "switch",
-				Lines: []int{int(stmt.Pos())},
-			}
+			add("switch", "switch", stmt.Pos())
 		case *ast.SelectStmt:
-			hints[fmt.Sprintf("select_%d", stmt.Pos())] = HintIR{
-				Kind:  "select",
-				Lines: []int{int(stmt.Pos())},
-			}
+			add("select", "select", stmt.Pos())
 		case *ast.DeferStmt:
-			hints[fmt.Sprintf("defer_%d", stmt.Pos())] = HintIR{
-				Kind:  "defer",
-				Lines: []int{int(stmt.Pos())},
-			}
+			add("defer", "defer", stmt.Pos())
 		}
 		return true
 	})
@@ -577,8 +662,8 @@ func extractASTHints(fn *ssa.Function, goPackage *packages.Package) map[string]H
 	return hints
 }
 
-func convertInstruction(instr ssa.Instruction) Instruction {
-	inst := Instruction{
+func convertInstruction(names localNames, instr ssa.Instruction) ir.Instruction {
+	inst := ir.Instruction{
 		Op:      fmt.Sprintf("%T", instr),
 		Args:    make([]string, 0),
 		Comment: instr.String(),
@@ -588,14 +673,15 @@ func convertInstruction(instr ssa.Instruction) Instruction {
 
 	if v, ok := instr.(ssa.Value); ok {
 		inst.Result = v.Name()
-		inst.Type = types.TypeString(v.Type(), nil)
+		inst.Type = names.typeString(v.Type())
 	}
 
 	for _, op := range instr.Operands(nil) {
 		if op != nil && *op != nil {
-			inst.Args = append(inst.Args, (*op).Name())
+			inst.Args = append(inst.Args, operandName(names, *op))
 		}
 	}
+	encodeInstruction(names, instr, &inst)
 
 	return inst
 }
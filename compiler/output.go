@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/gonim/compiler/ir"
+)
+
+const (
+	formatJSON    = "json"
+	formatBinary  = "binary"
+	formatJSONL   = "jsonl"
+	formatSharded = "sharded"
+)
+
+// defaultOutputs maps each IR format to its default output path.
+var defaultOutputs = map[string]string{
+	formatJSON:    "output.json",
+	formatBinary:  "output.gnir",
+	formatJSONL:   "output.jsonl",
+	formatSharded: "output_ir",
+}
+
+// irWriter receives the IR as it is generated: begin, then for every package
+// beginPackage, its functions and endPackage, then end.
+type irWriter interface {
+	begin(build ir.BuildInfo, module *ir.ModuleManifest, tests *ir.TestManifest) error
+	beginPackage(pkg ir.PackageIR) error
+	function(fn ir.FunctionIR) error
+	endPackage() error
+	end(mainPkg string, diags []ir.Diagnostic) error
+}
+
+// memoryWriter assembles the complete HybridIR.
+type memoryWriter struct {
+	ir ir.HybridIR
+}
+
+func (w *memoryWriter) begin(build ir.BuildInfo, module *ir.ModuleManifest, tests *ir.TestManifest) error {
+	w.ir = ir.HybridIR{
+		Build:    build,
+		Module:   module,
+		Tests:    tests,
+		Packages: make([]ir.PackageIR, 0),
+	}
+	return nil
+}
+
+func (w *memoryWriter) beginPackage(pkg ir.PackageIR) error {
+	w.ir.Packages = append(w.ir.Packages, pkg)
+	return nil
+}
+
+func (w *memoryWriter) function(fn ir.FunctionIR) error {
+	pkg := &w.ir.Packages[len(w.ir.Packages)-1]
+	pkg.Functions = append(pkg.Functions, fn)
+	return nil
+}
+
+func (w *memoryWriter) endPackage() error {
+	return nil
+}
+
+func (w *memoryWriter) end(mainPkg string, diags []ir.Diagnostic) error {
+	w.ir.MainPkg = mainPkg
+	w.ir.Diagnostics = diags
+	return nil
+}
+
+// IRRecord is one line of the JSON Lines output. The stream starts with a
+// "build" record, continues with a "package" record (without functions)
+// followed by one "function" record per function for every package, and ends
+// with an "end" record.
+type IRRecord struct {
+	Kind        string             `json:"kind"`
+	Build       *ir.BuildInfo      `json:"build,omitempty"`
+	Module      *ir.ModuleManifest `json:"module,omitempty"`
+	Tests       *ir.TestManifest   `json:"tests,omitempty"`
+	Package     *ir.PackageIR      `json:"package,omitempty"`
+	Function    *ir.FunctionIR     `json:"function,omitempty"`
+	MainPkg     string             `json:"main_package,omitempty"`
+	Diagnostics []ir.Diagnostic    `json:"diagnostics,omitempty"`
+}
+
+type jsonlWriter struct {
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+}
+
+func newJSONLWriter(path string) (*jsonlWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(f)
+	return &jsonlWriter{file: f, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+func (w *jsonlWriter) begin(build ir.BuildInfo, module *ir.ModuleManifest, tests *ir.TestManifest) error {
+	return w.enc.Encode(IRRecord{Kind: "build", Build: &build, Module: module, Tests: tests})
+}
+
+func (w *jsonlWriter) beginPackage(pkg ir.PackageIR) error {
+	return w.enc.Encode(IRRecord{Kind: "package", Package: &pkg})
+}
+
+func (w *jsonlWriter) function(fn ir.FunctionIR) error {
+	return w.enc.Encode(IRRecord{Kind: "function", Function: &fn})
+}
+
+func (w *jsonlWriter) endPackage() error {
+	return nil
+}
+
+func (w *jsonlWriter) end(mainPkg string, diags []ir.Diagnostic) error {
+	if err := w.enc.Encode(IRRecord{Kind: "end", MainPkg: mainPkg, Diagnostics: diags}); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// ShardIndex is the index.json manifest of the sharded output. Each package
+// is written to its own file next to the index.
+type ShardIndex struct {
+	Build       ir.BuildInfo       `json:"build"`
+	Module      *ir.ModuleManifest `json:"module,omitempty"`
+	Tests       *ir.TestManifest   `json:"tests,omitempty"`
+	Packages    []ShardEntry       `json:"packages"`
+	MainPkg     string             `json:"main_package"`
+	Diagnostics []ir.Diagnostic    `json:"diagnostics"`
+}
+
+type ShardEntry struct {
+	Path      string   `json:"path"`
+	Name      string   `json:"name"`
+	File      string   `json:"file"`
+	Functions int      `json:"functions"`
+	Imports   []string `json:"imports"`
+}
+
+type shardedWriter struct {
+	dir   string
+	index ShardIndex
+	files map[string]bool
+	pkg   ir.PackageIR
+}
+
+func newShardedWriter(dir string) (*shardedWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &shardedWriter{dir: dir, files: make(map[string]bool)}, nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// shardFile returns a file name for the package path that is unique within
+// this output.
+func (w *shardedWriter) shardFile(pkgPath string) string {
+	base := unsafeFileChars.ReplaceAllString(pkgPath, "_")
+	name := base + ".json"
+	for i := 2; w.files[name]; i++ {
+		name = fmt.Sprintf("%s-%d.json", base, i)
+	}
+	w.files[name] = true
+	return name
+}
+
+func (w *shardedWriter) begin(build ir.BuildInfo, module *ir.ModuleManifest, tests *ir.TestManifest) error {
+	w.index = ShardIndex{
+		Build:    build,
+		Module:   module,
+		Tests:    tests,
+		Packages: make([]ShardEntry, 0),
+	}
+	return nil
+}
+
+func (w *shardedWriter) beginPackage(pkg ir.PackageIR) error {
+	w.pkg = pkg
+	return nil
+}
+
+func (w *shardedWriter) function(fn ir.FunctionIR) error {
+	w.pkg.Functions = append(w.pkg.Functions, fn)
+	return nil
+}
+
+func (w *shardedWriter) endPackage() error {
+	entry := ShardEntry{
+		Path:      w.pkg.Path,
+		Name:      w.pkg.Name,
+		File:      w.shardFile(w.pkg.Path),
+		Functions: len(w.pkg.Functions),
+		Imports:   w.pkg.Imports,
+	}
+	if err := writeJSONFile(filepath.Join(w.dir, entry.File), w.pkg); err != nil {
+		return err
+	}
+	w.index.Packages = append(w.index.Packages, entry)
+	w.pkg = ir.PackageIR{}
+	return nil
+}
+
+func (w *shardedWriter) end(mainPkg string, diags []ir.Diagnostic) error {
+	w.index.MainPkg = mainPkg
+	w.index.Diagnostics = diags
+	return writeJSONFile(filepath.Join(w.dir, "index.json"), w.index)
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func writeBinaryFile(path string, v any) error {
+	data, err := ir.MarshalBinary(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
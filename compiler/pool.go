@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+// workerPool runs tasks on a fixed number of goroutines. Tasks must not
+// submit further tasks to the same pool.
+type workerPool struct {
+	tasks chan func()
+}
+
+func newWorkerPool(n int) *workerPool {
+	p := &workerPool{tasks: make(chan func())}
+	for range n {
+		go func() {
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// run calls fn(0) to fn(n-1) on the pool and waits for all of them.
+func (p *workerPool) run(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range n {
+		p.tasks <- func() {
+			defer wg.Done()
+			fn(i)
+		}
+	}
+	wg.Wait()
+}
+
+func (p *workerPool) close() {
+	close(p.tasks)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+)
+
+// featureSupport lists the language features the report looks for and
+// whether the runtime in runtime/runtime.nim can execute them.
+var featureSupport = map[string]bool{
+	"generics":   true,
+	"goroutines": true,
+	"channels":   true,
+	"select":     false,
+	"defer":      true,
+	"closures":   true,
+	"panic":      true,
+	"recover":    true,
+	"reflect":    false,
+	"unsafe":     false,
+	"cgo":        false,
+}
+
+type CompatReport struct {
+	Packages []PackageReport `json:"packages"`
+	Summary  ReportSummary   `json:"summary"`
+}
+
+type ReportSummary struct {
+	Packages             int `json:"packages"`
+	Functions            int `json:"functions"`
+	UnsupportedFunctions int `json:"unsupported_functions"`
+}
+
+type PackageReport struct {
+	Path        string           `json:"path"`
+	Features    []string         `json:"features"`
+	Stdlib      []StdlibUse      `json:"stdlib"`
+	Unsupported []string         `json:"unsupported"`
+	Functions   []FunctionReport `json:"functions"`
+}
+
+type StdlibUse struct {
+	Path      string `json:"path"`
+	Supported bool   `json:"supported"`
+	NimModule string `json:"nim_module,omitempty"`
+}
+
+type FunctionReport struct {
+	Name        string   `json:"name"`
+	Position    string   `json:"position"`
+	Features    []string `json:"features"`
+	Stdlib      []string `json:"stdlib"`
+	Unsupported []string `json:"unsupported"`
+}
+
+// unsupportedImport names an unsupported stdlib package in the report;
+// packages that are features of their own (unsafe, reflect) keep their name.
+func unsupportedImport(path string) string {
+	if _, ok := featureSupport[path]; ok {
+		return path
+	}
+	return "import " + path
+}
+
+func buildReport(p *program) CompatReport {
+	report := CompatReport{Packages: make([]PackageReport, 0)}
+
+	byPkg := make(map[*ssa.Package][]*ssa.Function)
+	for _, fn := range p.initialFunctions() {
+		if fn.Origin() != nil {
+			continue
+		}
+		byPkg[fn.Pkg] = append(byPkg[fn.Pkg], fn)
+	}
+
+	for _, pkg := range p.pkgs {
+		if pkg == nil {
+			continue
+		}
+		goPackage := findGoPackage(pkg, p.initial)
+
+		pr := PackageReport{
+			Path:        pkg.Pkg.Path(),
+			Stdlib:      make([]StdlibUse, 0),
+			Functions:   make([]FunctionReport, 0),
+			Unsupported: make([]string, 0),
+		}
+
+		features := make(map[string]bool)
+		unsupported := make(map[string]bool)
+		for _, fn := range byPkg[pkg] {
+			fr := reportFunction(fn, goPackage, p.stdlib)
+			for _, f := range fr.Features {
+				features[f] = true
+			}
+			for _, u := range fr.Unsupported {
+				unsupported[u] = true
+			}
+			if len(fr.Unsupported) > 0 {
+				report.Summary.UnsupportedFunctions++
+			}
+			pr.Functions = append(pr.Functions, fr)
+		}
+
+		for _, imp := range pkg.Pkg.Imports() {
+			switch {
+			case imp.Path() == "C":
+				features["cgo"] = true
+			case p.stdlib.isStandard(imp.Path()):
+				use := StdlibUse{
+					Path:      imp.Path(),
+					Supported: p.stdlib.manifest.supports(imp.Path()),
+					NimModule: p.stdlib.manifest.module(imp.Path()),
+				}
+				if _, ok := featureSupport[imp.Path()]; ok {
+					features[imp.Path()] = true
+				}
+				if !use.Supported {
+					unsupported[unsupportedImport(imp.Path())] = true
+				}
+				pr.Stdlib = append(pr.Stdlib, use)
+			}
+		}
+		if goPackage != nil && usesCgo(goPackage) {
+			features["cgo"] = true
+			unsupported["cgo"] = true
+		}
+		sort.Slice(pr.Stdlib, func(i, j int) bool { return pr.Stdlib[i].Path < pr.Stdlib[j].Path })
+
+		pr.Features = sortedKeys(features)
+		pr.Unsupported = sortedKeys(unsupported)
+		report.Packages = append(report.Packages, pr)
+		report.Summary.Functions += len(pr.Functions)
+	}
+
+	report.Summary.Packages = len(report.Packages)
+	return report
+}
+
+func usesCgo(pkg *packages.Package) bool {
+	for _, file := range pkg.Syntax {
+		for _, spec := range file.Imports {
+			if spec.Path.Value == `"C"` {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func reportFunction(fn *ssa.Function, goPackage *packages.Package, stdlib *stdlibResolver) FunctionReport {
+	fr := FunctionReport{
+		Name:        fn.RelString(fn.Pkg.Pkg),
+		Features:    make([]string, 0),
+		Stdlib:      make([]string, 0),
+		Unsupported: make([]string, 0),
+	}
+	if fn.Pos().IsValid() {
+		pos := fn.Prog.Fset.Position(fn.Pos())
+		fr.Position = fmt.Sprintf("%s:%d", pos.Filename, pos.Line)
+	}
+
+	features := make(map[string]bool)
+	if fn.TypeParams().Len() > 0 {
+		features["generics"] = true
+	}
+	if fn.Parent() != nil {
+		features["closures"] = true
+	}
+
+	for _, block := range fn.Blocks {
+		for _, instr := range block.Instrs {
+			switch instr := instr.(type) {
+			case *ssa.Go:
+				features["goroutines"] = true
+			case *ssa.Select:
+				features["select"] = true
+			case *ssa.Defer:
+				features["defer"] = true
+			case *ssa.MakeChan, *ssa.Send:
+				features["channels"] = true
+			case *ssa.UnOp:
+				if instr.Op == token.ARROW {
+					features["channels"] = true
+				}
+			case *ssa.MakeClosure:
+				features["closures"] = true
+			case *ssa.Panic:
+				features["panic"] = true
+			}
+
+			call, ok := instr.(ssa.CallInstruction)
+			if !ok {
+				continue
+			}
+			if b, ok := call.Common().Value.(*ssa.Builtin); ok && b.Name() == "recover" {
+				features["recover"] = true
+			}
+			if callee := call.Common().StaticCallee(); callee != nil && callee.Origin() != nil {
+				features["generics"] = true
+			}
+		}
+	}
+
+	imported := make(map[string]bool)
+	if goPackage != nil && fn.Syntax() != nil {
+		for _, path := range referencedPackages(fn.Syntax(), goPackage.TypesInfo) {
+			switch {
+			case path == "C":
+				features["cgo"] = true
+			case path == "unsafe" || path == "reflect":
+				features[path] = true
+				imported[path] = true
+			case stdlib.isStandard(path):
+				imported[path] = true
+			}
+		}
+	}
+
+	fr.Features = sortedKeys(features)
+	fr.Stdlib = sortedKeys(imported)
+	for _, f := range fr.Features {
+		if !featureSupport[f] {
+			fr.Unsupported = append(fr.Unsupported, f)
+		}
+	}
+	for _, path := range fr.Stdlib {
+		if _, ok := featureSupport[path]; !ok && !stdlib.manifest.supports(path) {
+			fr.Unsupported = append(fr.Unsupported, unsupportedImport(path))
+		}
+	}
+	return fr
+}
+
+// referencedPackages returns the import paths of the packages referenced by
+// qualified identifiers in node, not descending into function literals,
+// which are reported as functions of their own.
+func referencedPackages(node ast.Node, info *types.Info) []string {
+	paths := make(map[string]bool)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.FuncLit); ok && lit != node {
+			return false
+		}
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		if pn, ok := info.Uses[id].(*types.PkgName); ok {
+			paths[pn.Imported().Path()] = true
+		}
+		return true
+	})
+	return sortedKeys(paths)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeReportMarkdown(w io.Writer, report CompatReport) {
+	fmt.Fprintln(w, "# go2nim compatibility report")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%d packages, %d functions, %d functions using unsupported features.\n",
+		report.Summary.Packages, report.Summary.Functions, report.Summary.UnsupportedFunctions)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Package | Functions | Features | Stdlib | Unsupported |")
+	fmt.Fprintln(w, "|---------|-----------|----------|--------|-------------|")
+	for _, pr := range report.Packages {
+		stdlib := make([]string, 0, len(pr.Stdlib))
+		for _, use := range pr.Stdlib {
+			mark := "✓"
+			if !use.Supported {
+				mark = "✗"
+			}
+			stdlib = append(stdlib, fmt.Sprintf("`%s` %s", use.Path, mark))
+		}
+		fmt.Fprintf(w, "| `%s` | %d | %s | %s | %s |\n", pr.Path, len(pr.Functions),
+			markdownList(pr.Features), strings.Join(stdlib, ", "), markdownList(pr.Unsupported))
+	}
+
+	for _, pr := range report.Packages {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "## `%s`\n", pr.Path)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "| Function | Position | Features | Stdlib | Unsupported |")
+		fmt.Fprintln(w, "|----------|----------|----------|--------|-------------|")
+		for _, fr := range pr.Functions {
+			fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s |\n", fr.Name, fr.Position,
+				markdownList(fr.Features), markdownList(fr.Stdlib), markdownList(fr.Unsupported))
+		}
+	}
+}
+
+func markdownList(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	return strings.Join(items, ", ")
+}
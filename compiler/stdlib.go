@@ -0,0 +1,191 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"go/types"
+	"os"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+// defaultStdlibManifest maps the Go standard library symbols implemented in
+// stdlib/*.nim to their Nim module and proc. Keys of "symbols" are function
+// names, or Type.Method for methods (including interface methods).
+//
+//go:embed stdlib_manifest.json
+var defaultStdlibManifest []byte
+
+type stdlibManifest struct {
+	Packages map[string]stdlibPackage `json:"packages"`
+}
+
+type stdlibPackage struct {
+	Module  string            `json:"module"`
+	Symbols map[string]string `json:"symbols"`
+}
+
+func loadStdlibManifest(path string) (*stdlibManifest, error) {
+	data := defaultStdlibManifest
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdlib manifest: %w", err)
+		}
+	}
+
+	var m stdlibManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid stdlib manifest: %w", err)
+	}
+	if m.Packages == nil {
+		m.Packages = make(map[string]stdlibPackage)
+	}
+	return &m, nil
+}
+
+func (m *stdlibManifest) supports(pkgPath string) bool {
+	_, ok := m.Packages[pkgPath]
+	return ok
+}
+
+func (m *stdlibManifest) module(pkgPath string) string {
+	return m.Packages[pkgPath].Module
+}
+
+// stdlibResolver resolves calls into standard library packages against the
+// manifest and reports every unmapped symbol once.
+type stdlibResolver struct {
+	manifest *stdlibManifest
+	std      map[string]bool
+	diags    *diagnostics
+	severity string
+	reported map[string]bool
+}
+
+// UnmappedUse is a use of a standard library symbol missing from the
+// manifest. Uses are collected per package, kept in the cache with the
+// package IR, and reported once per symbol.
+type UnmappedUse struct {
+	Symbol     string        `json:"symbol"`
+	Diagnostic ir.Diagnostic `json:"diagnostic"`
+}
+
+func newStdlibResolver(m *stdlibManifest, diags *diagnostics, std map[string]bool, allowUnmapped bool) *stdlibResolver {
+	r := &stdlibResolver{
+		manifest: m,
+		std:      std,
+		diags:    diags,
+		severity: severityError,
+		reported: make(map[string]bool),
+	}
+	if allowUnmapped {
+		r.severity = severityWarning
+	}
+	return r
+}
+
+// isStandard reports whether path is a standard library package.
+func (r *stdlibResolver) isStandard(path string) bool {
+	return r.std[path]
+}
+
+// callee returns the package path and manifest symbol of the standard
+// library function or method called by instr.
+func (r *stdlibResolver) callee(instr ssa.Instruction) (string, string, bool) {
+	call, ok := instr.(ssa.CallInstruction)
+	if !ok {
+		return "", "", false
+	}
+	common := call.Common()
+
+	if common.IsInvoke() {
+		named, ok := types.Unalias(common.Value.Type()).(*types.Named)
+		if !ok || named.Obj().Pkg() == nil || !r.isStandard(named.Obj().Pkg().Path()) {
+			return "", "", false
+		}
+		return named.Obj().Pkg().Path(), named.Obj().Name() + "." + common.Method.Name(), true
+	}
+
+	callee := common.StaticCallee()
+	if callee == nil {
+		return "", "", false
+	}
+	if callee.Origin() != nil {
+		callee = callee.Origin()
+	}
+	obj, ok := callee.Object().(*types.Func)
+	if !ok || obj.Pkg() == nil || !r.isStandard(obj.Pkg().Path()) {
+		return "", "", false
+	}
+
+	symbol := obj.Name()
+	if recv := obj.Signature().Recv(); recv != nil {
+		symbol = receiverBaseName(recv.Type()) + "." + symbol
+	}
+	return obj.Pkg().Path(), symbol, true
+}
+
+// resolve returns the manifest target of a call into the standard library,
+// made by a function of package from, or the use of a symbol the manifest
+// does not map. It does not modify r, so it can be called concurrently.
+func (r *stdlibResolver) resolve(instr ssa.Instruction, from *ssa.Package) (*ir.StdlibTarget, *UnmappedUse) {
+	pkgPath, symbol, ok := r.callee(instr)
+	if !ok {
+		return nil, nil
+	}
+
+	if pkg, ok := r.manifest.Packages[pkgPath]; ok {
+		if proc, ok := pkg.Symbols[symbol]; ok {
+			return &ir.StdlibTarget{
+				Package:   pkgPath,
+				Symbol:    symbol,
+				NimModule: pkg.Module,
+				NimProc:   proc,
+			}, nil
+		}
+	}
+
+	key := pkgPath + "." + symbol
+	fn := instr.Parent()
+	pos := instr.Pos()
+	if !pos.IsValid() {
+		pos = fn.Pos()
+	}
+	return nil, &UnmappedUse{
+		Symbol: key,
+		Diagnostic: diagnosticAt(fn.Prog.Fset, pos, from.Pkg.Path(), r.severity, "unmapped-stdlib",
+			fmt.Sprintf("%s has no Nim implementation in the stdlib manifest", key),
+			"implement it under stdlib/ and add it to the manifest, or avoid the call"),
+	}
+}
+
+// report adds a diagnostic for every symbol in uses that has not been
+// reported yet.
+func (r *stdlibResolver) report(uses []UnmappedUse) {
+	for _, u := range uses {
+		if !r.reported[u.Symbol] {
+			r.reported[u.Symbol] = true
+			r.diags.add(u.Diagnostic)
+		}
+	}
+}
+
+// firstUses merges lists of unmapped uses, keeping the first use of every
+// symbol.
+func firstUses(lists ...[]UnmappedUse) []UnmappedUse {
+	seen := make(map[string]bool)
+	uses := make([]UnmappedUse, 0)
+	for _, list := range lists {
+		for _, u := range list {
+			if !seen[u.Symbol] {
+				seen[u.Symbol] = true
+				uses = append(uses, u)
+			}
+		}
+	}
+	return uses
+}
@@ -0,0 +1,25 @@
+package main
+
+import "fmt"
+
+type Box[T any] struct{ v T }
+
+func (b *Box[T]) Each(f func(T)) {
+	g := func() { f(b.v) }
+	g()
+}
+
+func Map[T, U any](xs []T, f func(T) U) []U {
+	out := make([]U, 0)
+	add := func(x T) { out = append(out, f(x)) }
+	for _, x := range xs {
+		add(x)
+	}
+	return out
+}
+
+func main() {
+	fmt.Println(Map([]int{1, 2}, func(i int) string { return fmt.Sprint(i * 2) }))
+	b := &Box[int]{v: 7}
+	b.Each(func(i int) { fmt.Println("each", i) })
+}
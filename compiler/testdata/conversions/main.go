@@ -0,0 +1,25 @@
+package main
+
+import "fmt"
+
+type Runes []rune
+
+func main() {
+	s := "héllo, 世界"
+	b := []byte(s)
+	r := []rune(s)
+	fmt.Println(len(b), b[1], b[2])
+	fmt.Println(len(r), r[1], r[7])
+	fmt.Println(string(b[:3]), string(r[7:]))
+
+	// Invalid UTF-8 decodes to U+FFFD, one rune per bad byte.
+	bad := []rune("a\xffb\xe4\xb8")
+	fmt.Println(len(bad), bad[1], bad[3])
+
+	named := Runes("añb")
+	fmt.Println(len(named), named[1], string(named))
+
+	fmt.Println(string(rune(0x4e16)), string(rune(-1)) == "�")
+	t := b[1:3:4]
+	fmt.Println(len(t), cap(t), string(t))
+}
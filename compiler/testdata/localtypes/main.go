@@ -0,0 +1,51 @@
+package main
+
+import "fmt"
+
+type T struct{ n int }
+
+func (t *T) Sum() int {
+	type pair struct{ a, b int }
+	p := pair{t.n, 2}
+	return p.a + p.b
+}
+
+func first() []string {
+	type pair struct{ k, v string }
+	ps := []pair{{"a", "1"}, {"b", "2"}}
+	out := make([]string, 0)
+	for _, p := range ps {
+		out = append(out, p.k+"="+p.v)
+	}
+	return out
+}
+
+func second() map[string]int {
+	type pair struct {
+		k string
+		v int
+	}
+	m := make(map[string]int)
+	for _, p := range []pair{{"x", 1}, {"y", 2}} {
+		m[p.k] = p.v
+	}
+	{
+		type pair int
+		var q pair = 3
+		m["q"] = int(q)
+	}
+	return m
+}
+
+func init() {
+	type counter int
+	var c counter = 1
+	_ = c
+}
+
+func main() {
+	fmt.Println(first())
+	m := second()
+	fmt.Println(m["x"], m["y"], m["q"])
+	fmt.Println((&T{n: 5}).Sum())
+}
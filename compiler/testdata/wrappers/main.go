@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+type W struct{ n int }
+
+func (w W) Val() int { return w.n }
+
+type Pool struct{ wg sync.WaitGroup }
+
+func (wp *Pool) worker(id int, out chan<- int) {
+	defer wp.wg.Done()
+	out <- id * 10
+}
+
+func (wp *Pool) run(n int) int {
+	out := make(chan int, n)
+	start := wp.worker
+	for i := 0; i < n; i++ {
+		wp.wg.Add(1)
+		go start(i, out)
+	}
+	wp.wg.Wait()
+	close(out)
+	sum := 0
+	for v := range out {
+		sum += v
+	}
+	return sum
+}
+
+func main() {
+	w := W{n: 7}
+	get := func() func() int { return w.Val }
+	fmt.Println(get()())
+	p := &Pool{}
+	fmt.Println(p.run(4))
+}
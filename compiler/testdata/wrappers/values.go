@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Base struct{ name string }
+
+func (b *Base) Name() string { return b.name }
+
+type Outer struct {
+	Base
+	k int
+}
+
+type Box[T any] struct{ v T }
+
+func (b Box[T]) Get() T { return b.v }
+
+type Namer interface{ Name() string }
+
+func (o *Outer) names() []string {
+	var out []string
+	each := func(fs ...func() string) {
+		for _, f := range fs {
+			out = append(out, f())
+		}
+	}
+	func() {
+		var n Namer = &Base{name: "base"}
+		each(o.Name, n.Name)
+	}()
+	return out
+}
+
+func init() {
+	nested := func() func() {
+		return func() {
+			val := W.Val
+			fmt.Println("thunk", val(W{n: 3}))
+			b := Box[string]{v: "boxed"}
+			g := b.Get
+			fmt.Println(g())
+			up := strings.ToUpper
+			fmt.Println(up("std"))
+		}
+	}
+	nested()()
+	o := &Outer{Base: Base{name: "outer"}}
+	fmt.Println(o.names())
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/packages"
+)
+
+// testMainPackage is the path of the package synthesized in test mode whose
+// main function runs the tests of every loaded package.
+const testMainPackage = "testmain"
+
+// extractTests finds the test functions of initial, which are expected to be
+// the test variants selected by testVariants. Functions named like tests but
+// with the wrong signature are reported, as go test rejects them.
+func extractTests(initial []*packages.Package, diags *diagnostics) *ir.TestManifest {
+	pkgs := append([]*packages.Package(nil), initial...)
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].PkgPath < pkgs[j].PkgPath })
+
+	byPath := make(map[string]*ir.TestPackage)
+	m := &ir.TestManifest{Packages: make([]ir.TestPackage, 0)}
+	var order []string
+	for _, pkg := range pkgs {
+		if pkg.TypesInfo == nil {
+			continue
+		}
+		path := pkg.PkgPath
+		if pkg.ForTest != "" {
+			path = pkg.ForTest
+		}
+
+		tp := byPath[path]
+		if tp == nil {
+			tp = &ir.TestPackage{
+				Path:       path,
+				Tests:      make([]ir.TestFunc, 0),
+				Benchmarks: make([]ir.TestFunc, 0),
+				Examples:   make([]ir.TestFunc, 0),
+				Fuzz:       make([]ir.TestFunc, 0),
+			}
+			byPath[path] = tp
+			order = append(order, path)
+		}
+		extractPackageTests(pkg, tp, diags)
+	}
+
+	for _, path := range order {
+		tp := byPath[path]
+		if len(tp.Tests)+len(tp.Benchmarks)+len(tp.Examples)+len(tp.Fuzz) > 0 || tp.TestMain != nil {
+			m.Packages = append(m.Packages, *tp)
+		}
+	}
+	return m
+}
+
+func extractPackageTests(pkg *packages.Package, tp *ir.TestPackage, diags *diagnostics) {
+	files := make([]*ast.File, 0)
+	for _, file := range pkg.Syntax {
+		if strings.HasSuffix(pkg.Fset.Position(file.Pos()).Filename, "_test.go") {
+			files = append(files, file)
+		}
+	}
+
+	examples := make(map[string]*doc.Example)
+	for _, ex := range doc.Examples(files...) {
+		examples["Example"+ex.Name] = ex
+	}
+
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil {
+				continue
+			}
+			obj, ok := pkg.TypesInfo.Defs[fn.Name].(*types.Func)
+			if !ok {
+				continue
+			}
+
+			pos := pkg.Fset.Position(fn.Pos())
+			tf := ir.TestFunc{Name: fn.Name.Name, Package: pkg.PkgPath, File: pos.Filename, Line: pos.Line}
+			sig := obj.Signature()
+			name := fn.Name.Name
+
+			switch {
+			case name == "TestMain" && isTestFunc(sig, "M"):
+				tp.TestMain = &tf
+			case isTestName(name, "Test"):
+				if checkTestFunc(pkg, fn, sig, "T", diags) {
+					tp.Tests = append(tp.Tests, tf)
+				}
+			case isTestName(name, "Benchmark"):
+				if checkTestFunc(pkg, fn, sig, "B", diags) {
+					tp.Benchmarks = append(tp.Benchmarks, tf)
+				}
+			case isTestName(name, "Fuzz"):
+				if checkTestFunc(pkg, fn, sig, "F", diags) {
+					tp.Fuzz = append(tp.Fuzz, tf)
+				}
+			case isTestName(name, "Example"):
+				if ex, ok := examples[name]; ok {
+					tf.Output = ex.Output
+					tf.HasOutput = ex.Output != "" || ex.EmptyOutput
+					tf.Unordered = ex.Unordered
+					tp.Examples = append(tp.Examples, tf)
+				}
+			}
+		}
+	}
+}
+
+// isTestName reports whether name is a test function name for prefix, using
+// the rule of go test: the prefix alone, or followed by a rune that is not a
+// lower-case letter.
+func isTestName(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// isTestFunc reports whether sig is func(*testing.<param>).
+func isTestFunc(sig *types.Signature, param string) bool {
+	if sig.TypeParams().Len() > 0 || sig.Params().Len() != 1 || sig.Results().Len() != 0 {
+		return false
+	}
+	ptr, ok := sig.Params().At(0).Type().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := types.Unalias(ptr.Elem()).(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "testing" && named.Obj().Name() == param
+}
+
+func checkTestFunc(pkg *packages.Package, fn *ast.FuncDecl, sig *types.Signature, param string, diags *diagnostics) bool {
+	if isTestFunc(sig, param) {
+		return true
+	}
+	arg := strings.ToLower(param)
+	diags.addAt(pkg.Fset, fn.Pos(), pkg.PkgPath, severityError, "invalid-test-signature",
+		fmt.Sprintf("wrong signature for %s, must be: func %s(%s *testing.%s)", fn.Name.Name, fn.Name.Name, arg, param),
+		"fix the signature or rename the function so go test does not treat it as a test")
+	return false
+}
+
+// relativeTests returns m with file names relative to the working directory.
+func relativeTests(m *ir.TestManifest) *ir.TestManifest {
+	rel := &ir.TestManifest{Packages: make([]ir.TestPackage, len(m.Packages))}
+	relFuncs := func(fns []ir.TestFunc) []ir.TestFunc {
+		out := make([]ir.TestFunc, len(fns))
+		for i, tf := range fns {
+			tf.File = relativePath(tf.File)
+			out[i] = tf
+		}
+		return out
+	}
+	for i, tp := range m.Packages {
+		tp.Tests = relFuncs(tp.Tests)
+		tp.Benchmarks = relFuncs(tp.Benchmarks)
+		tp.Examples = relFuncs(tp.Examples)
+		tp.Fuzz = relFuncs(tp.Fuzz)
+		if tp.TestMain != nil {
+			tm := *tp.TestMain
+			tm.File = relativePath(tm.File)
+			tp.TestMain = &tm
+		}
+		rel.Packages[i] = tp
+	}
+	return rel
+}
+
+// testMainIR synthesizes the package whose main function registers the test
+// functions of every package with the Nim testing module, runs each package,
+// through its TestMain if it has one, and exits with the combined status.
+func testMainIR(m *ir.TestManifest, nimModule string) ir.PackageIR {
+	pkgIR := ir.PackageIR{
+		Path:       testMainPackage,
+		Name:       "main",
+		Types:      make([]ir.TypeDef, 0),
+		Functions:  make([]ir.FunctionIR, 0),
+		Globals:    make([]ir.GlobalVar, 0),
+		Constants:  make([]ir.ConstDef, 0),
+		Imports:    make([]string, 0),
+		CGOImports: make([]ir.CGOImport, 0),
+	}
+
+	values := make([]ir.ValueIR, 0)
+	valueTypes := make(map[string]string)
+	value := func(v ir.ValueIR) string {
+		if _, ok := valueTypes[v.Name]; !ok {
+			v.Index, v.Block = -1, -1
+			values = append(values, v)
+			valueTypes[v.Name] = v.Type
+		}
+		return v.Name
+	}
+	call := func(proc string, args ...string) ir.Instruction {
+		argTypes := make([]string, 0, len(args))
+		for _, arg := range args {
+			argTypes = append(argTypes, valueTypes[arg])
+		}
+		fn := value(ir.ValueIR{
+			Name: "testing." + proc,
+			Kind: ir.ValueFunction,
+			Type: "func(" + strings.Join(argTypes, ", ") + ")",
+			Ref:  "testing." + proc,
+		})
+		return ir.Instruction{
+			Op:      "Call",
+			Args:    append([]string{fn}, args...),
+			Type:    "()",
+			Comment: fmt.Sprintf("testing.%s(%s)", proc, strings.Join(args, ", ")),
+			Stdlib: &ir.StdlibTarget{
+				Package:   "testing",
+				Symbol:    proc,
+				NimModule: nimModule,
+				NimProc:   proc,
+			},
+			Call: &ir.CallIR{Kind: ir.CallStatic, Value: fn, Target: "testing." + proc, Args: append([]string{}, args...)},
+		}
+	}
+	str := func(s string) string {
+		return value(ir.ValueIR{Name: strconv.Quote(s) + ":string", Kind: ir.ValueConst, Type: "string", Value: strconv.Quote(s)})
+	}
+	boolean := func(b bool) string {
+		return value(ir.ValueIR{Name: strconv.FormatBool(b) + ":bool", Kind: ir.ValueConst, Type: "bool", Value: strconv.FormatBool(b)})
+	}
+	ref := func(tf ir.TestFunc, param string) string {
+		name := tf.Package + "." + tf.Name
+		return value(ir.ValueIR{Name: name, Kind: ir.ValueFunction, Type: "func(" + param + ")", Ref: name})
+	}
+
+	instrs := make([]ir.Instruction, 0)
+	imports := make(map[string]bool)
+	for _, tp := range m.Packages {
+		for _, tf := range tp.Tests {
+			instrs = append(instrs, call("registerTest", str(tf.Name), ref(tf, "*testing.T")))
+			imports[tf.Package] = true
+		}
+		for _, tf := range tp.Benchmarks {
+			instrs = append(instrs, call("registerBenchmark", str(tf.Name), ref(tf, "*testing.B")))
+			imports[tf.Package] = true
+		}
+		for _, tf := range tp.Examples {
+			if !tf.HasOutput {
+				continue
+			}
+			instrs = append(instrs, call("registerExample", str(tf.Name), ref(tf, ""), str(tf.Output), boolean(tf.Unordered)))
+			imports[tf.Package] = true
+		}
+		for _, tf := range tp.Fuzz {
+			instrs = append(instrs, call("registerFuzz", str(tf.Name), ref(tf, "*testing.F")))
+			imports[tf.Package] = true
+		}
+		if tp.TestMain != nil {
+			instrs = append(instrs, call("runTestMain", str(tp.Path), ref(*tp.TestMain, "*testing.M")))
+			imports[tp.TestMain.Package] = true
+		} else {
+			instrs = append(instrs, call("runPackage", str(tp.Path)))
+		}
+	}
+	instrs = append(instrs, call("exitTests"), ir.Instruction{Op: "Return", Comment: "return", Return: &ir.ReturnIR{Values: make([]string, 0)}})
+	pkgIR.Imports = sortedKeys(imports)
+
+	pkgIR.Functions = append(pkgIR.Functions, ir.FunctionIR{
+		Name:    "main",
+		Package: testMainPackage,
+		Signature: ir.FuncSignature{
+			Params:  make([]ir.Param, 0),
+			Results: make([]ir.Param, 0),
+		},
+		Body: &ir.BodyIR{
+			Blocks: []ir.BlockIR{{
+				ID:           0,
+				Instructions: instrs,
+				Successors:   make([]int, 0),
+				Predecessors: make([]int, 0),
+				Idom:         -1,
+				Dominees:     make([]int, 0),
+				PostIdom:     -1,
+				Loop:         -1,
+			}},
+			Values:       values,
+			NamedResults: make([]ir.NamedResult, 0),
+			Locals:       make([]ir.LocalVar, 0),
+			FreeVars:     make([]string, 0),
+			StructHints:  make(map[string]ir.HintIR),
+			Defers:       make([]ir.DeferInfo, 0),
+			Loops:        make([]ir.LoopIR, 0),
+		},
+	})
+	return pkgIR
+}
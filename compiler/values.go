@@ -0,0 +1,170 @@
+package main
+
+import (
+	"go/constant"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+// operandName returns the name instructions refer to v by: its SSA name,
+// except that constants are written with their exact value, where SSA
+// abbreviates long strings and rounds floats, and wrappers are qualified
+// with their receiver type.
+func operandName(names localNames, v ssa.Value) string {
+	if c, ok := v.(*ssa.Const); ok && c.Value != nil {
+		return constText(c.Value) + ":" + names.typeString(c.Type())
+	}
+	// Wrappers are named after the method only.
+	if fn, ok := v.(*ssa.Function); ok && isWrapper(fn) {
+		return fn.String()
+	}
+	return v.Name()
+}
+
+// constText writes v in Go syntax without loss.
+func constText(v constant.Value) string {
+	switch v.Kind() {
+	case constant.String:
+		return strconv.Quote(constant.StringVal(v))
+	case constant.Float:
+		f, _ := constant.Float64Val(v)
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	case constant.Complex:
+		re, _ := constant.Float64Val(constant.Real(v))
+		im, _ := constant.Float64Val(constant.Imag(v))
+		return strconv.FormatComplex(complex(re, im), 'g', -1, 128)
+	}
+	return v.ExactString()
+}
+
+// extractValues builds the value table of fn: its receiver, parameters and
+// free variables, every register, and the globals, functions, constants and
+// builtins its instructions use, in that order.
+func extractValues(names localNames, fn *ssa.Function) []ir.ValueIR {
+	values := make([]ir.ValueIR, 0)
+	seen := make(map[ir.ValueIR]bool)
+	add := func(v ir.ValueIR) {
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+
+	for i, p := range fn.Params {
+		v := ir.ValueIR{Name: p.Name(), Kind: ir.ValueParam, Type: names.typeString(p.Type()), Index: i, Block: -1}
+		if fn.Signature.Recv() != nil {
+			v.Index--
+			if i == 0 {
+				v.Kind, v.Index = ir.ValueReceiver, -1
+			}
+		}
+		add(v)
+	}
+	for i, fv := range fn.FreeVars {
+		add(ir.ValueIR{Name: fv.Name(), Kind: ir.ValueFreeVar, Type: names.typeString(fv.Type()), Index: i, Block: -1})
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if v, ok := instr.(ssa.Value); ok {
+				add(ir.ValueIR{Name: v.Name(), Kind: ir.ValueRegister, Type: names.typeString(v.Type()), Index: -1, Block: b.Index})
+			}
+		}
+	}
+
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for _, op := range instr.Operands(nil) {
+				if op == nil || *op == nil {
+					continue
+				}
+				v := ir.ValueIR{Name: operandName(names, *op), Type: names.typeString((*op).Type()), Index: -1, Block: -1}
+				switch x := (*op).(type) {
+				case *ssa.Global:
+					v.Kind, v.Ref = ir.ValueGlobal, x.Pkg.Pkg.Path()+"."+x.Name()
+				case *ssa.Function:
+					v.Kind, v.Ref = ir.ValueFunction, funcRef(x, fn.Pkg)
+				case *ssa.Const:
+					v.Kind = ir.ValueConst
+					if x.Value != nil {
+						v.Value = constText(x.Value)
+					}
+				case *ssa.Builtin:
+					v.Kind = ir.ValueBuiltin
+				default:
+					continue
+				}
+				add(v)
+			}
+		}
+	}
+	return values
+}
+
+// extractNamedResults returns the named result variables of fn, with the
+// Allocs of those SSA did not lift.
+func extractNamedResults(names localNames, fn *ssa.Function) []ir.NamedResult {
+	named := make([]ir.NamedResult, 0)
+	results := fn.Signature.Results()
+	for i := 0; i < results.Len(); i++ {
+		r := results.At(i)
+		if r.Name() == "" || r.Name() == "_" {
+			continue
+		}
+		nr := ir.NamedResult{Name: r.Name(), Type: names.typeString(r.Type()), Index: i}
+		// Results captured by closures are heap Allocs, which are not
+		// among the Locals.
+		for _, instr := range fn.Blocks[0].Instrs {
+			if a, ok := instr.(*ssa.Alloc); ok && a.Comment == r.Name() && a.Pos() == r.Pos() {
+				nr.Alloc = a.Name()
+				break
+			}
+		}
+		named = append(named, nr)
+	}
+	return named
+}
+
+// funcRef returns the ir.FuncKey of fn as referenced from package from, or
+// its name for other synthetic functions without a package.
+func funcRef(fn *ssa.Function, from *ssa.Package) string {
+	if isWrapper(fn) && from != nil {
+		return from.Pkg.Path() + "." + fn.RelString(from.Pkg)
+	}
+	if origin := fn.Origin(); origin != nil {
+		fn = origin
+	}
+	if fn.Parent() != nil && fn.Pkg != nil {
+		return fn.Pkg.Pkg.Path() + "." + anonName(fn, fn.Pkg.Pkg)
+	}
+	name := ir.StripTypeArgs(fn.Name())
+	if fn.Pkg == nil {
+		return name
+	}
+	pkg := fn.Pkg.Pkg.Path()
+	if recv := fn.Signature.Recv(); recv != nil {
+		t := recv.Type()
+		ptr, pointer := t.(*types.Pointer)
+		if pointer {
+			t = ptr.Elem()
+		}
+		// Methods belong to package-level types, which keep their names.
+		_, typeName := ir.SplitQualified(ir.StripTypeArgs(types.TypeString(t, nil)))
+		return ir.MethodKey(pkg, typeName, pointer, name)
+	}
+	return pkg + "." + name
+}
+
+// anonName returns the name of the anonymous function fn relative to pkg,
+// such as main$1 or, for methods, (*Box).Each$1, without the type
+// parameters of the receiver like method FuncKeys.
+func anonName(fn *ssa.Function, pkg *types.Package) string {
+	name := fn.RelString(pkg)
+	if i, j := strings.Index(name, "["), strings.Index(name, "])"); strings.HasPrefix(name, "(") && i >= 0 && j > i {
+		name = name[:i] + name[j+1:]
+	}
+	return name
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/packages"
+)
+
+func runWatch(args []string) int {
+	var opts loadOptions
+	fs := newFlagSet("watch")
+	opts.register(fs)
+	outputPath := fs.String("output", "", "Output file (default output.json or output.gnir)")
+	format := fs.String("format", formatJSON, "Output format: json or binary")
+	interval := fs.Duration("interval", 500*time.Millisecond, "How often to check the source files for changes")
+	execCmd := fs.String("exec", "", "Shell command run after every successful regeneration, e.g. the backend; $GONIM_IR is the output path")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	opts.addPatterns(fs.Args())
+	opts.lazyBuild = true
+
+	if *format != formatJSON && *format != formatBinary {
+		fmt.Fprintf(os.Stderr, "gonim-compile watch: unknown format %q\n", *format)
+		return exitUsage
+	}
+	if *outputPath == "" {
+		*outputPath = defaultOutputs[*format]
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "gonim-compile watch: -interval must be positive\n")
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	w := &watcher{
+		opts:     opts,
+		output:   *outputPath,
+		format:   *format,
+		execCmd:  *execCmd,
+		pending:  make(map[string]bool),
+		stamps:   make(map[string]fileStamp),
+		owners:   make(map[string][]string),
+		dirs:     make(map[string]string),
+		importer: make(map[string][]string),
+	}
+	if !w.reload(true) {
+		return exitFailure
+	}
+
+	log.Printf("Watching %d packages for changes (Ctrl-C to stop)", len(w.ir.Packages))
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case <-ticker.C:
+			full, changed := w.poll()
+			for _, path := range changed {
+				w.pending[path] = true
+			}
+			// The test main covers every package, so test mode always
+			// regenerates everything. Packages whose regeneration failed
+			// are retried until it succeeds.
+			if full || w.fullPending || (w.opts.tests && len(changed) > 0) {
+				w.reload(true)
+			} else if len(w.pending) > 0 {
+				w.reload(false)
+			}
+		}
+	}
+}
+
+// filesPackage is the path the go command gives the package of .go files
+// named on the command line.
+const filesPackage = "command-line-arguments"
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// watcher keeps the IR of the watched packages and the state of their files.
+// A change regenerates the changed packages and the watched packages that
+// import them, and merges their IR into the previous one.
+type watcher struct {
+	opts    loadOptions
+	output  string
+	format  string
+	execCmd string
+
+	ir ir.HybridIR
+	// pending holds packages whose regeneration failed or has not run yet.
+	pending map[string]bool
+
+	// fullPending is set when a full reload failed.
+	fullPending bool
+	// lastErr is the error of the last failed reload, which is not logged
+	// again while retries fail the same way.
+	lastErr string
+
+	// stamps holds the state of every watched file: the compiled Go files of
+	// the packages, their directories, so that added and removed files are
+	// noticed, and the go.mod files.
+	stamps map[string]fileStamp
+	// owners maps a compiled Go file to the packages it belongs to.
+	owners map[string][]string
+	// dirs maps a package directory to the package in it.
+	dirs map[string]string
+	// importer maps a package to the watched packages that import it.
+	importer map[string][]string
+	modFiles []string
+}
+
+// poll returns whether a go.mod file changed, which needs a full reload, and
+// the packages whose files changed.
+func (w *watcher) poll() (bool, []string) {
+	for _, mod := range w.modFiles {
+		if w.changed(mod, statFile(mod)) {
+			return true, nil
+		}
+	}
+
+	changed := make(map[string]bool)
+	for file, owners := range w.owners {
+		if w.changed(file, statFile(file)) {
+			for _, pkg := range owners {
+				changed[pkg] = true
+			}
+		}
+	}
+	for dir, pkg := range w.dirs {
+		if w.changed(dir, statFile(dir)) {
+			changed[pkg] = true
+		}
+	}
+	return false, sortedKeys(changed)
+}
+
+func (w *watcher) changed(path string, stamp fileStamp) bool {
+	if w.stamps[path] == stamp {
+		return false
+	}
+	w.stamps[path] = stamp
+	return true
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+// affected returns the pending packages and the watched packages that
+// import them, directly or indirectly.
+func (w *watcher) affected() []string {
+	seen := make(map[string]bool)
+	var visit func(path string)
+	visit = func(path string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		for _, imp := range w.importer[path] {
+			visit(imp)
+		}
+	}
+	for path := range w.pending {
+		visit(path)
+	}
+	return sortedKeys(seen)
+}
+
+// reload regenerates the IR, of every package or of the affected ones, and
+// reports whether the packages could be loaded.
+func (w *watcher) reload(full bool) bool {
+	start := time.Now()
+	opts := w.opts
+	var affected []string
+	if !full {
+		affected = w.affected()
+		// A package given as .go files is named command-line-arguments,
+		// which go/packages cannot load by path, nor its files together
+		// with other patterns, so its changes reload everything.
+		if containsString(affected, filesPackage) {
+			full, affected = true, nil
+		} else {
+			opts.patterns = affected
+		}
+	}
+
+	diags := newDiagnostics()
+	p, err := loadProgram(&opts, diags)
+	if err != nil {
+		if msg := err.Error(); msg != w.lastErr {
+			w.lastErr = msg
+			diags.print(os.Stderr)
+			if full {
+				log.Printf("Failed to load packages: %v; retrying", err)
+			} else {
+				log.Printf("Failed to load packages: %v; %s stale, retrying", err, strings.Join(affected, ", "))
+			}
+		}
+		w.fullPending = full
+		return false
+	}
+
+	mem := &memoryWriter{}
+	// memoryWriter never fails.
+	_ = emitIR(p, mem, nil, opts.verbose)
+
+	if full {
+		w.ir = mem.ir
+		w.stamps = make(map[string]fileStamp)
+		w.owners = make(map[string][]string)
+		w.dirs = make(map[string]string)
+		w.importer = make(map[string][]string)
+		w.modFiles = nil
+	} else {
+		w.merge(mem.ir, affected)
+	}
+	w.track(p.initial)
+	w.pending = make(map[string]bool)
+	w.fullPending = false
+	w.lastErr = ""
+
+	diags.print(os.Stderr)
+	if err := w.write(); err != nil {
+		log.Printf("Failed to write output: %v", err)
+		return true
+	}
+
+	if full {
+		log.Printf("Generated IR for %d packages in %s: %s", len(w.ir.Packages), time.Since(start).Round(time.Millisecond), w.output)
+	} else {
+		log.Printf("Regenerated %s in %s: %s", strings.Join(affected, ", "), time.Since(start).Round(time.Millisecond), w.output)
+	}
+
+	if n := diags.count(severityError); n > 0 {
+		log.Printf("%d errors; not running -exec", n)
+		return true
+	}
+	w.runExec()
+	return true
+}
+
+// merge replaces the packages in affected with their regenerated IR.
+func (w *watcher) merge(hir ir.HybridIR, affected []string) {
+	replaced := make(map[string]bool)
+	for _, path := range affected {
+		replaced[path] = true
+	}
+
+	pkgs := make([]ir.PackageIR, 0, len(w.ir.Packages))
+	for _, pkg := range w.ir.Packages {
+		if !replaced[pkg.Path] {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	pkgs = append(pkgs, hir.Packages...)
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Path < pkgs[j].Path })
+	w.ir.Packages = pkgs
+
+	if hir.MainPkg != "" {
+		w.ir.MainPkg = hir.MainPkg
+	} else if replaced[w.ir.MainPkg] {
+		w.ir.MainPkg = ""
+	}
+
+	diags := make([]ir.Diagnostic, 0, len(w.ir.Diagnostics))
+	for _, d := range w.ir.Diagnostics {
+		if !replaced[d.Package] {
+			diags = append(diags, d)
+		}
+	}
+	merged := &diagnostics{list: append(diags, hir.Diagnostics...)}
+	w.ir.Diagnostics = merged.sorted()
+}
+
+// track records the files, directories and imports of freshly loaded
+// packages.
+func (w *watcher) track(initial []*packages.Package) {
+	for _, pkg := range initial {
+		for file, owners := range w.owners {
+			w.owners[file] = removeString(owners, pkg.PkgPath)
+		}
+		for _, file := range pkg.CompiledGoFiles {
+			w.owners[file] = append(w.owners[file], pkg.PkgPath)
+			w.stamps[file] = statFile(file)
+		}
+		for _, file := range pkg.GoFiles {
+			dir := filepath.Dir(file)
+			w.dirs[dir] = pkg.PkgPath
+			w.stamps[dir] = statFile(dir)
+		}
+
+		for dep, importers := range w.importer {
+			w.importer[dep] = removeString(importers, pkg.PkgPath)
+		}
+		for path := range pkg.Imports {
+			w.importer[path] = append(w.importer[path], pkg.PkgPath)
+		}
+
+		if pkg.Module != nil && pkg.Module.GoMod != "" && !containsString(w.modFiles, pkg.Module.GoMod) {
+			w.modFiles = append(w.modFiles, pkg.Module.GoMod)
+			w.stamps[pkg.Module.GoMod] = statFile(pkg.Module.GoMod)
+		}
+	}
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watcher) write() error {
+	if w.format == formatBinary {
+		return writeBinaryFile(w.output, w.ir)
+	}
+	return writeJSONFile(w.output, w.ir)
+}
+
+func (w *watcher) runExec() {
+	if w.execCmd == "" {
+		return
+	}
+	cmd := exec.Command("sh", "-c", w.execCmd)
+	cmd.Env = append(os.Environ(), "GONIM_IR="+w.output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("-exec failed: %v", err)
+	}
+}
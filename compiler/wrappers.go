@@ -0,0 +1,67 @@
+package main
+
+import (
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/gonim/compiler/ir"
+	"golang.org/x/tools/go/ssa"
+)
+
+// isWrapper reports whether fn is a bound method wrapper or a thunk, which
+// SSA shares between packages instead of making them members of one.
+func isWrapper(fn *ssa.Function) bool {
+	if fn.Pkg != nil || fn.Synthetic == "" {
+		return false
+	}
+	return strings.HasSuffix(fn.Name(), "$bound") || strings.HasSuffix(fn.Name(), "$thunk")
+}
+
+// packageWrappers returns the wrappers the functions of pkg use, sorted by
+// name: its functions, the methods of its types and the anonymous functions
+// nested in either. A method value such as wp.worker, passed as a callback
+// from a closure, thus has its wrapper emitted next to the method it calls.
+func packageWrappers(pkg *ssa.Package) []*ssa.Function {
+	seen := make(map[*ssa.Function]bool)
+	wrappers := make([]*ssa.Function, 0)
+	for _, fn := range withAnonFuncs(append(packageFunctions(pkg), packageMethods(pkg)...)) {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				for _, op := range instr.Operands(nil) {
+					if op == nil {
+						continue
+					}
+					if w, ok := (*op).(*ssa.Function); ok && isWrapper(w) && !seen[w] {
+						seen[w] = true
+						wrappers = append(wrappers, w)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(wrappers, func(i, j int) bool {
+		return wrappers[i].String() < wrappers[j].String()
+	})
+	return wrappers
+}
+
+// describeWrapper describes the wrapper fn and the method it calls.
+func describeWrapper(names localNames, fn *ssa.Function) *ir.WrapperIR {
+	w := &ir.WrapperIR{Kind: ir.WrapperThunk, Method: fn.Object().Name()}
+	var recv types.Type
+	if strings.HasSuffix(fn.Name(), "$bound") {
+		w.Kind, recv = ir.WrapperBound, fn.FreeVars[0].Type()
+	} else {
+		recv = fn.Params[0].Type()
+	}
+	w.Recv = names.typeString(recv)
+	w.Interface = types.IsInterface(recv)
+	if !w.Interface {
+		// Methods of generic types are called through their origin.
+		if method := fn.Prog.FuncValue(fn.Object().(*types.Func).Origin()); method != nil {
+			w.Target = funcRef(method, nil)
+		}
+	}
+	return w
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gonim/compiler/ir"
+)
+
+func TestPackageWrappers(t *testing.T) {
+	prog := loadIR(t, "", "./testdata/wrappers")
+	pkg := prog.MainPkg
+	tests := []struct {
+		name   string
+		kind   string
+		target string
+	}{
+		{"(W).Val$bound", ir.WrapperBound, "(W).Val"},
+		{"(W).Val$thunk", ir.WrapperThunk, "(W).Val"},
+		// Taken in a method and passed as a callback.
+		{"(*Pool).worker$bound", ir.WrapperBound, "(*Pool).worker"},
+		// Taken in a closure of a method.
+		{"(*Base).Name$bound", ir.WrapperBound, "(*Base).Name"},
+		{"(Box[string]).Get$bound", ir.WrapperBound, "(Box).Get"},
+		// Interface methods have no target.
+		{"(Namer).Name$bound", ir.WrapperBound, ""},
+	}
+	for _, tt := range tests {
+		fn := prog.Function(pkg + "." + tt.name)
+		if fn == nil || fn.Wrapper == nil {
+			t.Errorf("wrapper %s is not in the IR", tt.name)
+			continue
+		}
+		w := fn.Wrapper
+		if w.Kind != tt.kind {
+			t.Errorf("%s: kind %q, want %q", tt.name, w.Kind, tt.kind)
+		}
+		target := ""
+		if tt.target != "" {
+			target = pkg + "." + tt.target
+		}
+		if w.Target != target {
+			t.Errorf("%s: target %q, want %q", tt.name, w.Target, target)
+		}
+		if w.Target != "" && prog.Function(w.Target) == nil {
+			t.Errorf("%s: target %s is not in the IR", tt.name, w.Target)
+		}
+	}
+}
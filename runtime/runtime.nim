@@ -85,6 +85,29 @@ proc `==`*(s1, s2: GoString): bool =
   if s1.isNil or s2.isNil: return false
   s1.data == s2.data
 
+# string(r) for an integer r: its UTF-8 encoding, or that of U+FFFD if r is
+# not a valid code point
+proc runeToGoString*(r: SomeInteger): GoString =
+  var c = int64(r)
+  if c < 0 or c > 0x10FFFF or (c >= 0xD800 and c <= 0xDFFF):
+    c = 0xFFFD
+  var s = ""
+  if c < 0x80:
+    s.add(char(c))
+  elif c < 0x800:
+    s.add(char(0xC0 or (c shr 6)))
+    s.add(char(0x80 or (c and 0x3F)))
+  elif c < 0x10000:
+    s.add(char(0xE0 or (c shr 12)))
+    s.add(char(0x80 or ((c shr 6) and 0x3F)))
+    s.add(char(0x80 or (c and 0x3F)))
+  else:
+    s.add(char(0xF0 or (c shr 18)))
+    s.add(char(0x80 or ((c shr 12) and 0x3F)))
+    s.add(char(0x80 or ((c shr 6) and 0x3F)))
+    s.add(char(0x80 or (c and 0x3F)))
+  newGoString(s)
+
 # ===========================
 # GoSlice Implementation
 # ===========================
@@ -112,6 +135,19 @@ proc append*[T](s: GoSlice[T], items: varargs[T]): GoSlice[T] =
     result.data[result.length] = item
     result.length.inc
 
+# append(s, other...)
+proc appendSlice*[T](s: GoSlice[T], other: GoSlice[T]): GoSlice[T] =
+  result = s
+  if other != nil:
+    for i in 0..<other.length:
+      result = result.append(other.data[i])
+
+# append(s, str...) appends the bytes of a string
+proc appendString*(s: GoSlice[uint8], str: GoString): GoSlice[uint8] =
+  result = s
+  for c in str.data:
+    result = result.append(uint8(c))
+
 proc `[]`*[T](s: GoSlice[T], i: int): T =
   if i < 0 or i >= s.length:
     raise newException(IndexDefect, "slice index out of range")
@@ -131,6 +167,20 @@ proc `[]`*[T](s: GoSlice[T], slice: HSlice[int, int]): GoSlice[T] =
   result.capacity = result.length
   result.data = s.data[start..<stop]
 
+# s[low:high:max]
+proc slice*[T](s: GoSlice[T], low, high, max: int): GoSlice[T] =
+  if low < 0 or low > high or high > max or max > s.capacity:
+    raise newException(IndexDefect, "slice bounds out of range")
+  result = newGoSliceFromSeq(s.data[low..<max])
+  result.length = high - low
+
+# a[low:high:max] of an array
+proc slice*[T](a: openArray[T], low, high, max: int): GoSlice[T] =
+  if low < 0 or low > high or high > max or max > a.len:
+    raise newException(IndexDefect, "slice bounds out of range")
+  result = newGoSliceFromSeq(a[low..<max])
+  result.length = high - low
+
 proc len*[T](s: GoSlice[T]): int =
   s.length
 
@@ -148,6 +198,91 @@ iterator pairs*[T](s: GoSlice[T]): (int, T) =
   for i in 0..<s.length:
     yield (i, s.data[i])
 
+proc copy*[T](dst: GoSlice[T], src: GoSlice[T]): int =
+  result = min(dst.length, src.length)
+  # Copy through a temporary, as the slices may overlap
+  let tmp = src.data[0..<result]
+  for i in 0..<result:
+    dst.data[i] = tmp[i]
+
+proc copy*(dst: GoSlice[uint8], src: GoString): int =
+  result = min(dst.length, src.data.len)
+  for i in 0..<result:
+    dst.data[i] = uint8(src.data[i])
+
+proc clear*[T](s: GoSlice[T]) =
+  for i in 0..<s.length:
+    s.data[i] = default(T)
+
+# string(b) of a []byte
+proc sliceToGoString*(s: GoSlice[uint8]): GoString =
+  var str = newString(s.length)
+  for i in 0..<s.length:
+    str[i] = char(s.data[i])
+  newGoString(str)
+
+# string(r) of a []rune
+proc sliceToGoString*(s: GoSlice[Rune]): GoString =
+  var str = ""
+  for i in 0..<s.length:
+    str.add($runeToGoString(s.data[i]))
+  newGoString(str)
+
+# []byte(s) of a string
+proc stringToBytes*(s: GoString): GoSlice[uint8] =
+  var data = newSeq[uint8](s.data.len)
+  for i, c in s.data:
+    data[i] = uint8(c)
+  newGoSliceFromSeq(data)
+
+# []rune(s) of a string: its code points, with U+FFFD for each byte that
+# does not start a valid UTF-8 sequence
+proc stringToRunes*(s: GoString): GoSlice[Rune] =
+  var data: seq[Rune] = @[]
+  var i = 0
+  while i < s.data.len:
+    let b0 = int32(s.data[i])
+    var r = Rune(0xFFFD)
+    var n = 1
+    if b0 < 0x80:
+      r = b0
+    else:
+      var need = 0
+      var lo = 0x80'i32
+      var hi = 0xBF'i32
+      if b0 >= 0xC2 and b0 <= 0xDF:
+        need = 1
+        r = b0 and 0x1F
+      elif b0 >= 0xE0 and b0 <= 0xEF:
+        need = 2
+        r = b0 and 0x0F
+        if b0 == 0xE0: lo = 0xA0
+        elif b0 == 0xED: hi = 0x9F
+      elif b0 >= 0xF0 and b0 <= 0xF4:
+        need = 3
+        r = b0 and 0x07
+        if b0 == 0xF0: lo = 0x90
+        elif b0 == 0xF4: hi = 0x8F
+      var ok = need > 0
+      for k in 1..need:
+        if i + k >= s.data.len:
+          ok = false
+          break
+        let b = int32(s.data[i + k])
+        if b < lo or b > hi:
+          ok = false
+          break
+        r = (r shl 6) or (b and 0x3F)
+        lo = 0x80
+        hi = 0xBF
+      if ok:
+        n = need + 1
+      else:
+        r = 0xFFFD
+    data.add(r)
+    i += n
+  newGoSliceFromSeq(data)
+
 # ===========================
 # GoMap Implementation
 # ===========================
@@ -183,6 +318,10 @@ proc delete*[K, V](m: GoMap[K, V], key: K) =
     if m.data.hasKey(key):
       m.data.del(key)
 
+proc clear*[K, V](m: GoMap[K, V]) =
+  withLock(m.lock):
+    m.data.clear()
+
 proc len*[K, V](m: GoMap[K, V]): int =
   withLock(m.lock):
     m.data.len
@@ -257,6 +396,13 @@ proc isClosed*[T](ch: GoChan[T]): bool =
   withLock(ch.lock):
     ch.closed
 
+proc len*[T](ch: GoChan[T]): int =
+  withLock(ch.lock):
+    ch.queue.len
+
+proc cap*[T](ch: GoChan[T]): int =
+  ch.capacity
+
 # ===========================
 # Goroutine Implementation
 # ===========================
@@ -0,0 +1,373 @@
+## Go testing package implementation in Nim
+##
+## The test main synthesized by the frontend in test mode registers the
+## tests, benchmarks, examples and fuzz targets of every package and runs
+## them package by package, the way a go test binary does.
+import std/[algorithm, monotimes, os, posix, strutils, times]
+import ../runtime
+import fmt
+
+type
+  TestFailNow = object of CatchableError
+  TestSkipNow = object of CatchableError
+
+  common = ref object of RootObj
+    name: string
+    failed: bool
+    skipped: bool
+    finished: bool
+    output: seq[string]
+    cleanups: seq[proc()]
+    level: int
+
+  T* = ref object of common
+  B* = ref object of common
+    N*: int
+    timerOn: bool
+    start: MonoTime
+    elapsed: Duration
+  F* = ref object of common
+    seeds: int
+
+  M* = ref object
+    path: string
+    code: int
+
+  TB* = common
+
+  testEntry = object
+    name: string
+    fn: proc(t: T)
+
+  benchmarkEntry = object
+    name: string
+    fn: proc(b: B)
+
+  exampleEntry = object
+    name: string
+    fn: proc()
+    output: string
+    unordered: bool
+
+  fuzzEntry = object
+    name: string
+    fn: proc(f: F)
+
+var
+  tests: seq[testEntry]
+  benchmarks: seq[benchmarkEntry]
+  examples: seq[exampleEntry]
+  fuzzTargets: seq[fuzzEntry]
+  anyFailed = false
+
+proc hasFlag(names: varargs[string]): bool =
+  for i in 1..paramCount():
+    for name in names:
+      if paramStr(i) == name or paramStr(i).startsWith(name & "="):
+        return true
+  false
+
+proc verbose(): bool = hasFlag("-v", "-test.v")
+proc benchEnabled(): bool = hasFlag("-bench", "-test.bench")
+
+proc seconds(d: Duration): string =
+  formatFloat(d.inMicroseconds.float / 1e6, ffDecimal, 2) & "s"
+
+proc indent(c: common): string = repeat("    ", c.level)
+
+# Methods shared by T, B and F
+proc Name*(c: common): string = c.name
+
+proc Log*(c: common, args: varargs[string, `$`]) =
+  c.output.add(args.join(" "))
+
+proc Logf*(c: common, format: string, args: varargs[string, `$`]) =
+  c.output.add(Sprintf(format, args))
+
+proc Fail*(c: common) =
+  c.failed = true
+
+proc Failed*(c: common): bool = c.failed
+
+proc FailNow*(c: common) =
+  c.failed = true
+  c.finished = true
+  raise newException(TestFailNow, c.name)
+
+proc Error*(c: common, args: varargs[string, `$`]) =
+  c.Log(args)
+  c.Fail()
+
+proc Errorf*(c: common, format: string, args: varargs[string, `$`]) =
+  c.output.add(Sprintf(format, args))
+  c.Fail()
+
+proc Fatal*(c: common, args: varargs[string, `$`]) =
+  c.Log(args)
+  c.FailNow()
+
+proc Fatalf*(c: common, format: string, args: varargs[string, `$`]) =
+  c.output.add(Sprintf(format, args))
+  c.FailNow()
+
+proc SkipNow*(c: common) =
+  c.skipped = true
+  c.finished = true
+  raise newException(TestSkipNow, c.name)
+
+proc Skip*(c: common, args: varargs[string, `$`]) =
+  c.Log(args)
+  c.SkipNow()
+
+proc Skipf*(c: common, format: string, args: varargs[string, `$`]) =
+  c.output.add(Sprintf(format, args))
+  c.SkipNow()
+
+proc Skipped*(c: common): bool = c.skipped
+
+proc Helper*(c: common) =
+  discard
+
+proc Cleanup*(c: common, fn: proc()) =
+  c.cleanups.add(fn)
+
+proc TempDir*(c: common): string =
+  result = createTempDir("gonim-test", "")
+  let dir = result
+  c.Cleanup(proc() = removeDir(dir))
+
+proc Setenv*(c: common, key, value: string) =
+  let had = existsEnv(key)
+  let old = getEnv(key)
+  putEnv(key, value)
+  c.Cleanup(proc() =
+    if had: putEnv(key, old) else: delEnv(key))
+
+# run executes body for c, recovering from FailNow, SkipNow and panics, and
+# runs the cleanups in reverse order.
+proc run(c: common, body: proc()) =
+  try:
+    body()
+  except TestFailNow, TestSkipNow:
+    discard
+  except CatchableError as e:
+    c.output.add("panic: " & e.msg)
+    c.failed = true
+  for i in countdown(c.cleanups.high, 0):
+    c.cleanups[i]()
+  c.cleanups.setLen(0)
+  c.finished = true
+
+proc report(c: common, elapsed: Duration) =
+  let status = if c.failed: "FAIL" elif c.skipped: "SKIP" else: "PASS"
+  if c.failed or verbose():
+    echo c.indent() & "--- " & status & ": " & c.name & " (" & seconds(elapsed) & ")"
+    for line in c.output:
+      echo c.indent() & "    " & line
+  if c.failed:
+    anyFailed = true
+
+# T
+proc Parallel*(t: T) =
+  discard
+
+proc Run*(t: T, name: string, fn: proc(t: T)): bool =
+  let sub = T(name: t.name & "/" & name.replace(' ', '_'), level: t.level + 1)
+  if verbose():
+    echo sub.indent() & "=== RUN   " & sub.name
+  let start = getMonoTime()
+  sub.run(proc() = fn(sub))
+  sub.report(getMonoTime() - start)
+  if sub.failed:
+    t.failed = true
+  not sub.failed
+
+# B
+proc StartTimer*(b: B) =
+  if not b.timerOn:
+    b.start = getMonoTime()
+    b.timerOn = true
+
+proc StopTimer*(b: B) =
+  if b.timerOn:
+    b.elapsed += getMonoTime() - b.start
+    b.timerOn = false
+
+proc ResetTimer*(b: B) =
+  if b.timerOn:
+    b.start = getMonoTime()
+  b.elapsed = DurationZero
+
+proc ReportAllocs*(b: B) =
+  discard
+
+proc SetBytes*(b: B, n: int64) =
+  discard
+
+proc Elapsed*(b: B): Duration =
+  result = b.elapsed
+  if b.timerOn:
+    result += getMonoTime() - b.start
+
+proc runN(b: B, fn: proc(b: B), n: int) =
+  b.N = n
+  b.elapsed = DurationZero
+  b.timerOn = false
+  b.StartTimer()
+  b.run(proc() = fn(b))
+  b.StopTimer()
+
+proc Run*(b: B, name: string, fn: proc(b: B)): bool =
+  let sub = B(name: b.name & "/" & name.replace(' ', '_'), level: b.level + 1)
+  sub.runN(fn, 1)
+  if sub.failed:
+    b.failed = true
+  not sub.failed
+
+# F
+proc Add*(f: F, args: varargs[string, `$`]) =
+  inc f.seeds
+
+proc Fuzz*[Fn](f: F, fn: Fn) =
+  f.Skipf("fuzzing is not supported; %d seed inputs not run", $f.seeds)
+
+# Runners called by the synthesized test main
+proc registerTest*(name: string, fn: proc(t: T)) =
+  tests.add(testEntry(name: name, fn: fn))
+
+proc registerBenchmark*(name: string, fn: proc(b: B)) =
+  benchmarks.add(benchmarkEntry(name: name, fn: fn))
+
+proc registerExample*(name: string, fn: proc(), output: string, unordered: bool) =
+  examples.add(exampleEntry(name: name, fn: fn, output: output, unordered: unordered))
+
+proc registerFuzz*(name: string, fn: proc(f: F)) =
+  fuzzTargets.add(fuzzEntry(name: name, fn: fn))
+
+proc runTestCase(name: string, fn: proc(t: T)): bool =
+  let t = T(name: name)
+  if verbose():
+    echo "=== RUN   " & name
+  let start = getMonoTime()
+  t.run(proc() = fn(t))
+  t.report(getMonoTime() - start)
+  not t.failed
+
+# captureOutput runs fn with the standard output redirected to a temporary
+# file and returns what it wrote.
+proc captureOutput(fn: proc()): string =
+  flushFile(stdout)
+  let (file, path) = createTempFile("gonim-example", "")
+  let saved = dup(STDOUT_FILENO)
+  discard dup2(getFileHandle(file), STDOUT_FILENO)
+  try:
+    fn()
+  finally:
+    flushFile(stdout)
+    discard dup2(saved, STDOUT_FILENO)
+    discard close(saved)
+    close(file)
+  result = readFile(path)
+  removeFile(path)
+
+proc normalizeOutput(s: string, unordered: bool): string =
+  var lines: seq[string]
+  for line in s.strip().splitLines():
+    lines.add(line.strip(leading = false))
+  if unordered:
+    lines.sort()
+  lines.join("\n")
+
+proc runExampleCase(e: exampleEntry): bool =
+  if verbose():
+    echo "=== RUN   " & e.name
+  let start = getMonoTime()
+  var got = ""
+  var panicked = ""
+  try:
+    got = captureOutput(e.fn)
+  except CatchableError as ex:
+    panicked = ex.msg
+  let elapsed = getMonoTime() - start
+  let ok = panicked.len == 0 and
+    normalizeOutput(got, e.unordered) == normalizeOutput(e.output, e.unordered)
+  if not ok:
+    echo "--- FAIL: " & e.name & " (" & seconds(elapsed) & ")"
+    if panicked.len > 0:
+      echo "panic: " & panicked
+    echo "got:"
+    echo got.strip()
+    echo "want:"
+    echo e.output.strip()
+    anyFailed = true
+  elif verbose():
+    echo "--- PASS: " & e.name & " (" & seconds(elapsed) & ")"
+  ok
+
+proc runBenchmarkCase(e: benchmarkEntry) =
+  let b = B(name: e.name)
+  var n = 1
+  while true:
+    b.runN(e.fn, n)
+    if b.failed or b.skipped or b.elapsed >= initDuration(seconds = 1) or n >= 1_000_000_000:
+      break
+    n = min(n * 10, 1_000_000_000)
+  if b.failed or b.skipped:
+    b.report(b.elapsed)
+    return
+  let nsPerOp = b.elapsed.inNanoseconds.float / b.N.float
+  echo alignLeft(e.name, 24) & align($b.N, 12) & "\t" & formatFloat(nsPerOp, ffDecimal, 1) & " ns/op"
+
+proc runFuzzCase(e: fuzzEntry): bool =
+  let f = F(name: e.name)
+  if verbose():
+    echo "=== RUN   " & e.name
+  let start = getMonoTime()
+  f.run(proc() = e.fn(f))
+  f.report(getMonoTime() - start)
+  not f.failed
+
+proc Run*(m: M): int =
+  var ok = true
+  for t in tests:
+    ok = runTestCase(t.name, t.fn) and ok
+  for f in fuzzTargets:
+    ok = runFuzzCase(f) and ok
+  for e in examples:
+    ok = runExampleCase(e) and ok
+  if ok and benchEnabled():
+    for b in benchmarks:
+      runBenchmarkCase(b)
+  m.code = if ok: 0 else: 1
+  m.code
+
+proc finishPackage(path: string, code: int, elapsed: Duration) =
+  if code == 0:
+    if verbose():
+      echo "PASS"
+    echo "ok  \t" & path & "\t" & seconds(elapsed)
+  else:
+    echo "FAIL"
+    echo "FAIL\t" & path & "\t" & seconds(elapsed)
+    anyFailed = true
+  tests.setLen(0)
+  benchmarks.setLen(0)
+  examples.setLen(0)
+  fuzzTargets.setLen(0)
+
+proc runPackage*(path: string) =
+  let start = getMonoTime()
+  let m = M(path: path)
+  finishPackage(path, m.Run(), getMonoTime() - start)
+
+proc runTestMain*(path: string, fn: proc(m: M)) =
+  let start = getMonoTime()
+  let m = M(path: path, code: -1)
+  fn(m)
+  if m.code == -1:
+    # TestMain returned without calling m.Run
+    m.code = 0
+  finishPackage(path, m.code, getMonoTime() - start)
+
+proc exitTests*() =
+  quit(if anyFailed: 1 else: 0)